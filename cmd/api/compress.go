@@ -0,0 +1,284 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	// below this many bytes the encoding headers cost about what compression saves
+	minCompressSize = 1024
+	// brotli's default level is slow enough to show up in request latency
+	brotliLevel = 4
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}}
+	brotliWriters = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}}
+)
+
+// content encodings we can produce, preferred in this order when a client weighs them the same
+var supportedEncodings = []string{"br", "gzip"}
+
+// compresses response bodies with brotli or gzip, whichever Accept-Encoding prefers.
+// Bodies under minCompressSize, range and no-content responses and content that is
+// already compressed (images, archives) pass through untouched
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// whether or not this response is compressed, another client's might be
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// the supported encoding with the highest q value, or "" for identity
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			coding, params = part[:i], part[i+1:]
+		}
+
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		weights[strings.ToLower(strings.TrimSpace(coding))] = q
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, encoding := range supportedEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q = weights["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// holds back the first minCompressSize bytes so tiny bodies can go out as they
+// are, then commits to compressing or not for the rest of the response
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	status int
+	buf []byte
+	decided bool
+	enc interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+
+	cw.status = status
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < minCompressSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// a flush means the handler is streaming, so whatever has been buffered is
+// compressed without waiting for minCompressSize
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		if cw.decide(true) != nil {
+			return
+		}
+	}
+
+	if cw.enc != nil && cw.enc.Flush() != nil {
+		return
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// sends the header and the buffered bytes, through an encoder when large is set
+// and the response is worth compressing
+func (cw *compressResponseWriter) decide(large bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+
+	if large && cw.compressible() {
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+
+		// the encoded bytes are a representation of their own
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodedETag(etag, cw.encoding))
+		}
+
+		switch cw.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.enc = bw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+func (cw *compressResponseWriter) compressible() bool {
+	h := cw.Header()
+
+	switch {
+	case h.Get("Content-Encoding") != "":
+		return false
+	case h.Get("Content-Range") != "":
+		return false
+	case cw.status == http.StatusNoContent, cw.status == http.StatusNotModified, cw.status == http.StatusPartialContent:
+		return false
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf)
+	}
+
+	return !alreadyCompressed(contentType)
+}
+
+// formats that gain nothing from a second round of compression
+func alreadyCompressed(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+
+	for _, prefix := range []string{"image/", "video/", "audio/", "font/woff"} {
+		if strings.HasPrefix(contentType, prefix) {
+			// svg is text
+			return !strings.HasPrefix(contentType, "image/svg+xml")
+		}
+	}
+
+	for _, prefix := range []string{"application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/x-brotli"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// finishes the response once the handler returns, a body that never reached
+// minCompressSize goes out uncompressed
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+
+		cw.decide(false)
+	}
+
+	if cw.enc == nil {
+		return
+	}
+
+	cw.enc.Close()
+
+	switch enc := cw.enc.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(enc)
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the ETag of a record with a version column as r will get it, e.g. "3-json".
+// It changes with every update and names the format too, since each format
+// is different bytes. The compress middleware adds the content coding
+func (app *application) versionETag(r *http.Request, version int) string {
+	format, ok := negotiateFormat(r.Header.Get("Accept"), false)
+	if !ok {
+		format = mediaTypeJSON
+	}
+
+	var representation string
+
+	switch {
+	case format == mediaTypeMsgPack:
+		representation = "msgpack"
+	case app.prettyJSON(r):
+		representation = "json-pretty"
+	default:
+		representation = "json"
+	}
+
+	return `"` + strconv.Itoa(version) + "-" + representation + `"`
+}
+
+// the ETag of a rendered body, for responses that aren't a single versioned record
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// the tag of etag's representation once it is encoded, "3-json" sent with
+// gzip becomes "3-json-gzip"
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// the tag of the representation before encoding, so a tag a client got with a
+// compressed body still matches when this response isn't compressed
+func unencodedETag(etag string) string {
+	for _, encoding := range supportedEncodings {
+		if suffix := "-" + encoding + `"`; strings.HasSuffix(etag, suffix) {
+			return strings.TrimSuffix(etag, suffix) + `"`
+		}
+	}
+
+	return etag
+}
+
+// finds etag in an If-Match or If-None-Match list and returns the entry that
+// matched. "*" matches anything. Weak entries only match when weak is set, as
+// If-None-Match allows; If-Match compares strongly
+func etagListMatches(list, etag string, weak bool) (string, bool) {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return candidate, true
+		}
+
+		tag := candidate
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if unencodedETag(tag) == unencodedETag(etag) {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// writes updates and deletes only against the version the client last saw,
+// sending 428 when the request has no If-Match and 412 when it is stale. The
+// tag is compared strongly, so it has to be one fetched in the same format
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch == "" {
+		app.preconditionRequiredResponse(w, r)
+		return false
+	}
+
+	if _, ok := etagListMatches(ifMatch, etag, false); !ok {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVersionETag(t *testing.T) {
+	app := &application{}
+	app.config.env = "production"
+
+	tests := []struct {
+		target 	string
+		accept 	string
+		want 	string
+	}{
+		{"/v1/pizzas/1", "", `"3-json"`},
+		{"/v1/pizzas/1", "application/json", `"3-json"`},
+		{"/v1/pizzas/1?pretty=true", "", `"3-json-pretty"`},
+		{"/v1/pizzas/1", "application/x-msgpack", `"3-msgpack"`},
+		{"/v1/pizzas/1", "image/png", `"3-json"`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Header.Set("Accept", tt.accept)
+
+		if got := app.versionETag(r, 3); got != tt.want {
+			t.Errorf("%s with Accept %q: got %s, want %s", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestETagListMatches(t *testing.T) {
+	tests := []struct {
+		name 	string
+		list 	string
+		weak 	bool
+		want 	string
+	}{
+		{name: "exact", list: `"3-json"`, want: `"3-json"`},
+		{name: "among others", list: `"2-json", "3-json"`, want: `"3-json"`},
+		{name: "any", list: `*`, want: `*`},
+		{name: "other version", list: `"2-json"`},
+		{name: "other format", list: `"3-msgpack"`},
+		{name: "fetched compressed", list: `"3-json-gzip"`, want: `"3-json-gzip"`},
+		{name: "fetched with brotli", list: `"3-json-br"`, want: `"3-json-br"`},
+		{name: "weak under strong comparison", list: `W/"3-json"`},
+		{name: "weak under weak comparison", list: `W/"3-json"`, weak: true, want: `W/"3-json"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := etagListMatches(tt.list, `"3-json"`, tt.weak)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q, %t, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodedETag(t *testing.T) {
+	got := encodedETag(`"3-json"`, "gzip")
+	if got != `"3-json-gzip"` {
+		t.Errorf("got %s, want \"3-json-gzip\"", got)
+	}
+
+	if back := unencodedETag(got); back != `"3-json"` {
+		t.Errorf("got %s back, want \"3-json\"", back)
+	}
+}
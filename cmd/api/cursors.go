@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+// how many rows a page holds when the client doesn't say
+const defaultPageSize = 20
+
+// a cursor is its JSON and a signature over the JSON and the list it came
+// from, so clients can't forge a position or carry one over to another list.
+// The scope names the list, e.g. "reviews" or "opinions:12"
+func (app *application) encodeCursor(scope string, cursor *data.Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+
+	js, err := json.Marshal(cursor)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js) + "." + app.cursorSignature(scope, js)
+}
+
+// nil when s wasn't handed out for scope or has been tampered with
+func (app *application) decodeCursor(scope, s string) *data.Cursor {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(s[:i])
+	if err != nil {
+		return nil
+	}
+
+	if !hmac.Equal([]byte(s[i+1:]), []byte(app.cursorSignature(scope, js))) {
+		return nil
+	}
+
+	var cursor data.Cursor
+
+	err = json.Unmarshal(js, &cursor)
+	if err != nil {
+		return nil
+	}
+
+	return &cursor
+}
+
+func (app *application) cursorSignature(scope string, js []byte) string {
+	mac := hmac.New(sha256.New, []byte(app.config.cursors.signingKey))
+	mac.Write([]byte(scope + "\n"))
+	mac.Write(js)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// reads how the client wants a list paged: by page and page_size, or by limit
+// and an after or before cursor. ok is false when the query string asks for
+// neither, lists then come back whole the way they always have
+func (app *application) readFilters(qs url.Values, scope, defaultSort string, safelist []string, v *validator.Validator) (f data.Filters, ok bool) {
+	paged := qs.Get("page") != "" || qs.Get("page_size") != ""
+	cursored := qs.Get("after") != "" || qs.Get("before") != "" || qs.Get("limit") != ""
+
+	f.SortSafelist = safelist
+
+	switch {
+	case paged && cursored:
+		v.AddError("page", "cannot be used together with after, before or limit")
+		return f, true
+	case paged:
+		f.Page = app.readInt(qs, "page", 1, v)
+		f.PageSize = app.readInt(qs, "page_size", defaultPageSize, v)
+		f.Sort = app.readString(qs, "sort", defaultSort)
+
+		data.ValidateFilters(v, f)
+
+		return f, true
+	case cursored:
+		f.PageSize = app.readInt(qs, "limit", defaultPageSize, v)
+
+		for key, cursor := range map[string]**data.Cursor{"after": &f.After, "before": &f.Before} {
+			s := qs.Get(key)
+			if s == "" {
+				continue
+			}
+
+			*cursor = app.decodeCursor(scope, s)
+			v.Check(*cursor != nil, key, "is not a valid cursor")
+		}
+
+		// a cursor carries on in the order it was made for
+		f.Sort = defaultSort
+		for _, cursor := range []*data.Cursor{f.After, f.Before} {
+			if cursor != nil {
+				f.Sort = cursor.Sort
+			}
+		}
+		f.Sort = app.readString(qs, "sort", f.Sort)
+
+		data.ValidateCursorFilters(v, f)
+
+		return f, true
+	}
+
+	return f, false
+}
+
+// fills in the cursors the client sends back for the next and previous pages
+func (app *application) signMetadata(scope string, metadata data.Metadata) data.Metadata {
+	metadata.NextCursor = app.encodeCursor(scope, metadata.Next)
+	metadata.PrevCursor = app.encodeCursor(scope, metadata.Prev)
+
+	return metadata
+}
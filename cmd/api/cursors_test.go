@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tclohm/project-pizza/internal/data"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	app := &application{}
+	app.config.cursors.signingKey = "secret"
+
+	cursor := &data.Cursor{Sort: "-price", Key: "12.5", ID: 7}
+
+	got := app.decodeCursor("reviews", app.encodeCursor("reviews", cursor))
+	if !reflect.DeepEqual(got, cursor) {
+		t.Errorf("got %+v, want %+v", got, cursor)
+	}
+
+	if s := app.encodeCursor("reviews", nil); s != "" {
+		t.Errorf("got %q for no cursor, want \"\"", s)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	app := &application{}
+	app.config.cursors.signingKey = "secret"
+
+	s := app.encodeCursor("opinions:12", &data.Cursor{Sort: "name", Key: "Lucali", ID: 7})
+	payload, signature := s[:strings.IndexByte(s, '.')], s[strings.IndexByte(s, '.')+1:]
+
+	flipped := "A"
+	if strings.HasPrefix(signature, "A") {
+		flipped = "B"
+	}
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"name","k":"Lucali","i":1}`))
+
+	otherKey := &application{}
+	otherKey.config.cursors.signingKey = "other"
+
+	tests := []struct {
+		name 	string
+		app 	*application
+		scope 	string
+		cursor 	string
+	}{
+		{"another list", app, "opinions:13", s},
+		{"another key", otherKey, "opinions:12", s},
+		{"tampered payload", app, "opinions:12", forged + "." + signature},
+		{"tampered signature", app, "opinions:12", payload + "." + flipped + signature[1:]},
+		{"no signature", app, "opinions:12", payload},
+		{"not base64", app, "opinions:12", "!!." + signature},
+		{"empty", app, "opinions:12", ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.app.decodeCursor(tt.scope, tt.cursor); got != nil {
+			t.Errorf("%s: got %+v, want nil", tt.name, got)
+		}
+	}
+}
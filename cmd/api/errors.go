@@ -5,17 +5,25 @@ import (
 	"net/http"
 )
 
+// the request id comes along through the context, the route is whatever mux
+// matched further down the chain, if it got that far
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url": r.URL.String(),
-	})
+	}
+
+	if route, ok := r.Context().Value(routeContextKey).(*matchedRoute); ok {
+		properties["route"] = route.template
+	}
+
+	app.logger.PrintErrorContext(r.Context(), err, properties)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -52,7 +60,53 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since it was fetched, fetch it again before retrying"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request must send the resource's ETag in an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) invalidSignedURLResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the signed url is invalid or has expired"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, contentType string) {
+	message := fmt.Sprintf("the request body must be sent as %s", contentType)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource can be sent as application/json, application/msgpack, or text/csv for lists"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+func (app *application) requestTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request body is too large"
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) tusVersionMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	message := fmt.Sprintf("the Tus-Resumable header must be %s", tusVersion)
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) uploadOffsetConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Upload-Offset header does not match the current upload offset"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) uploadBusyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "another request is writing to this upload, ask for its offset again once it finishes"
+	app.errorResponse(w, r, http.StatusConflict, message)
 }
\ No newline at end of file
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+const (
+	// how long a single export may stream for, it is exempt from the request budget
+	exportTimeout = 10 * time.Minute
+	// rows between flushes so a slow client sees progress without a syscall per row
+	exportFlushEvery = 500
+)
+
+var exportColumns = []string{
+	"id",
+	"created_at",
+	"style",
+	"price",
+	"cheesiness",
+	"flavor",
+	"sauciness",
+	"saltiness",
+	"charness",
+	"spiciness",
+	"conclusion",
+	"pizza_id",
+	"pizza_name",
+	"venue_id",
+	"venue_name",
+	"venue_address",
+	"lat",
+	"lon",
+}
+
+// streams every review joined with its pizza and venue straight from the
+// cursor to the client, nothing is buffered beyond the current row
+func (app *application) exportReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	format := app.readString(qs, "format", "csv")
+	filter := data.ReviewExportFilter{
+		From: app.readTime(qs, "from", v),
+		To: app.readTime(qs, "to", v),
+	}
+
+	v.Check(validator.In(format, "csv", "ndjson"), "format", "must be csv or ndjson")
+	v.Check(filter.From.IsZero() || filter.To.IsZero() || !filter.From.After(filter.To), "from", "must not be after to")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), exportTimeout)
+	defer cancel()
+
+	// the server's write timeout is sized for ordinary responses
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(exportTimeout))
+
+	var write func(*data.ReviewExport) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="reviews.csv"`)
+
+		cw := csv.NewWriter(w)
+		record := make([]string, len(exportColumns))
+
+		// the header waits for the first row so a failing query can still answer with a 500,
+		// an export that matched nothing gets it on the final flush
+		wroteHeader := false
+		writeHeader := func() error {
+			if wroteHeader {
+				return nil
+			}
+			wroteHeader = true
+			return cw.Write(exportColumns)
+		}
+
+		write = func(export *data.ReviewExport) error {
+			err := writeHeader()
+			if err != nil {
+				return err
+			}
+			return cw.Write(exportRecord(record, export))
+		}
+		flush = func() error {
+			err := writeHeader()
+			if err != nil {
+				return err
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="reviews.ndjson"`)
+
+		enc := json.NewEncoder(w)
+
+		write = func(export *data.ReviewExport) error {
+			return enc.Encode(export)
+		}
+		flush = func() error {
+			return nil
+		}
+	}
+
+	rows := 0
+
+	err := app.models.Reviews.Export(ctx, filter, func(export *data.ReviewExport) error {
+		err := write(export)
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			rc.Flush()
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		// nothing has gone out yet, the client can still get a proper error
+		if rows == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// fills record in place, missing pizza and venue fields are left empty
+func exportRecord(record []string, export *data.ReviewExport) []string {
+	for i := range record {
+		record[i] = ""
+	}
+
+	record[0] = strconv.FormatInt(export.ID, 10)
+	record[1] = export.CreatedAt.Format(time.RFC3339)
+	record[2] = export.Style
+	record[3] = formatScore(export.Price)
+	record[4] = formatScore(export.Cheesiness)
+	record[5] = formatScore(export.Flavor)
+	record[6] = formatScore(export.Sauciness)
+	record[7] = formatScore(export.Saltiness)
+	record[8] = formatScore(export.Charness)
+	record[9] = formatScore(export.Spiciness)
+	record[10] = export.Conclusion
+
+	if export.PizzaID != nil {
+		record[11] = strconv.FormatInt(*export.PizzaID, 10)
+		record[12] = *export.PizzaName
+	}
+
+	if export.VenueID != nil {
+		record[13] = strconv.FormatInt(*export.VenueID, 10)
+		record[14] = *export.VenueName
+		record[15] = *export.VenueAddress
+		record[16] = strconv.FormatFloat(*export.Lat, 'f', -1, 64)
+		record[17] = strconv.FormatFloat(*export.Lon, 'f', -1, 64)
+	}
+
+	return record
+}
+
+func formatScore(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+)
+
+// starts the orphaned image sweeper in the background, the returned func
+// stops it and blocks until any in-flight sweep has wound down
+func (app *application) startImageSweeper() func() {
+	if app.config.gc.interval == 0 {
+		return func() {}
+	}
+
+	// cancelling the context aborts a sweep's queries rather than holding up shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(app.config.gc.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				app.sweepOrphanedImages(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// removes images nobody references once they are older than the grace period,
+// the grace period gives clients time to attach a freshly uploaded image to a review
+func (app *application) sweepOrphanedImages(ctx context.Context) {
+	// a panic in here would take down the whole server, log it instead
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	// a sweep is one big background request, give it a budget of its own
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-app.config.gc.grace)
+
+	images, err := app.models.Images.GetOrphaned(ctx, cutoff)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"task": "image_gc",
+		})
+		return
+	}
+
+	deleted := 0
+
+	for _, image := range images {
+		// shutting down
+		if ctx.Err() != nil {
+			break
+		}
+
+		properties := map[string]string{
+			"task": "image_gc",
+			"image_id": strconv.FormatInt(image.ID, 10),
+			"location": image.Location,
+		}
+
+		if app.config.gc.dryRun {
+			app.logger.PrintInfo("would delete orphaned image", properties)
+			continue
+		}
+
+		// drop the row first so a failure here never leaves a row pointing at a missing file
+		err := app.models.Images.DeleteOrphaned(ctx, image.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				// attached to a review or already removed since we looked
+			default:
+				app.logger.PrintError(err, properties)
+			}
+			continue
+		}
+
+		err = os.Remove(imagePath(image))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.PrintError(err, properties)
+		}
+
+		deleted++
+	}
+
+	app.logger.PrintInfo("orphaned image sweep finished", map[string]string{
+		"task": "image_gc",
+		"found": strconv.Itoa(len(images)),
+		"deleted": strconv.Itoa(deleted),
+		"dry_run": strconv.FormatBool(app.config.gc.dryRun),
+	})
+}
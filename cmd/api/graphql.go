@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/graphql"
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+const graphRequestContextKey = contextKey("graph")
+
+// what a list field is costed as under -graphql-max-cost, venues(limit) can hold more
+const graphQLListSize = 10
+
+// the per-request state resolvers share. The loaders live as long as the
+// request so each venue, pizza or review is fetched once however often the
+// query mentions it
+type graphRequest struct {
+	r 				*http.Request
+	venues 			*graphql.Loader
+	pizzas 			*graphql.Loader
+	reviews 		*graphql.Loader
+	venuePizzas 	*graphql.Loader
+	pizzaVenues 	*graphql.Loader
+	reviewPizzas 	*graphql.Loader
+	venueScores 	*graphql.Loader
+}
+
+func (app *application) newGraphRequest(r *http.Request) *graphRequest {
+	ctx := r.Context()
+
+	return &graphRequest{
+		r: r,
+		venues: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			venues, err := app.models.Graph.VenuesByID(ctx, int64Keys(keys))
+			out := map[interface{}]interface{}{}
+			for id, venue := range venues {
+				out[id] = venue
+			}
+			return out, err
+		}),
+		pizzas: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			pizzas, err := app.models.Graph.PizzasByID(ctx, int64Keys(keys))
+			out := map[interface{}]interface{}{}
+			for id, pizza := range pizzas {
+				out[id] = pizza
+			}
+			return out, err
+		}),
+		reviews: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			reviews, err := app.models.Graph.ReviewsByID(ctx, int64Keys(keys))
+			out := map[interface{}]interface{}{}
+			for id, review := range reviews {
+				out[id] = review
+			}
+			return out, err
+		}),
+		venuePizzas: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			ids := int64Keys(keys)
+			pizzas, err := app.models.Graph.PizzasByVenue(ctx, ids)
+			return pizzaLists(ids, pizzas), err
+		}),
+		reviewPizzas: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			ids := int64Keys(keys)
+			pizzas, err := app.models.Graph.PizzasByReview(ctx, ids)
+			return pizzaLists(ids, pizzas), err
+		}),
+		pizzaVenues: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			ids := int64Keys(keys)
+			venues, err := app.models.Graph.VenuesByPizza(ctx, ids)
+			out := map[interface{}]interface{}{}
+			for _, id := range ids {
+				// an empty list rather than null for pizzas no venue serves
+				list := venues[id]
+				if list == nil {
+					list = []*data.Venue{}
+				}
+				out[id] = list
+			}
+			return out, err
+		}),
+		venueScores: graphql.NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			scores, err := app.models.Graph.Scores(ctx, int64Keys(keys))
+			out := map[interface{}]interface{}{}
+			for id, s := range scores {
+				out[id] = s
+			}
+			return out, err
+		}),
+	}
+}
+
+func graphRequestFrom(ctx context.Context) *graphRequest {
+	return ctx.Value(graphRequestContextKey).(*graphRequest)
+}
+
+// logs err against the request and hands the client the same vague message a
+// 500 gets, the details of a failed query are none of its business
+func (gr *graphRequest) serverError(app *application, err error) error {
+	app.logError(gr.r, err)
+	return errors.New("the server encountered a problem and could not resolve this field")
+}
+
+func int64Keys(keys []interface{}) []int64 {
+	ids := make([]int64, len(keys))
+	for i, key := range keys {
+		ids[i] = key.(int64)
+	}
+	return ids
+}
+
+func pizzaLists(ids []int64, pizzas map[int64][]*data.Pizza) map[interface{}]interface{} {
+	out := map[interface{}]interface{}{}
+	for _, id := range ids {
+		list := pizzas[id]
+		if list == nil {
+			list = []*data.Pizza{}
+		}
+		out[id] = list
+	}
+	return out
+}
+
+// IDs arrive as strings, whatever the client sent
+func parseID(v interface{}) (int64, error) {
+	id, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid ID %q", v)
+	}
+	return id, nil
+}
+
+// the validator's errors in one message, sorted so the message is stable
+func validationError(errs map[string]string) error {
+	messages := make([]string, 0, len(errs))
+	for key, message := range errs {
+		messages = append(messages, key+" "+message)
+	}
+
+	sort.Strings(messages)
+
+	return fmt.Errorf("failed validation: %s", strings.Join(messages, "; "))
+}
+
+// resolves a field from a loader keyed by one of the parent's ids
+func (app *application) loadResolver(loader func(gr *graphRequest) *graphql.Loader, key func(source interface{}) int64) graphql.ResolveFunc {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		gr := graphRequestFrom(p.Context)
+		thunk := loader(gr).Load(key(p.Source))
+
+		return graphql.Thunk(func() (interface{}, error) {
+			value, err := thunk()
+			if err != nil {
+				return nil, gr.serverError(app, err)
+			}
+			return value, nil
+		}), nil
+	}
+}
+
+func (app *application) graphQLSchema() (*graphql.Schema, error) {
+	scoresType := &graphql.Object{
+		Name: "Scores",
+		Fields: graphql.Fields{
+			"reviews": {Type: graphql.NonNullOf(graphql.Int)},
+			"price": {Type: graphql.Float},
+			"cheesiness": {Type: graphql.Float},
+			"flavor": {Type: graphql.Float},
+			"sauciness": {Type: graphql.Float},
+			"saltiness": {Type: graphql.Float},
+			"charness": {Type: graphql.Float},
+			"spiciness": {Type: graphql.Float},
+		},
+	}
+
+	imageType := &graphql.Object{
+		Name: "Image",
+		Fields: graphql.Fields{
+			"id": {
+				Type: graphql.NonNullOf(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ReviewImage).ImageID, nil
+				},
+			},
+			"position": {Type: graphql.NonNullOf(graphql.Int)},
+			"caption": {Type: graphql.NonNullOf(graphql.String)},
+			"filename": {Type: graphql.String},
+			"contentType": {Type: graphql.String},
+			"url": {
+				Type: graphql.NonNullOf(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return fmt.Sprintf("/v1/images/%d", p.Source.(*data.ReviewImage).ImageID), nil
+				},
+			},
+		},
+	}
+
+	venueType := &graphql.Object{Name: "Venue"}
+	pizzaType := &graphql.Object{Name: "Pizza"}
+	reviewType := &graphql.Object{Name: "Review"}
+
+	venueType.Fields = graphql.Fields{
+		"id": {Type: graphql.NonNullOf(graphql.ID)},
+		"name": {Type: graphql.NonNullOf(graphql.String)},
+		"lat": {Type: graphql.NonNullOf(graphql.Float)},
+		"lon": {Type: graphql.NonNullOf(graphql.Float)},
+		"address": {Type: graphql.NonNullOf(graphql.String)},
+		"pizzas": {
+			Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(pizzaType))),
+			Resolve: app.loadResolver(
+				func(gr *graphRequest) *graphql.Loader { return gr.venuePizzas },
+				func(source interface{}) int64 { return source.(*data.Venue).ID },
+			),
+		},
+		"scores": {
+			Type: graphql.NonNullOf(scoresType),
+			Resolve: app.loadResolver(
+				func(gr *graphRequest) *graphql.Loader { return gr.venueScores },
+				func(source interface{}) int64 { return source.(*data.Venue).ID },
+			),
+		},
+	}
+
+	pizzaType.Fields = graphql.Fields{
+		"id": {Type: graphql.NonNullOf(graphql.ID)},
+		"name": {Type: graphql.NonNullOf(graphql.String)},
+		"review": {
+			Type: reviewType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if p.Source.(*data.Pizza).ReviewId == 0 {
+					return nil, nil
+				}
+				return app.loadResolver(
+					func(gr *graphRequest) *graphql.Loader { return gr.reviews },
+					func(source interface{}) int64 { return source.(*data.Pizza).ReviewId },
+				)(p)
+			},
+		},
+		"venues": {
+			Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(venueType))),
+			Resolve: app.loadResolver(
+				func(gr *graphRequest) *graphql.Loader { return gr.pizzaVenues },
+				func(source interface{}) int64 { return source.(*data.Pizza).ID },
+			),
+		},
+	}
+
+	reviewType.Fields = graphql.Fields{
+		"id": {Type: graphql.NonNullOf(graphql.ID)},
+		"style": {Type: graphql.NonNullOf(graphql.String)},
+		"price": {Type: graphql.NonNullOf(graphql.Float)},
+		"cheesiness": {Type: graphql.NonNullOf(graphql.Float)},
+		"flavor": {Type: graphql.NonNullOf(graphql.Float)},
+		"sauciness": {Type: graphql.NonNullOf(graphql.Float)},
+		"saltiness": {Type: graphql.NonNullOf(graphql.Float)},
+		"charness": {Type: graphql.NonNullOf(graphql.Float)},
+		"spiciness": {Type: graphql.NonNullOf(graphql.Float)},
+		"conclusion": {Type: graphql.NonNullOf(graphql.String)},
+		"createdAt": {
+			Type: graphql.NonNullOf(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*data.Review).CreatedAt.UTC().Format(time.RFC3339), nil
+			},
+		},
+		"images": {
+			Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(imageType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				review := p.Source.(*data.Review)
+				if review.Images != nil {
+					return review.Images, nil
+				}
+
+				// the review list doesn't carry photos, the batched lookup does
+				thunk, err := app.loadResolver(
+					func(gr *graphRequest) *graphql.Loader { return gr.reviews },
+					func(source interface{}) int64 { return review.ID },
+				)(p)
+				if err != nil {
+					return nil, err
+				}
+
+				return graphql.Thunk(func() (interface{}, error) {
+					loaded, err := thunk.(graphql.Thunk)()
+					if err != nil || loaded == nil {
+						return []*data.ReviewImage{}, err
+					}
+					return loaded.(*data.Review).Images, nil
+				}), nil
+			},
+		},
+		"pizzas": {
+			Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(pizzaType))),
+			Resolve: app.loadResolver(
+				func(gr *graphRequest) *graphql.Loader { return gr.reviewPizzas },
+				func(source interface{}) int64 { return source.(*data.Review).ID },
+			),
+		},
+	}
+
+	// a root field fetching one record by its id argument
+	byID := func(loader func(gr *graphRequest) *graphql.Loader) graphql.ResolveFunc {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			id, err := parseID(p.Args["id"])
+			if err != nil {
+				return nil, err
+			}
+			return app.loadResolver(loader, func(interface{}) int64 { return id })(p)
+		}
+	}
+
+	float := graphql.NonNullOf(graphql.Float)
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"venues": {
+				Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(venueType))),
+				Args: graphql.Args{
+					"name": {Type: graphql.String},
+					"near": {Type: &graphql.InputObject{
+						Name: "NearInput",
+						Fields: graphql.Args{
+							"lat": {Type: float},
+							"lon": {Type: float},
+							"radiusKm": {Type: float},
+						},
+					}},
+					"bounds": {Type: &graphql.InputObject{
+						Name: "BoundsInput",
+						Fields: graphql.Args{
+							"south": {Type: float},
+							"west": {Type: float},
+							"north": {Type: float},
+							"east": {Type: float},
+						},
+					}},
+					"limit": {Type: graphql.Int, Default: 50},
+				},
+				Resolve: app.resolveVenues,
+			},
+			"venue": {
+				Type: venueType,
+				Args: graphql.Args{"id": {Type: graphql.NonNullOf(graphql.ID)}},
+				Resolve: byID(func(gr *graphRequest) *graphql.Loader { return gr.venues }),
+			},
+			"pizzas": {
+				Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(pizzaType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					pizzas, err := app.models.Pizzas.GetAll(p.Context)
+					if err != nil {
+						return nil, graphRequestFrom(p.Context).serverError(app, err)
+					}
+					return pizzas, nil
+				},
+			},
+			"pizza": {
+				Type: pizzaType,
+				Args: graphql.Args{"id": {Type: graphql.NonNullOf(graphql.ID)}},
+				Resolve: byID(func(gr *graphRequest) *graphql.Loader { return gr.pizzas }),
+			},
+			"reviews": {
+				Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(reviewType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					reviews, err := app.models.Reviews.GetAll(p.Context)
+					if err != nil {
+						return nil, graphRequestFrom(p.Context).serverError(app, err)
+					}
+					return reviews, nil
+				},
+			},
+			"review": {
+				Type: reviewType,
+				Args: graphql.Args{"id": {Type: graphql.NonNullOf(graphql.ID)}},
+				Resolve: byID(func(gr *graphRequest) *graphql.Loader { return gr.reviews }),
+			},
+			"scores": {
+				Type: graphql.NonNullOf(scoresType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					scores, err := app.models.Graph.Scores(p.Context, nil)
+					if err != nil {
+						return nil, graphRequestFrom(p.Context).serverError(app, err)
+					}
+					return scores[0], nil
+				},
+			},
+		},
+	}
+
+	mutation := &graphql.Object{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createReview": {
+				Type: graphql.NonNullOf(reviewType),
+				Args: graphql.Args{
+					"input": {Type: graphql.NonNullOf(&graphql.InputObject{
+						Name: "ReviewInput",
+						Fields: graphql.Args{
+							"style": {Type: graphql.NonNullOf(graphql.String)},
+							"price": {Type: float},
+							"cheesiness": {Type: float},
+							"flavor": {Type: float},
+							"sauciness": {Type: float},
+							"saltiness": {Type: float},
+							"charness": {Type: float},
+							"spiciness": {Type: float},
+							"conclusion": {Type: graphql.NonNullOf(graphql.String)},
+							"imageIds": {Type: graphql.ListOf(graphql.NonNullOf(graphql.ID))},
+							"captions": {Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+						},
+					})},
+				},
+				Resolve: app.resolveCreateReview,
+			},
+		},
+	}
+
+	schema, err := graphql.NewSchema(query, mutation)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.SetLimits(graphql.Limits{
+		MaxDepth: app.config.graphQL.maxDepth,
+		MaxCost: app.config.graphQL.maxCost,
+		ListSize: graphQLListSize,
+	})
+
+	return schema, nil
+}
+
+func (app *application) resolveVenues(p graphql.ResolveParams) (interface{}, error) {
+	filter := data.VenueFilter{Limit: 50}
+
+	if limit, ok := p.Args["limit"].(int); ok {
+		filter.Limit = limit
+	}
+
+	if name, ok := p.Args["name"].(string); ok {
+		filter.Name = name
+	}
+
+	if near, ok := p.Args["near"].(map[string]interface{}); ok {
+		filter.Lat = near["lat"].(float64)
+		filter.Lon = near["lon"].(float64)
+		filter.RadiusKm = near["radiusKm"].(float64)
+	}
+
+	if bounds, ok := p.Args["bounds"].(map[string]interface{}); ok {
+		filter.Bounds = &data.Bounds{
+			South: bounds["south"].(float64),
+			West: bounds["west"].(float64),
+			North: bounds["north"].(float64),
+			East: bounds["east"].(float64),
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateVenueFilter(v, filter); !v.Valid() {
+		return nil, validationError(v.Errors)
+	}
+
+	venues, err := app.models.Graph.SearchVenues(p.Context, filter)
+	if err != nil {
+		return nil, graphRequestFrom(p.Context).serverError(app, err)
+	}
+
+	return venues, nil
+}
+
+func (app *application) resolveCreateReview(p graphql.ResolveParams) (interface{}, error) {
+	fields := p.Args["input"].(map[string]interface{})
+
+	input := createReviewInput{
+		Style: fields["style"].(string),
+		Price: float32(fields["price"].(float64)),
+		Cheesiness: float32(fields["cheesiness"].(float64)),
+		Flavor: float32(fields["flavor"].(float64)),
+		Sauciness: float32(fields["sauciness"].(float64)),
+		Saltiness: float32(fields["saltiness"].(float64)),
+		Charness: float32(fields["charness"].(float64)),
+		Spiciness: float32(fields["spiciness"].(float64)),
+		Conclusion: fields["conclusion"].(string),
+	}
+
+	if ids, ok := fields["imageIds"].([]interface{}); ok {
+		for _, raw := range ids {
+			id, err := parseID(raw)
+			if err != nil {
+				return nil, err
+			}
+			input.ImageIds = append(input.ImageIds, id)
+		}
+	}
+
+	if captions, ok := fields["captions"].([]interface{}); ok {
+		for _, caption := range captions {
+			input.Captions = append(input.Captions, caption.(string))
+		}
+	}
+
+	gr := graphRequestFrom(p.Context)
+	v := validator.New()
+
+	review, err := app.newReview(p.Context, input, v)
+	if err != nil {
+		return nil, gr.serverError(app, err)
+	}
+
+	if !v.Valid() {
+		return nil, validationError(v.Errors)
+	}
+
+	err = app.models.Reviews.Insert(p.Context, review)
+	if err != nil {
+		return nil, gr.serverError(app, err)
+	}
+
+	return review, nil
+}
+
+// answers 400 when the request is rejected before it runs, otherwise 200 with
+// whatever data and field errors the execution produced
+func (app *application) graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphRequestContextKey, app.newGraphRequest(r))
+
+	result := app.graphQL.Execute(ctx, req)
+
+	status := http.StatusOK
+	if !result.Executed {
+		status = http.StatusBadRequest
+	}
+
+	env := envelope{}
+	if result.Executed {
+		env["data"] = result.Data
+	}
+	if len(result.Errors) > 0 {
+		env["errors"] = result.Errors
+	}
+
+	err = app.writeResponse(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
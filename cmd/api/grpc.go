@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// a unary method, in is the decoded request
+type unaryRPC func(ctx context.Context, in rpcRequest) (proto.Message, error)
+
+// a server streaming method, replies go out through stream.SendMsg
+type streamRPC func(in rpcRequest, stream grpc.ServerStream) error
+
+// starts the gRPC server on its own port next to the HTTP one, the returned
+// function stops it. Listening happens up front so a taken port fails startup
+func (app *application) serveGRPC() (func(ctx context.Context) error, error) {
+	if app.config.grpc.port == 0 {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.grpc.port))
+	if err != nil {
+		return nil, err
+	}
+
+	srv := app.grpcServer()
+
+	go func() {
+		app.logger.PrintInfo("starting grpc server", map[string]string{
+			"addr": lis.Addr().String(),
+		})
+
+		err := srv.Serve(lis)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"addr": lis.Addr().String(),
+			})
+		}
+	}()
+
+	// GracefulStop waits for every open stream, an export still running when
+	// ctx runs out is cut off
+	shutdown := func(ctx context.Context) error {
+		stopped := make(chan struct{})
+
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return ctx.Err()
+		}
+	}
+
+	return shutdown, nil
+}
+
+func (app *application) grpcServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(app.recoverUnaryRPC, app.unaryRPCBudget),
+		grpc.ChainStreamInterceptor(app.recoverStreamRPC),
+	)
+
+	srv.RegisterService(app.grpcServiceDesc("VenueService", map[string]unaryRPC{
+		"GetVenue": app.getVenueRPC,
+		"ListVenues": app.listVenuesRPC,
+		"CreateVenue": app.createVenueRPC,
+	}, nil), app)
+
+	srv.RegisterService(app.grpcServiceDesc("PizzaService", map[string]unaryRPC{
+		"GetPizza": app.getPizzaRPC,
+		"ListPizzas": app.listPizzasRPC,
+		"CreatePizza": app.createPizzaRPC,
+	}, nil), app)
+
+	srv.RegisterService(app.grpcServiceDesc("ReviewService", map[string]unaryRPC{
+		"GetReview": app.getReviewRPC,
+		"CreateReview": app.createReviewRPC,
+	}, map[string]streamRPC{
+		"ListReviews": app.listReviewsRPC,
+	}), app)
+
+	srv.RegisterService(app.grpcServiceDesc("ImageService", map[string]unaryRPC{
+		"GetImage": app.getImageRPC,
+	}, nil), app)
+
+	reflection.Register(srv)
+
+	return srv
+}
+
+// builds the registration for a service in grpcFile. Every method in the
+// descriptor needs an implementation and the other way round, a mismatch
+// stops the server from starting
+func (app *application) grpcServiceDesc(name string, unary map[string]unaryRPC, streams map[string]streamRPC) *grpc.ServiceDesc {
+	sd := grpcFile.Services().ByName(protoreflect.Name(name))
+	if sd == nil {
+		panic(fmt.Sprintf("grpc: no service %s", name))
+	}
+
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		// the methods are looked up here rather than through an interface
+		HandlerType: (*interface{})(nil),
+		Metadata: grpcFile.Path(),
+	}
+
+	methods := sd.Methods()
+
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		methodName := string(md.Name())
+
+		if md.IsStreamingServer() {
+			fn, ok := streams[methodName]
+			if !ok {
+				panic(fmt.Sprintf("grpc: %s.%s has no implementation", name, methodName))
+			}
+			desc.Streams = append(desc.Streams, streamMethod(md, fn))
+			continue
+		}
+
+		fn, ok := unary[methodName]
+		if !ok {
+			panic(fmt.Sprintf("grpc: %s.%s has no implementation", name, methodName))
+		}
+		desc.Methods = append(desc.Methods, unaryMethod(md, fn))
+	}
+
+	if len(desc.Methods) != len(unary) || len(desc.Streams) != len(streams) {
+		panic(fmt.Sprintf("grpc: %s has implementations for methods it doesn't declare", name))
+	}
+
+	return desc
+}
+
+func unaryMethod(md protoreflect.MethodDescriptor, fn unaryRPC) grpc.MethodDesc {
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	return grpc.MethodDesc{
+		MethodName: string(md.Name()),
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := dynamicpb.NewMessage(md.Input())
+
+			err := dec(in)
+			if err != nil {
+				return nil, err
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(ctx, rpcRequest{req.(*dynamicpb.Message)})
+			}
+
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+		},
+	}
+}
+
+func streamMethod(md protoreflect.MethodDescriptor, fn streamRPC) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName: string(md.Name()),
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := dynamicpb.NewMessage(md.Input())
+
+			err := stream.RecvMsg(in)
+			if err != nil {
+				return err
+			}
+
+			return fn(rpcRequest{in}, stream)
+		},
+	}
+}
+
+// unary calls get the same database budget as HTTP requests, streams are
+// bounded by their own timeouts
+func (app *application) unaryRPCBudget(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, app.config.requestBudget)
+	defer cancel()
+
+	return handler(ctx, req)
+}
+
+func (app *application) recoverUnaryRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = app.rpcServerError(ctx, fmt.Errorf("%s", p))
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func (app *application) recoverStreamRPC(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = app.rpcServerError(stream.Context(), fmt.Errorf("%s", p))
+		}
+	}()
+
+	return handler(srv, stream)
+}
+
+// logs err and gives the client the message a 500 gets over HTTP
+func (app *application) rpcServerError(ctx context.Context, err error) error {
+	method, _ := grpc.Method(ctx)
+
+	app.logger.PrintErrorContext(ctx, err, map[string]string{
+		"grpc_method": method,
+	})
+
+	return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+}
+
+func rpcNotFound() error {
+	return status.Error(codes.NotFound, "the requested resource could not be found")
+}
+
+// the validator's errors as field violations, the same keys the HTTP API
+// reports in its 422 body
+func rpcFailedValidation(errors map[string]string) error {
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	violations := &errdetails.BadRequest{}
+	for _, field := range fields {
+		violations.FieldViolations = append(violations.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field: field,
+			Description: errors[field],
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, "failed validation").WithDetails(violations)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "failed validation")
+	}
+
+	return st.Err()
+}
+
+// a decoded request message, unset fields read as their zero value
+type rpcRequest struct {
+	m *dynamicpb.Message
+}
+
+func (r rpcRequest) get(name string) protoreflect.Value {
+	fd := r.m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		panic(fmt.Sprintf("grpc: %s has no field %s", r.m.Descriptor().FullName(), name))
+	}
+	return r.m.Get(fd)
+}
+
+func (r rpcRequest) Int64(name string) int64 {
+	return r.get(name).Int()
+}
+
+func (r rpcRequest) Float64(name string) float64 {
+	return r.get(name).Float()
+}
+
+func (r rpcRequest) Float32(name string) float32 {
+	return float32(r.get(name).Float())
+}
+
+func (r rpcRequest) String(name string) string {
+	return r.get(name).String()
+}
+
+func (r rpcRequest) Int64s(name string) []int64 {
+	list := r.get(name).List()
+	out := make([]int64, list.Len())
+	for i := range out {
+		out[i] = list.Get(i).Int()
+	}
+	return out
+}
+
+func (r rpcRequest) Strings(name string) []string {
+	list := r.get(name).List()
+	out := make([]string, list.Len())
+	for i := range out {
+		out[i] = list.Get(i).String()
+	}
+	return out
+}
+
+// the zero time when the timestamp is unset
+func (r rpcRequest) Time(name string) time.Time {
+	fd := r.m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if !r.m.Has(fd) {
+		return time.Time{}
+	}
+
+	ts := r.m.Get(fd).Message()
+	fields := ts.Descriptor().Fields()
+
+	return time.Unix(ts.Get(fields.ByName("seconds")).Int(), ts.Get(fields.ByName("nanos")).Int()).UTC()
+}
+
+// the values of a reply message by field name
+type rpcFields map[string]interface{}
+
+// builds a message of grpcFile. Values are the Go types protoreflect.ValueOf
+// takes, plus int for int32 fields, time.Time for timestamps, messages and
+// slices of either for repeated fields. Zero times are left unset
+func newRPCMessage(name string, fields rpcFields) *dynamicpb.Message {
+	md := grpcFile.Messages().ByName(protoreflect.Name(name))
+	if md == nil {
+		panic(fmt.Sprintf("grpc: no message %s", name))
+	}
+
+	m := dynamicpb.NewMessage(md)
+
+	for field, value := range fields {
+		fd := md.Fields().ByName(protoreflect.Name(field))
+		if fd == nil {
+			panic(fmt.Sprintf("grpc: %s has no field %s", name, field))
+		}
+
+		switch value := value.(type) {
+		case nil:
+		case int:
+			m.Set(fd, protoreflect.ValueOfInt32(int32(value)))
+		case time.Time:
+			if !value.IsZero() {
+				m.Set(fd, protoreflect.ValueOfMessage(timestamppb.New(value).ProtoReflect()))
+			}
+		case *dynamicpb.Message:
+			if value != nil {
+				m.Set(fd, protoreflect.ValueOfMessage(value))
+			}
+		case []*dynamicpb.Message:
+			list := m.Mutable(fd).List()
+			for _, item := range value {
+				list.Append(protoreflect.ValueOfMessage(item))
+			}
+		default:
+			m.Set(fd, protoreflect.ValueOf(value))
+		}
+	}
+
+	return m
+}
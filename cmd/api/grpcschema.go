@@ -0,0 +1,214 @@
+package main
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the gRPC API is built here so the build needs no protoc, pizza/v1/pizza.proto
+// is the same schema for clients to generate from. The messages are dynamic,
+// clients can also fetch the schema from the server's reflection service, e.g.
+// grpcurl -plaintext localhost:4002 describe
+const grpcPackage = "pizza.v1"
+
+var grpcFile = buildGRPCFile(&descriptorpb.FileDescriptorProto{
+	Name: proto.String("pizza/v1/pizza.proto"),
+	Package: proto.String(grpcPackage),
+	Syntax: proto.String("proto3"),
+	Dependency: []string{"google/protobuf/timestamp.proto"},
+	MessageType: []*descriptorpb.DescriptorProto{
+		protoMessage("Venue",
+			protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			protoField("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("lat", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			protoField("lon", 4, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			protoField("address", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		),
+		protoMessage("Pizza",
+			protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			protoField("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("review_id", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+		),
+		protoMessage("ReviewImage",
+			protoField("image_id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			protoField("position", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			protoField("caption", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("filename", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("content_type", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		),
+		protoMessage("Review",
+			protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			protoField("style", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("price", 3, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("cheesiness", 4, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("flavor", 5, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("sauciness", 6, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("saltiness", 7, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("charness", 8, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("spiciness", 9, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("conclusion", 10, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoMessageField("created_at", 11, ".google.protobuf.Timestamp"),
+			protoRepeated(protoMessageField("images", 12, ".pizza.v1.ReviewImage")),
+		),
+		protoMessage("Image",
+			protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			protoField("filename", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("content_type", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("status", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("checksum", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoMessageField("created_at", 6, ".google.protobuf.Timestamp"),
+		),
+
+		protoMessage("GetVenueRequest", protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+		protoMessage("ListVenuesRequest"),
+		protoMessage("ListVenuesResponse", protoRepeated(protoMessageField("venues", 1, ".pizza.v1.Venue"))),
+		protoMessage("CreateVenueRequest",
+			protoField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("lat", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			protoField("lon", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+			protoField("address", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		),
+
+		protoMessage("GetPizzaRequest", protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+		protoMessage("ListPizzasRequest"),
+		protoMessage("ListPizzasResponse", protoRepeated(protoMessageField("pizzas", 1, ".pizza.v1.Pizza"))),
+		protoMessage("CreatePizzaRequest",
+			protoField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("review_id", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+		),
+
+		protoMessage("GetReviewRequest", protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+		protoMessage("ListReviewsRequest",
+			protoMessageField("from", 1, ".google.protobuf.Timestamp"),
+			protoMessageField("to", 2, ".google.protobuf.Timestamp"),
+		),
+		// one per review and venue serving its pizza, pizza and venue are unset when there is none
+		protoMessage("ListReviewsResponse",
+			protoMessageField("review", 1, ".pizza.v1.Review"),
+			protoMessageField("pizza", 2, ".pizza.v1.Pizza"),
+			protoMessageField("venue", 3, ".pizza.v1.Venue"),
+		),
+		protoMessage("CreateReviewRequest",
+			protoField("style", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoField("price", 2, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("cheesiness", 3, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("flavor", 4, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("sauciness", 5, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("saltiness", 6, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("charness", 7, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("spiciness", 8, descriptorpb.FieldDescriptorProto_TYPE_FLOAT),
+			protoField("conclusion", 9, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			protoRepeated(protoField("image_ids", 10, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+			protoRepeated(protoField("captions", 11, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+		),
+
+		protoMessage("GetImageRequest", protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{
+		protoService("VenueService",
+			protoMethod("GetVenue", "GetVenueRequest", "Venue"),
+			protoMethod("ListVenues", "ListVenuesRequest", "ListVenuesResponse"),
+			protoMethod("CreateVenue", "CreateVenueRequest", "Venue"),
+		),
+		protoService("PizzaService",
+			protoMethod("GetPizza", "GetPizzaRequest", "Pizza"),
+			protoMethod("ListPizzas", "ListPizzasRequest", "ListPizzasResponse"),
+			protoMethod("CreatePizza", "CreatePizzaRequest", "Pizza"),
+		),
+		protoService("ReviewService",
+			protoMethod("GetReview", "GetReviewRequest", "Review"),
+			protoStreaming(protoMethod("ListReviews", "ListReviewsRequest", "ListReviewsResponse")),
+			protoMethod("CreateReview", "CreateReviewRequest", "Review"),
+		),
+		protoService("ImageService",
+			protoMethod("GetImage", "GetImageRequest", "Image"),
+		),
+	},
+})
+
+// registers the file globally so the reflection service can describe it. A
+// mistake in the descriptor is a bug, the server doesn't start with one
+func buildGRPCFile(fdp *descriptorpb.FileDescriptorProto) protoreflect.FileDescriptor {
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+
+	err = protoregistry.GlobalFiles.RegisterFile(fd)
+	if err != nil {
+		panic(err)
+	}
+
+	return fd
+}
+
+func protoMessage(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func protoField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name: proto.String(name),
+		JsonName: proto.String(protoJSONName(name)),
+		Number: proto.Int32(number),
+		Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type: typ.Enum(),
+	}
+}
+
+// typeName is fully qualified, with the leading dot
+func protoMessageField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := protoField(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	field.TypeName = proto.String(typeName)
+	return field
+}
+
+func protoRepeated(field *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return field
+}
+
+// lowerCamelCase, the way protoc names fields in JSON
+func protoJSONName(name string) string {
+	out := make([]byte, 0, len(name))
+	upper := false
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			out = append(out, c-'a'+'A')
+			upper = false
+		default:
+			out = append(out, c)
+			upper = false
+		}
+	}
+
+	return string(out)
+}
+
+func protoService(name string, methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.ServiceDescriptorProto {
+	return &descriptorpb.ServiceDescriptorProto{Name: proto.String(name), Method: methods}
+}
+
+// input and output are messages of this package
+func protoMethod(name, input, output string) *descriptorpb.MethodDescriptorProto {
+	return &descriptorpb.MethodDescriptorProto{
+		Name: proto.String(name),
+		InputType: proto.String("." + grpcPackage + "." + input),
+		OutputType: proto.String("." + grpcPackage + "." + output),
+	}
+}
+
+func protoStreaming(method *descriptorpb.MethodDescriptorProto) *descriptorpb.MethodDescriptorProto {
+	method.ServerStreaming = proto.Bool(true)
+	return method
+}
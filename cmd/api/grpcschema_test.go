@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// the descriptor written out the way pizza/v1/pizza.proto lays it out, less
+// comments and blank lines
+func protoDeclarations(fd protoreflect.FileDescriptor) []string {
+	lines := []string{
+		fmt.Sprintf("syntax = %q;", fd.Syntax().String()),
+		fmt.Sprintf("package %s;", fd.Package()),
+	}
+
+	for i := 0; i < fd.Imports().Len(); i++ {
+		lines = append(lines, fmt.Sprintf("import %q;", fd.Imports().Get(i).Path()))
+	}
+
+	typeName := func(field protoreflect.FieldDescriptor) string {
+		if field.Message() == nil {
+			return field.Kind().String()
+		}
+		if field.Message().ParentFile() == fd {
+			return string(field.Message().Name())
+		}
+		return string(field.Message().FullName())
+	}
+
+	for i := 0; i < fd.Messages().Len(); i++ {
+		md := fd.Messages().Get(i)
+
+		if md.Fields().Len() == 0 {
+			lines = append(lines, fmt.Sprintf("message %s {}", md.Name()))
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("message %s {", md.Name()))
+		for j := 0; j < md.Fields().Len(); j++ {
+			field := md.Fields().Get(j)
+
+			repeated := ""
+			if field.Cardinality() == protoreflect.Repeated {
+				repeated = "repeated "
+			}
+
+			lines = append(lines, fmt.Sprintf("  %s%s %s = %d;", repeated, typeName(field), field.Name(), field.Number()))
+		}
+		lines = append(lines, "}")
+	}
+
+	for i := 0; i < fd.Services().Len(); i++ {
+		sd := fd.Services().Get(i)
+
+		lines = append(lines, fmt.Sprintf("service %s {", sd.Name()))
+		for j := 0; j < sd.Methods().Len(); j++ {
+			method := sd.Methods().Get(j)
+
+			stream := ""
+			if method.IsStreamingServer() {
+				stream = "stream "
+			}
+
+			lines = append(lines, fmt.Sprintf("  rpc %s(%s) returns (%s%s);", method.Name(), method.Input().Name(), stream, method.Output().Name()))
+		}
+		lines = append(lines, "}")
+	}
+
+	return lines
+}
+
+func TestProtoFileMatchesDescriptor(t *testing.T) {
+	b, err := os.ReadFile("../../" + grpcFile.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "//") {
+			continue
+		}
+		got = append(got, line)
+	}
+
+	want := protoDeclarations(grpcFile)
+
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var g, w string
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+
+		if g != w {
+			t.Fatalf("%s declaration %d: got %q, want %q from grpcschema.go", grpcFile.Path(), i+1, g, w)
+		}
+	}
+}
@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+// how long each readiness check may take before it counts as failed
+const readinessTimeout = 2 * time.Second
+
+// liveness, only says the process is up and serving. Dependencies are left to
+// readyz so a database outage doesn't get every instance restarted
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	// create a map
 	env := envelope{
 		"status": "available",
@@ -13,9 +26,95 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 			"version": version,
 		},
 	}
-	
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+
+	err := app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readiness, 503 when a dependency is down or the server is draining before shutdown
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if atomic.LoadInt32(&app.shuttingDown) == 1 {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	err = checkStorageWritable()
+	if err != nil {
+		checks["storage"] = err.Error()
+		ready = false
+	} else {
+		checks["storage"] = "ok"
+	}
+
+	migration, err := migrationVersion(ctx, app.db)
+	if err != nil {
+		checks["migrations"] = err.Error()
+		ready = false
+	} else {
+		checks["migrations"] = migration
+	}
+
+	status := http.StatusOK
+	env := envelope{"status": "ready", "checks": checks}
+
+	if !ready {
+		status = http.StatusServiceUnavailable
+		env["status"] = "unavailable"
+	}
+
+	err = app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// creates and removes a file where uploads are written
+func checkStorageWritable() error {
+	f, err := ioutil.TempFile(imageStorageDir(), ".readyz-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	return f.Close()
+}
+
+// the schema version recorded by the migrate tool, a dirty version means a
+// migration failed halfway and the schema can't be trusted
+func migrationVersion(ctx context.Context, db *sql.DB) (string, error) {
+	var (
+		version int64
+		dirty bool
+	)
+
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("no migrations applied")
+		}
+		return "", err
+	}
+
+	if dirty {
+		return "", errors.New("migration " + strconv.FormatInt(version, 10) + " is dirty")
+	}
+
+	return strconv.FormatInt(version, 10), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckStorageWritableFollowsFilepath(t *testing.T) {
+	root := t.TempDir() + string(filepath.Separator)
+	t.Setenv("FILEPATH", root)
+
+	// nothing to write to until FILEPATH has an uploads directory
+	if err := checkStorageWritable(); err == nil {
+		t.Fatal("got no error without an uploads directory under FILEPATH")
+	}
+
+	err := os.Mkdir(root+imageDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkStorageWritable(); err != nil {
+		t.Fatal(err)
+	}
+}
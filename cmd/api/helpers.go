@@ -9,7 +9,9 @@ import (
 	"io"
 	"strings"
 	"strconv"
+	"time"
 
+	"github.com/tclohm/project-pizza/internal/data"
 	"github.com/tclohm/project-pizza/internal/validator"
 	
 )
@@ -59,27 +61,6 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	// Use .Marshal for production b/c less memory used
-	js, err := json.MarshalIndent(data, "", "\t")
-
-	if err != nil {
-		return err
-	}
-
-	js = append(js, '\n')
-
-	for key, value := range headers {
-		w.Header()[key] = value
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	w.Write(js)
-
-	return nil
-}
-
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -114,4 +95,58 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 
 	return i
-}
\ No newline at end of file
+}
+
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// only the server knows when its cache has been purged, so clients must check
+// back every time, the ETag keeps that to a 304 while nothing has changed.
+// Age is how long ago the entry was read from the database
+func (app *application) cacheHeaders(status *data.CacheStatus) http.Header {
+	if app.config.cache.backend == "none" {
+		return nil
+	}
+
+	age := 0
+	if status.Hit {
+		age = int(time.Since(status.StoredAt).Seconds())
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "private, no-cache")
+	headers.Set("Age", strconv.Itoa(age))
+
+	return headers
+}
+
+// runs fn in a goroutine the server waits for on shutdown, a panic is logged
+// instead of taking the process down
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
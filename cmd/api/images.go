@@ -1,12 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strconv"
 	"errors"
 	"os"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/tclohm/project-pizza/internal/data"
 	"github.com/tclohm/project-pizza/internal/validator"
@@ -14,38 +21,156 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// streams the multipart "file" part straight to disk instead of buffering
+// the form in memory, anything over the configured max size is a 413
 func (app *application) createImageHandler(w http.ResponseWriter, r *http.Request) {
+	// leave some room for the multipart boundaries and part headers
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.uploads.maxSize + 1 << 20)
 
-	r.ParseMultipartForm(10 << 20)
-	file, handler, err := r.FormFile("file")
-
+	mr, err := r.MultipartReader()
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	defer file.Close()
+	var part *multipart.Part
+
+	for {
+		part, err = mr.NextPart()
+		if err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+				app.badRequestResponse(w, r, errors.New("body must contain a file field"))
+			case isBodyTooLarge(err):
+				app.requestTooLargeResponse(w, r)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		if part.FormName() == "file" {
+			break
+		}
+
+		part.Close()
+	}
+
+	defer part.Close()
+
+	image := &data.Image{
+		Filename: part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Status: data.ImageStatusReady,
+	}
+
+	v := validator.New()
 
-	tmpFile, err := ioutil.TempFile("uploads", "upload-*.jpg")
+	if data.ValidateImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image.Location, err = reserveImageFile(image.ContentType)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	defer tmpFile.Close()
+	image.Checksum, err = writeImageFile(imagePath(image), part, app.config.uploads.maxSize)
+	if err != nil {
+		os.Remove(imagePath(image))
+
+		switch {
+		case errors.Is(err, errUploadTooLarge):
+			app.requestTooLargeResponse(w, r)
+		case errors.Is(err, errUploadIncomplete):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Images.Insert(r.Context(), image)
+	if err != nil {
+		os.Remove(imagePath(image))
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	fileBytes, err := ioutil.ReadAll(file)
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/images/%d", image.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"image": image}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Get(r.Context(), n)
+
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// nothing to serve until the upload has been completed
+	if image.Status != data.ImageStatusReady {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	tmpFile.Write(fileBytes)
+	// the file never changes once uploaded, ServeFile answers If-None-Match against this
+	if image.Checksum != "" {
+		w.Header().Set("ETag", `"`+image.Checksum+`"`)
+	}
+
+	http.ServeFile(w, r, imagePath(image))
+}
+
+// where and until when the image bytes may be PUT
+type signedUpload struct {
+	Method 		string 		`json:"method"`
+	URL 		string 		`json:"url"`
+	ExpiresAt 	time.Time 	`json:"expires_at"`
+}
+
+type createImageUploadInput struct {
+	Filename 	string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+// hands out a short-lived signed url the client PUTs the raw image bytes to,
+// so big photos skip the multipart form parsing in createImageHandler
+func (app *application) createImageUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var input createImageUploadInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 
 	image := &data.Image{
-		Filename: handler.Filename,
-		ContentType: handler.Header["Content-Type"][0],
-		Location: tmpFile.Name(),
+		Filename: input.Filename,
+		ContentType: input.ContentType,
+		Status: data.ImageStatusPending,
 	}
 
 	v := validator.New()
@@ -55,22 +180,45 @@ func (app *application) createImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Images.Insert(image)
+	// reserve the file now so the row always has somewhere to point at
+	image.Location, err = reserveImageFile(image.ContentType)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	err = app.models.Images.Insert(r.Context(), image)
+	if err != nil {
+		os.Remove(imagePath(image))
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	expires := time.Now().Add(app.config.uploads.urlTTL)
+	path := fmt.Sprintf("/v1/images/%d/upload", image.ID)
+
+	upload := signedUpload{
+		Method: http.MethodPut,
+		URL: app.signURL(http.MethodPut, path, expires),
+		ExpiresAt: expires.UTC().Truncate(time.Second),
+	}
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/images/%d", image.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"image": image, "upload": upload}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request) {
+// target of the signed url, the request body is the image itself
+func (app *application) uploadImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.verifySignedURL(r) {
+		app.invalidSignedURLResponse(w, r)
+		return
+	}
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 
@@ -80,19 +228,117 @@ func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	image, err := app.models.Images.Get(n)
+	image, err := app.models.Images.Get(r.Context(), n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if image.Status != data.ImageStatusPending {
+		app.badRequestResponse(w, r, errors.New("image upload has already been completed"))
+		return
+	}
+
+	if r.Header.Get("Content-Type") != image.ContentType {
+		app.badRequestResponse(w, r, fmt.Errorf("Content-Type must be %s", image.ContentType))
+		return
+	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.uploads.maxSize + 1)
+
+	image.Checksum, err = writeImageFile(imagePath(image), r.Body, app.config.uploads.maxSize)
 	if err != nil {
 		switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.notFoundResponse(w, r)
-			default:
-				app.serverErrorResponse(w, r, err)
+		case errors.Is(err, errUploadTooLarge):
+			app.requestTooLargeResponse(w, r)
+		case errors.Is(err, errUploadIncomplete):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the checksum is only persisted once the upload is completed
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checks the uploaded bytes really are the declared image type before flipping it to ready
+func (app *application) completeImageUploadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Get(r.Context(), n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// completing twice is harmless
+	if image.Status == data.ImageStatusReady {
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"image": image}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	err = checkImageContent(v, image)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image.Checksum, err = fileChecksum(imagePath(image))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	image.Status = data.ImageStatusReady
+
+	err = app.models.Images.Update(r.Context(), image)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	http.ServeFile(w, r, os.Getenv("FILEPATH") + image.Location)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Request) {
@@ -105,7 +351,7 @@ func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Images.Delete(n)
+	image, err := app.models.Images.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -116,8 +362,150 @@ func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	err = app.models.Images.Delete(r.Context(), n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the row is gone, a leftover file is only wasted disk so log and carry on
+	err = os.Remove(imagePath(image))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.logError(r, err)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// where an image lives on disk
+func imagePath(image *data.Image) string {
+	return os.Getenv("FILEPATH") + image.Location
+}
+
+// the directory image files are written to
+const imageDir = "uploads"
+
+// where image files are written on disk, readyz checks the same place
+func imageStorageDir() string {
+	return os.Getenv("FILEPATH") + imageDir
+}
+
+// creates an empty file in uploads for an image whose bytes arrive later and
+// returns its location, which like every stored location is relative to FILEPATH
+func reserveImageFile(contentType string) (string, error) {
+	pattern := "upload-*.jpg"
+	if contentType == "image/png" {
+		pattern = "upload-*.png"
+	}
+
+	tmpFile, err := ioutil.TempFile(imageStorageDir(), pattern)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(tmpFile.Name(), os.Getenv("FILEPATH")), tmpFile.Close()
+}
+
+var (
+	errUploadTooLarge = errors.New("upload is larger than the maximum upload size")
+	errUploadIncomplete = errors.New("upload was interrupted before the whole file was received")
+)
+
+// copies src into a temp file next to dst while hashing it, then renames it
+// over dst so readers never see a half written image. Returns the hex sha256
+func writeImageFile(dst string, src io.Reader, maxSize int64) (string, error) {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst) + ".*.part")
+	if err != nil {
+		return "", err
+	}
+
+	// a no-op once the rename has happened
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+
+	// read one byte past the limit so an oversized upload can be told apart
+	n, err := io.Copy(io.MultiWriter(tmpFile, hash), io.LimitReader(src, maxSize + 1))
+	if err != nil {
+		if isBodyTooLarge(err) {
+			return "", errUploadTooLarge
+		}
+		return "", fmt.Errorf("%w: %s", errUploadIncomplete, err)
+	}
+
+	if n > maxSize {
+		return "", errUploadTooLarge
+	}
+
+	err = tmpFile.Sync()
+	if err != nil {
+		return "", err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpFile.Name(), dst)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hex sha256 of a file already on disk
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+// sniffs the start of the stored file and records a validation error unless
+// it is non-empty and matches the declared content type
+func checkImageContent(v *validator.Validator, image *data.Image) error {
+	file, err := os.Open(imagePath(image))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	head := make([]byte, 512)
+	size, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	v.Check(size > 0, "file", "has not been uploaded")
+	v.Check(size == 0 || http.DetectContentType(head[:size]) == image.ContentType, "file", "content does not match content_type")
+
+	return nil
+}
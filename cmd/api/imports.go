@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// the most an import body may be, a few hundred thousand spreadsheet rows
+	maxImportSize = 20 << 20
+	// rows written per transaction
+	importBatchSize = 100
+)
+
+// the content types an import can be sent as, and the format each one means
+var importFormats = map[string]string{
+	"text/csv": data.ImportFormatCSV,
+	"application/x-ndjson": data.ImportFormatNDJSON,
+	"application/ndjson": data.ImportFormatNDJSON,
+}
+
+// parses the whole body up front so a malformed file is rejected straight away,
+// then writes the rows in the background. Clients poll the job for progress
+func (app *application) createImportHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	format, ok := importFormats[mediaType]
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, "text/csv or application/x-ndjson")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	rows, err := data.ParseImport(format, r.Body)
+	if err != nil {
+		switch {
+		case isBodyTooLarge(err):
+			app.requestTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("import contains no rows"))
+		return
+	}
+
+	job := &data.ImportJob{
+		Format: format,
+		Status: data.ImportStatusPending,
+		TotalRows: len(rows),
+	}
+
+	err = app.models.Imports.Insert(r.Context(), job)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the job outlives the request, keep its id for the logs and sql but not its deadline
+	ctx := jsonlog.WithProperty(context.Background(), jsonlog.RequestIDProperty, jsonlog.RequestID(r.Context()))
+	properties := map[string]string{
+		"import_id": strconv.FormatInt(job.ID, 10),
+	}
+
+	// Run updates the job's status and counters as it goes, the response shows
+	// it the way it was accepted
+	accepted := *job
+
+	app.background(func() {
+		err := app.models.Imports.Run(ctx, job, rows, importBatchSize)
+		if err != nil {
+			app.logger.PrintErrorContext(ctx, err, properties)
+			return
+		}
+
+		app.logger.PrintInfoContext(ctx, "import finished", properties)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/%d", accepted.ID))
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"import": &accepted}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Imports.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"import": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// per-row errors, including rows lost to a rolled back batch
+func (app *application) listImportErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// 404 for a job that doesn't exist rather than an empty list
+	_, err = app.models.Imports.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	importErrors, err := app.models.Imports.GetErrors(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"errors": importErrors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
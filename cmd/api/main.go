@@ -1,19 +1,28 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
 	"flag"
-	_ "fmt"
+	"io"
+	"net"
+	"fmt"
 	"os"
 	"time"
 	"context"
 	"database/sql"
 	"strings"
+	"sync"
 
+	"github.com/tclohm/project-pizza/internal/cache"
 	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/graphql"
 	"github.com/tclohm/project-pizza/internal/jsonlog"
 
+	"github.com/XSAM/otelsql"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
 const version = "1.0.0"
@@ -21,6 +30,12 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env string
+	// apply pending embedded migrations before serving
+	autoMigrate bool
+	// how long readyz reports 503 before the server stops accepting connections
+	shutdownDrain time.Duration
+	// how long a request may spend on database work before its queries are cancelled
+	requestBudget time.Duration
 	db struct {
 		dataSource string
 		maxOpenConns int
@@ -36,17 +51,87 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// proxies whose X-Forwarded-For header we believe when working out the client ip
+	trustedProxies []*net.IPNet
+	// request paths that don't get an access log line
+	accessLog struct {
+		exclude []string
+	}
+	// image uploads, the key signs the presigned upload urls handed to clients
+	uploads struct {
+		signingKey	string
+		urlTTL		time.Duration
+		maxSize		int64
+		timeout		time.Duration
+	}
+	// the key signs the cursors handed out for paging through lists
+	cursors struct {
+		signingKey	string
+	}
+	// metrics are served on their own listen address, empty disables them
+	metrics struct {
+		addr string
+	}
+	// bounds on what one GraphQL document may ask for, 0 lifts the bound
+	graphQL struct {
+		maxDepth	int
+		maxCost		int
+	}
+	// the gRPC services listen on their own port, 0 disables them
+	grpc struct {
+		port int
+	}
+	// span exporter, none|otlp|stdout. The endpoint is only read by otlp,
+	// the file only by stdout
+	tracing struct {
+		exporter	string
+		endpoint	string
+		file		string
+	}
+	// logs always go to stdout, and to a rotating file too when one is set
+	log struct {
+		level				jsonlog.Level
+		stackTraces			bool
+		sampleFirst			int
+		sampleThereafter	int
+		file				string
+		fileMaxSize			int64
+		fileMaxAge			time.Duration
+	}
+	// read-through cache for the venue pizza endpoints, backend is memory|redis|none
+	cache struct {
+		backend		string
+		ttl			time.Duration
+		size		int
+		redisAddr	string
+	}
+	// orphaned image garbage collection
+	gc struct {
+		interval	time.Duration
+		grace		time.Duration
+		dryRun		bool
+	}
 }
 
 type application struct {
 	config config
 	logger *jsonlog.Logger
 	models data.Models
+	metrics *metrics
+	db *sql.DB
+	// set to 1 once shutdown starts, readyz reports 503 from then on
+	shuttingDown int32
+	// goroutines started with background, shutdown waits for them
+	wg sync.WaitGroup
+	// the document /v1/openapi.json serves, built by routes()
+	openAPI envelope
+	// the schema /v1/graphql executes against, built by routes()
+	graphQL *graphql.Schema
 }
 
 func main() {
 
-	// stdout streams
+	// stdout streams, replaced by the configured logger once the flags are parsed
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
 	err := godotenv.Load(".env")
@@ -59,11 +144,14 @@ func main() {
 	// values of the env command-line flags into the config struct
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdownDrain, "shutdown-drain", 5*time.Second, "How long /v1/readyz fails before shutdown so load balancers stop routing to us")
+	flag.DurationVar(&cfg.requestBudget, "request-budget", 10*time.Second, "Deadline for all database work done by a single request")
 
 	//connectionString := "host=%s user=%s dbname=%s sslmode=%s"
 	//connectionString = fmt.Sprintf(connectionString, os.Getenv("HOSTNAME"), os.Getenv("PSQL_USER"), os.Getenv("PSQL_DATABASE"), "disable")
 
 	flag.StringVar(&cfg.db.dataSource, "db-ds", os.Getenv("PIZZA_DB_DSN"), "PostgreSQL DSN")
+	flag.BoolVar(&cfg.autoMigrate, "auto-migrate", false, "Apply pending database migrations at startup")
 
 	// limit of open connections
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -81,8 +169,136 @@ func main() {
 		return nil
 	})
 
+	flag.Func("trusted-proxies", "Trusted proxy IPs or CIDRs (space separated)", func(val string) error {
+		for _, field := range strings.Fields(val) {
+			if !strings.Contains(field, "/") {
+				if strings.Contains(field, ":") {
+					field += "/128"
+				} else {
+					field += "/32"
+				}
+			}
+
+			_, network, err := net.ParseCIDR(field)
+			if err != nil {
+				return err
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, network)
+		}
+		return nil
+	})
+
+	cfg.accessLog.exclude = []string{"/v1/healthz", "/v1/healthcheck", "/v1/readyz"}
+	flag.Func("access-log-exclude", "Request paths left out of the access log (space separated) (default \"/v1/healthz /v1/healthcheck /v1/readyz\")", func(val string) error {
+		cfg.accessLog.exclude = strings.Fields(val)
+		return nil
+	})
+
+	flag.StringVar(&cfg.uploads.signingKey, "upload-signing-key", os.Getenv("PIZZA_UPLOAD_SIGNING_KEY"), "HMAC key for presigned upload URLs")
+	flag.DurationVar(&cfg.uploads.urlTTL, "upload-url-ttl", 15*time.Minute, "How long a presigned upload URL stays valid")
+	flag.Int64Var(&cfg.uploads.maxSize, "upload-max-size", 50<<20, "Maximum image upload size in bytes")
+	flag.DurationVar(&cfg.uploads.timeout, "upload-timeout", 10*time.Minute, "How long an upload request may take to send its body and get the answer")
+
+	flag.StringVar(&cfg.cursors.signingKey, "cursor-signing-key", os.Getenv("PIZZA_CURSOR_SIGNING_KEY"), "HMAC key for list paging cursors")
+
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Listen address for GET /debug/metrics, e.g. localhost:4001 (disabled when empty)")
+
+	flag.IntVar(&cfg.graphQL.maxDepth, "graphql-max-depth", 10, "How deeply a GraphQL query may nest fields (0 for no limit)")
+	flag.IntVar(&cfg.graphQL.maxCost, "graphql-max-cost", 5000, "Most fields a GraphQL query may resolve, counting each list as holding 10 items (0 for no limit)")
+
+	flag.IntVar(&cfg.grpc.port, "grpc-port", 0, "gRPC server port (0 disables it)")
+
+	flag.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Where to send trace spans (none|otlp|stdout)")
+	flag.StringVar(&cfg.tracing.endpoint, "trace-otlp-endpoint", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&cfg.tracing.file, "trace-file", "", "File the stdout exporter appends spans to instead of stdout")
+
+	cfg.log.level = jsonlog.LevelInfo
+	flag.Func("log-level", "Minimum level to log (debug|info|warn|error|fatal|off) (default info)", func(val string) error {
+		level, err := jsonlog.ParseLevel(val)
+		cfg.log.level = level
+		return err
+	})
+	flag.BoolVar(&cfg.log.stackTraces, "log-stack-traces", true, "Attach a stack trace to ERROR and FATAL entries")
+	flag.IntVar(&cfg.log.sampleFirst, "log-sample-first", 0, "Identical DEBUG/INFO messages logged each second before sampling starts (0 disables sampling)")
+	flag.IntVar(&cfg.log.sampleThereafter, "log-sample-thereafter", 100, "Once sampling, log every nth identical DEBUG/INFO message")
+	flag.StringVar(&cfg.log.file, "log-file", "", "Also write logs to this file, rotating it by size")
+	flag.Int64Var(&cfg.log.fileMaxSize, "log-file-max-size", 100<<20, "Size in bytes at which the log file is rotated")
+	flag.DurationVar(&cfg.log.fileMaxAge, "log-file-max-age", 7*24*time.Hour, "How long rotated log files are kept (0 keeps them forever)")
+
+	flag.StringVar(&cfg.cache.backend, "cache", "memory", "Cache for the venue pizza endpoints (memory|redis|none)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "How long a cached venue pizza response is served")
+	flag.IntVar(&cfg.cache.size, "cache-size", 1000, "Maximum entries in the memory cache")
+	flag.StringVar(&cfg.cache.redisAddr, "cache-redis-addr", os.Getenv("PIZZA_REDIS_ADDR"), "Address of a Redis protocol server for -cache=redis, e.g. localhost:6379")
+
+	flag.DurationVar(&cfg.gc.interval, "gc-interval", time.Hour, "How often to sweep for orphaned images (0 disables the sweeper)")
+	flag.DurationVar(&cfg.gc.grace, "gc-grace-period", 24*time.Hour, "How long an unreferenced image is kept before it is deleted")
+	flag.BoolVar(&cfg.gc.dryRun, "gc-dry-run", false, "Log orphaned images instead of deleting them")
+
 	flag.Parse()
 
+	logger, logFile, err := newLogger(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	if cfg.gc.interval < 0 {
+		logger.PrintFatal(errors.New("-gc-interval must not be negative"), nil)
+	}
+
+	// api [flags] migrate up|down|status|force N
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(cfg, logger, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	if cfg.autoMigrate {
+		err = autoMigrate(cfg, logger)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	// without a configured key, signed urls only work against this process until it restarts
+	if cfg.uploads.signingKey == "" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.uploads.signingKey = string(key)
+
+		logger.PrintInfo("no upload signing key configured, using a random one", nil)
+	}
+
+	// a random key would break cursors across restarts and between replicas, only
+	// a development server gets away with one
+	if cfg.cursors.signingKey == "" && cfg.env != "development" {
+		logger.PrintFatal(errors.New("-cursor-signing-key is required outside development"), nil)
+	}
+
+	if cfg.cursors.signingKey == "" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.cursors.signingKey = string(key)
+
+		logger.PrintInfo("no cursor signing key configured, using a random one", nil)
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -92,10 +308,17 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	models, err := newModels(cfg, db)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: models,
+		metrics: newMetrics(db),
+		db: db,
 	}
 
 	// start server
@@ -104,11 +327,65 @@ func main() {
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// spans still sitting in the batcher are lost if we exit without flushing
+	err = shutdownTracing(ctx)
+	if err != nil {
+		logger.PrintError(err, nil)
+	}
 }
 
+func newModels(cfg config, db *sql.DB) (data.Models, error) {
+	switch cfg.cache.backend {
+	case "none":
+		return data.NewModels(db), nil
+	case "memory":
+		return data.NewCachedModels(db, cache.NewMemory(cfg.cache.size), cfg.cache.ttl), nil
+	case "redis":
+		if cfg.cache.redisAddr == "" {
+			return data.Models{}, errors.New("-cache=redis needs -cache-redis-addr")
+		}
+		return data.NewCachedModels(db, cache.NewRedis(cfg.cache.redisAddr, "pizza:", cfg.db.maxIdleConns), cfg.cache.ttl), nil
+	default:
+		return data.Models{}, fmt.Errorf("unknown cache backend %q", cfg.cache.backend)
+	}
+}
+
+// builds the logger the flags describe. On error the returned logger is the
+// stdout-only one, so the caller can still report the failure
+func newLogger(cfg config) (*jsonlog.Logger, *jsonlog.RotatingFile, error) {
+	opts := []jsonlog.Option{
+		jsonlog.WithStackTraces(cfg.log.stackTraces),
+	}
+
+	if cfg.log.sampleFirst > 0 {
+		opts = append(opts, jsonlog.WithSampling(time.Second, cfg.log.sampleFirst, cfg.log.sampleThereafter))
+	}
+
+	if cfg.log.file == "" {
+		return jsonlog.New(os.Stdout, cfg.log.level, opts...), nil, nil
+	}
+
+	file, err := jsonlog.NewRotatingFile(cfg.log.file, cfg.log.fileMaxSize, cfg.log.fileMaxAge)
+	if err != nil {
+		return jsonlog.New(os.Stdout, jsonlog.LevelInfo), nil, err
+	}
+
+	return jsonlog.New(io.MultiWriter(os.Stdout, file), cfg.log.level, opts...), file, nil
+}
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dataSource)
+	// every statement gets its own span under whichever model call issued it
+	db, err := otelsql.Open("postgres", cfg.db.dataSource,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip: true,
+			OmitConnResetSession: true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bucket upper bounds, in seconds and bytes
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000}
+)
+
+type histogram struct {
+	buckets []float64
+	counts 	[]uint64
+	sum 	float64
+	count 	uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts: make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// labels a request is counted under
+type requestKey struct {
+	method string
+	route string
+}
+
+type routeMetrics struct {
+	statuses map[int]uint64
+	latency *histogram
+	size *histogram
+}
+
+// in-process collector exposed in the prometheus text format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+type metrics struct {
+	mu 			sync.Mutex
+	routes 		map[requestKey]*routeMetrics
+	rateLimited uint64
+	db 			*sql.DB
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	return &metrics{
+		routes: make(map[requestKey]*routeMetrics),
+		db: db,
+	}
+}
+
+func (m *metrics) observeRequest(method, route string, status, bytes int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{method: method, route: route}
+
+	rm, ok := m.routes[key]
+	if !ok {
+		rm = &routeMetrics{
+			statuses: make(map[int]uint64),
+			latency: newHistogram(latencyBuckets),
+			size: newHistogram(sizeBuckets),
+		}
+		m.routes[key] = rm
+	}
+
+	rm.statuses[status]++
+	rm.latency.observe(duration.Seconds())
+	rm.size.observe(float64(bytes))
+}
+
+func (m *metrics) rateLimitRejected() {
+	atomic.AddUint64(&m.rateLimited, 1)
+}
+
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+
+	keys := make([]requestKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	fmt.Fprintln(w, "# HELP pizza_http_requests_total Total HTTP requests by method, route and status.")
+	fmt.Fprintln(w, "# TYPE pizza_http_requests_total counter")
+	for _, key := range keys {
+		statuses := m.routes[key].statuses
+
+		codes := make([]int, 0, len(statuses))
+		for code := range statuses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			fmt.Fprintf(w, "pizza_http_requests_total{%s,status=\"%d\"} %d\n", key.labels(), code, statuses[code])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP pizza_http_request_duration_seconds HTTP request latency by method and route.")
+	fmt.Fprintln(w, "# TYPE pizza_http_request_duration_seconds histogram")
+	for _, key := range keys {
+		writeHistogram(w, "pizza_http_request_duration_seconds", key.labels(), m.routes[key].latency)
+	}
+
+	fmt.Fprintln(w, "# HELP pizza_http_response_size_bytes HTTP response body size by method and route.")
+	fmt.Fprintln(w, "# TYPE pizza_http_response_size_bytes histogram")
+	for _, key := range keys {
+		writeHistogram(w, "pizza_http_response_size_bytes", key.labels(), m.routes[key].size)
+	}
+
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pizza_rate_limit_rejections_total Requests rejected by the rate limiter.")
+	fmt.Fprintln(w, "# TYPE pizza_rate_limit_rejections_total counter")
+	fmt.Fprintf(w, "pizza_rate_limit_rejections_total %d\n", atomic.LoadUint64(&m.rateLimited))
+
+	if m.db == nil {
+		return
+	}
+
+	stats := m.db.Stats()
+
+	gauges := []struct {
+		name string
+		help string
+		kind string
+		value float64
+	}{
+		{"pizza_db_max_open_connections", "Maximum number of open connections to the database.", "gauge", float64(stats.MaxOpenConnections)},
+		{"pizza_db_open_connections", "Established connections, both in use and idle.", "gauge", float64(stats.OpenConnections)},
+		{"pizza_db_in_use_connections", "Connections currently in use.", "gauge", float64(stats.InUse)},
+		{"pizza_db_idle_connections", "Idle connections.", "gauge", float64(stats.Idle)},
+		{"pizza_db_wait_count_total", "Connections waited for.", "counter", float64(stats.WaitCount)},
+		{"pizza_db_wait_duration_seconds_total", "Time spent waiting for a connection.", "counter", stats.WaitDuration.Seconds()},
+		{"pizza_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", "counter", float64(stats.MaxIdleClosed)},
+		{"pizza_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", "counter", float64(stats.MaxIdleTimeClosed)},
+		{"pizza_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", "counter", float64(stats.MaxLifetimeClosed)},
+	}
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", g.name, g.kind)
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+	}
+}
+
+func (k requestKey) labels() string {
+	return fmt.Sprintf("method=%q,route=%q", k.method, escapeLabel(k.route))
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// %q already escapes quotes and backslashes, newlines are the only other thing the format cares about
+func escapeLabel(s string) string {
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	app.metrics.writeTo(w)
+}
+
+// runs the metrics endpoint on its own address so it never ends up on the public port
+func (app *application) serveMetrics() func(ctx context.Context) error {
+	if app.config.metrics.addr == "" {
+		return func(ctx context.Context) error { return nil }
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/metrics", app.metricsHandler)
+
+	srv := &http.Server{
+		Addr:			app.config.metrics.addr,
+		Handler:		mux,
+		IdleTimeout:	time.Minute,
+		ReadTimeout:	5 * time.Second,
+		WriteTimeout:	10 * time.Second,
+	}
+
+	go func() {
+		app.logger.PrintInfo("starting metrics server", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			app.logger.PrintError(err, map[string]string{
+				"addr": srv.Addr,
+			})
+		}
+	}()
+
+	return srv.Shutdown
+}
@@ -1,19 +1,64 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"golang.org/x/time/rate"
 )
 
+// tags the request with an id, reusing X-Request-ID when a proxy already set one,
+// and echoes it back so clients can quote it. Every *Context log call picks it up
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		// ids from clients or proxies are kept when valid, anything else gets replaced
+		if !jsonlog.ValidRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := jsonlog.WithProperty(r.Context(), jsonlog.RequestIDProperty, id)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				// a handler that already sent part of its body aborts this way, net/http
+				// drops the connection so the client can tell the response is incomplete
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
@@ -79,6 +124,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// response, just like before
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.metrics.rateLimitRejected()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -104,11 +150,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// browsers hide response headers from scripts unless told otherwise
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
 					
 					// check request has http method options
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
 						//w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "If-Match, If-None-Match")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -120,4 +169,248 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		}
 		next.ServeHTTP(w, r)
 	})
+}
+
+// records the status code and body size a handler wrote
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes int
+	wroteHeader bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(status int) {
+	if !mw.wroteHeader {
+		mw.status = status
+		mw.wroteHeader = true
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytes += n
+	return n, err
+}
+
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+type contextKey string
+
+const routeContextKey = contextKey("route")
+
+// what mux matched, filled in by recordRoute for the middleware wrapped around the router
+type matchedRoute struct {
+	template string
+	vars map[string]string
+}
+
+// counts every request, including ones turned away by the rate limiter or that
+// match no route. The route template is filled in by recordRoute once mux has matched
+func (app *application) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		route := &matchedRoute{template: "unmatched"}
+		r = r.WithContext(context.WithValue(r.Context(), routeContextKey, route))
+
+		mw := &metricsResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(mw, r)
+
+		app.metrics.observeRequest(r.Method, route.template, mw.status, mw.bytes, time.Since(start))
+	})
+}
+
+// registered with router.Use so it runs after matching, when mux.CurrentRoute is set
+func (app *application) recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*matchedRoute); ok {
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					route.template = tmpl
+				}
+			}
+			route.vars = mux.Vars(r)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// one line per request, written once the handler is done. Runs inside requestID
+// so the line carries the request id
+func (app *application) accessLog(next http.Handler) http.Handler {
+	exclude := make(map[string]bool, len(app.config.accessLog.exclude))
+	for _, path := range app.config.accessLog.exclude {
+		exclude[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exclude[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		mw := &metricsResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(mw, r)
+
+		properties := map[string]string{
+			"method": r.Method,
+			"path": r.URL.Path,
+			"status": strconv.Itoa(mw.status),
+			"bytes": strconv.Itoa(mw.bytes),
+			"duration": time.Since(start).String(),
+			"client_ip": app.clientIP(r),
+			"user_agent": r.UserAgent(),
+		}
+
+		route := "unmatched"
+		if matched, ok := r.Context().Value(routeContextKey).(*matchedRoute); ok {
+			route = matched.template
+			for k, v := range matched.vars {
+				properties["param."+k] = v
+			}
+		}
+		properties["route"] = route
+
+		// the message is per route so log sampling thins out busy routes, not all of them
+		app.logger.PrintInfoContext(r.Context(), r.Method+" "+route, properties)
+	})
+}
+
+// the address the request came from. X-Forwarded-For is only believed when the
+// connection comes from a trusted proxy, and then only up to the first hop we don't trust
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		ip = hop
+
+		if !app.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (app *application) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var tracer = otel.Tracer("github.com/tclohm/project-pizza/cmd/api")
+
+// registered with router.Use so the span can be named after the matched route,
+// model and sql spans started further down hang off it
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		if id := jsonlog.RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+
+		mw := &metricsResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(mw.status),
+			semconv.HTTPResponseBodySize(mw.bytes),
+		)
+
+		if mw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(mw.status))
+		}
+	})
+}
+
+// routes named with these prefixes stream big request or response bodies, the
+// budget would expire halfway through them. Uploads get -upload-timeout to
+// arrive in instead, exports set their own deadline
+const (
+	uploadRoutePrefix = "upload:"
+	streamRoutePrefix = "stream:"
+)
+
+// gives every request a deadline, the models derive their query deadlines from it
+// and a client that hangs up cancels whatever query is in flight
+func (app *application) requestBudget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			name := route.GetName()
+
+			if strings.HasPrefix(name, uploadRoutePrefix) {
+				// the server's timeouts are sized for ordinary requests, a
+				// 50MB body on a slow link takes minutes
+				rc := http.NewResponseController(w)
+				rc.SetReadDeadline(time.Now().Add(app.config.uploads.timeout))
+				rc.SetWriteDeadline(time.Now().Add(app.config.uploads.timeout))
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if strings.HasPrefix(name, streamRoutePrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.requestBudget)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
\ No newline at end of file
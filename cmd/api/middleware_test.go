@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestBudgetExtendsUploadDeadline(t *testing.T) {
+	app := &application{}
+	app.config.requestBudget = time.Second
+	app.config.uploads.timeout = time.Minute
+
+	readAll := func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/upload", readAll).Methods("POST").Name(uploadRoutePrefix + "test")
+	router.HandleFunc("/other", readAll).Methods("POST")
+	router.Use(app.requestBudget)
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		path 	string
+		want 	int
+	}{
+		{"/upload", http.StatusNoContent},
+		{"/other", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\n", tt.path)
+
+			// the body takes three times the server's read timeout to arrive
+			for i := 0; i < 4; i++ {
+				time.Sleep(75 * time.Millisecond)
+				conn.Write([]byte("x"))
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+	"github.com/tclohm/project-pizza/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+const migrateUsage = "usage: api migrate up|down [N]|status|force N"
+
+// reads migrations from the binary rather than the filesystem. The postgres driver
+// holds a pg_advisory_lock while it migrates, so instances started together with
+// -auto-migrate take turns instead of racing
+func newMigrator(cfg config, logger *jsonlog.Logger) (*migrate.Migrate, error) {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, cfg.db.dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Log = migrateLogger{logger}
+
+	return m, nil
+}
+
+// runs `api migrate ...`, args are what follows "migrate" on the command line
+func runMigrate(cfg config, logger *jsonlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := newMigrator(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		// one step unless told otherwise, rolling back everything is never what you want by accident
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		err = m.Steps(-steps)
+	case "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return errors.New(migrateUsage)
+		}
+		err = m.Force(version)
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	return logMigrationVersion(m, logger)
+}
+
+// applies any pending migrations, used by -auto-migrate before the server starts
+func autoMigrate(cfg config, logger *jsonlog.Logger) error {
+	m, err := newMigrator(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	return logMigrationVersion(m, logger)
+}
+
+func logMigrationVersion(m *migrate.Migrate, logger *jsonlog.Logger) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		logger.PrintInfo("no migrations applied", nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("database schema version", map[string]string{
+		"version": strconv.FormatUint(uint64(version), 10),
+		"dirty": strconv.FormatBool(dirty),
+	})
+
+	return nil
+}
+
+// adapts jsonlog to migrate.Logger, one line per applied migration
+type migrateLogger struct {
+	logger *jsonlog.Logger
+}
+
+func (ml migrateLogger) Printf(format string, v ...interface{}) {
+	ml.logger.PrintInfo(strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
+
+func (ml migrateLogger) Verbose() bool {
+	return false
+}
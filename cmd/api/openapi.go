@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/graphql"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// the swagger-ui-dist release named in swagger-ui/VERSION, make docs/swagger-ui
+// fetches it. Served from the binary so the docs load without reaching a CDN
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+// one operation as the spec describes it. Bodies are given as values of the
+// types the handler decodes and renders, their schemas are read off the json tags
+type apiOperation struct {
+	method 			string
+	// the path exactly as routes() registers it, regexps included
+	path 			string
+	tag 			string
+	summary 		string
+	params 			[]apiParam
+	// what the handler decodes with readJSON, nil when the body isn't JSON
+	input 			interface{}
+	// media types of a body that is read as is
+	inputTypes 		[]string
+	status 			int
+	// what the handler passes to writeResponse, nil when it writes the body itself
+	output 			envelope
+	// media types of a body the handler writes itself
+	outputTypes 	[]string
+	outputHeaders 	[]string
+	errors 			[]int
+	// still served, but clients should move to what the summary names
+	deprecated 		bool
+}
+
+// a query or header parameter, path parameters are read off the path
+type apiParam struct {
+	in 				string
+	name 			string
+	// a value of the type the handler parses the parameter as
+	value 			interface{}
+	required 		bool
+	description 	string
+}
+
+// the paging parameters of a list, see readFilters. sorts are the columns it
+// can be sorted by, each also descending with a leading -
+func pageParams(defaultSort string, sorts ...string) []apiParam {
+	return []apiParam{
+		{in: "query", name: "page", value: 0, description: "page number, pages by offset"},
+		{in: "query", name: "page_size", value: 0, description: "rows per page, 1 to 100, default 20"},
+		{in: "query", name: "after", value: "", description: "next_cursor of the previous page"},
+		{in: "query", name: "before", value: "", description: "prev_cursor of the next page"},
+		{in: "query", name: "limit", value: 0, description: "rows per page when paging by cursor, 1 to 100, default 20"},
+		{in: "query", name: "sort", value: "", description: strings.Join(sorts, ", ") + ", default " + defaultSort},
+	}
+}
+
+var tusHeader = apiParam{in: "header", name: "Tus-Resumable", value: "", required: true, description: "must be " + tusVersion}
+
+// every route in router() has to be listed here, openapi_test.go fails
+// otherwise
+var apiOperations = []apiOperation{
+	{
+		method: http.MethodGet, path: "/v1/healthz", tag: "health",
+		summary: "Liveness, up as long as the process is serving",
+		status: http.StatusOK, output: envelope{"status": "", "system_info": map[string]string{}},
+	},
+	{
+		method: http.MethodGet, path: "/v1/healthcheck", tag: "health",
+		summary: "The old name of /v1/healthz, kept for existing probes until the next release",
+		status: http.StatusOK, output: envelope{"status": "", "system_info": map[string]string{}},
+		deprecated: true,
+	},
+	{
+		method: http.MethodGet, path: "/v1/readyz", tag: "health",
+		summary: "Readiness, answers 503 with the same body when a dependency is down",
+		status: http.StatusOK, output: envelope{"status": "", "checks": map[string]string{}},
+	},
+	{
+		method: http.MethodGet, path: "/v1/openapi.json", tag: "docs",
+		summary: "This document",
+		status: http.StatusOK, outputTypes: []string{mediaTypeJSON},
+	},
+	{
+		method: http.MethodGet, path: "/v1/docs", tag: "docs",
+		summary: "Interactive documentation rendered from this document",
+		status: http.StatusOK, outputTypes: []string{"text/html"},
+	},
+	{
+		method: http.MethodGet, path: "/v1/docs/{file:[A-Za-z0-9._-]+}", tag: "docs",
+		summary: "The swagger-ui scripts and styles the documentation page loads",
+		status: http.StatusOK, outputTypes: []string{"text/css", "text/javascript"},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, path: "/v1/images", tag: "images",
+		summary: "Upload an image as the file field of a multipart form",
+		inputTypes: []string{"multipart/form-data"},
+		status: http.StatusCreated, output: envelope{"image": &data.Image{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodPost, path: "/v1/images/uploads", tag: "images",
+		summary: "Reserve an image and get a signed url to PUT its bytes to",
+		input: createImageUploadInput{},
+		status: http.StatusCreated, output: envelope{"image": &data.Image{}, "upload": signedUpload{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/images/{id:[0-9]+}", tag: "images",
+		summary: "Download a completed image",
+		status: http.StatusOK, outputTypes: []string{"image/jpeg", "image/png"}, outputHeaders: []string{"ETag"},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPut, path: "/v1/images/{id:[0-9]+}/upload", tag: "images",
+		summary: "Send the bytes of a reserved image, the url comes from POST /v1/images/uploads",
+		params: []apiParam{
+			{in: "query", name: "expires", value: int64(0), required: true, description: "unix time the url stops working"},
+			{in: "query", name: "signature", value: "", required: true},
+		},
+		inputTypes: []string{"image/jpeg", "image/png"},
+		status: http.StatusOK, output: envelope{"image": &data.Image{}},
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge},
+	},
+	{
+		method: http.MethodPost, path: "/v1/images/{id:[0-9]+}/complete", tag: "images",
+		summary: "Check an uploaded image and mark it ready",
+		status: http.StatusOK, output: envelope{"image": &data.Image{}},
+		errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodOptions, path: "/v1/uploads", tag: "uploads",
+		summary: "The tus versions, extensions and maximum size supported",
+		status: http.StatusNoContent, outputHeaders: []string{"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size"},
+	},
+	{
+		method: http.MethodPost, path: "/v1/uploads", tag: "uploads",
+		summary: "Start a resumable tus upload",
+		params: []apiParam{
+			tusHeader,
+			{in: "header", name: "Upload-Length", value: int64(0), required: true},
+			{in: "header", name: "Upload-Metadata", value: "", description: "filename and content_type, base64 encoded"},
+		},
+		status: http.StatusCreated, output: envelope{"image": &data.Image{}, "upload": &data.Upload{}},
+		outputHeaders: []string{"Location", "Tus-Resumable", "Upload-Expires"},
+		errors: []int{http.StatusBadRequest, http.StatusPreconditionFailed, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodHead, path: "/v1/uploads/{id:[0-9]+}", tag: "uploads",
+		summary: "How far an upload got",
+		params: []apiParam{tusHeader},
+		status: http.StatusOK, outputHeaders: []string{"Tus-Resumable", "Upload-Offset", "Upload-Length", "Upload-Expires"},
+		errors: []int{http.StatusNotFound, http.StatusPreconditionFailed},
+	},
+	{
+		method: http.MethodPatch, path: "/v1/uploads/{id:[0-9]+}", tag: "uploads",
+		summary: "Append a chunk to an upload",
+		params: []apiParam{
+			tusHeader,
+			{in: "header", name: "Upload-Offset", value: int64(0), required: true},
+		},
+		inputTypes: []string{"application/offset+octet-stream"},
+		status: http.StatusNoContent, outputHeaders: []string{"Tus-Resumable", "Upload-Offset", "Upload-Expires"},
+		errors: []int{
+			http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity,
+		},
+	},
+	{
+		method: http.MethodPost, path: "/v1/imports", tag: "imports",
+		summary: "Import venues, pizzas and reviews from a spreadsheet export",
+		inputTypes: []string{"text/csv", "application/x-ndjson"},
+		status: http.StatusAccepted, output: envelope{"import": &data.ImportJob{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType},
+	},
+	{
+		method: http.MethodGet, path: "/v1/imports/{id:[0-9]+}", tag: "imports",
+		summary: "Progress of an import",
+		status: http.StatusOK, output: envelope{"import": &data.ImportJob{}},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, path: "/v1/imports/{id:[0-9]+}/errors", tag: "imports",
+		summary: "The rows an import rejected",
+		status: http.StatusOK, output: envelope{"errors": []*data.ImportError{}},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, path: "/v1/exports/reviews", tag: "exports",
+		summary: "Stream every review with its pizza and venue",
+		params: []apiParam{
+			{in: "query", name: "format", value: "", description: "csv (the default) or ndjson"},
+			{in: "query", name: "from", value: time.Time{}},
+			{in: "query", name: "to", value: time.Time{}},
+		},
+		status: http.StatusOK, outputTypes: []string{"text/csv", "application/x-ndjson"},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/venues", tag: "venues",
+		summary: "List venues, metadata is only there when the list is paged",
+		params: pageParams("id", "id", "name"),
+		status: http.StatusOK, output: envelope{"venues": []*data.Venue{}, "metadata": data.Metadata{}},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodPost, path: "/v1/venues", tag: "venues",
+		summary: "Add a venue",
+		input: createVenueInput{},
+		status: http.StatusCreated, output: envelope{"venue": &data.Venue{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/venues/{id:[0-9]+}", tag: "venues",
+		summary: "Show a venue",
+		status: http.StatusOK, output: envelope{"venue": &data.Venue{}}, outputHeaders: []string{"ETag"},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPatch, path: "/v1/venues/{id:[0-9]+}", tag: "venues",
+		summary: "Change a venue, only the fields sent are updated",
+		params: []apiParam{{in: "header", name: "If-Match", value: "", required: true, description: "the ETag the venue was fetched with"}},
+		input: updateVenueInput{},
+		status: http.StatusOK, output: envelope{"venue": &data.Venue{}}, outputHeaders: []string{"ETag"},
+		errors: []int{
+			http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed,
+			http.StatusUnprocessableEntity, http.StatusPreconditionRequired,
+		},
+	},
+	{
+		method: http.MethodDelete, path: "/v1/venues/{id:[0-9]+}", tag: "venues",
+		summary: "Delete a venue and the record of which pizzas it serves",
+		params: []apiParam{{in: "header", name: "If-Match", value: "", required: true, description: "the ETag the venue was fetched with"}},
+		status: http.StatusOK, output: envelope{"message": ""},
+		errors: []int{http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+	},
+	{
+		method: http.MethodPost, path: "/v1/reviews", tag: "reviews",
+		summary: "Add a review, optionally with images",
+		input: createReviewInput{},
+		status: http.StatusCreated, output: envelope{"review": &data.Review{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/reviews", tag: "reviews",
+		summary: "List reviews, metadata is only there when the list is paged",
+		params: pageParams("id", "id", "created_at", "price", "cheesiness", "flavor", "sauciness", "saltiness", "charness", "spiciness"),
+		status: http.StatusOK, output: envelope{"reviews": []*data.Review{}, "metadata": data.Metadata{}},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/reviews/from={start}-to={end}", tag: "reviews",
+		summary: "Reviews written between two dates",
+		status: http.StatusOK, output: envelope{"reviews": []*data.ReviewWithPizzaName{}},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, path: "/v1/pizzas", tag: "pizzas",
+		summary: "List pizzas, metadata is only there when the list is paged",
+		params: pageParams("id", "id", "name"),
+		status: http.StatusOK, output: envelope{"pizzas": []*data.Pizza{}, "metadata": data.Metadata{}},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodPost, path: "/v1/pizzas", tag: "pizzas",
+		summary: "Add a pizza",
+		input: createPizzaInput{},
+		status: http.StatusCreated, output: envelope{"pizza": &data.Pizza{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/pizzas/{id:[0-9]+}", tag: "pizzas",
+		summary: "Show a pizza",
+		status: http.StatusOK, output: envelope{"pizza": &data.Pizza{}}, outputHeaders: []string{"ETag"},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPatch, path: "/v1/pizzas/{id:[0-9]+}", tag: "pizzas",
+		summary: "Change a pizza, only the fields sent are updated",
+		params: []apiParam{{in: "header", name: "If-Match", value: "", required: true, description: "the ETag the pizza was fetched with"}},
+		input: updatePizzaInput{},
+		status: http.StatusOK, output: envelope{"pizza": &data.Pizza{}}, outputHeaders: []string{"ETag"},
+		errors: []int{
+			http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed,
+			http.StatusUnprocessableEntity, http.StatusPreconditionRequired,
+		},
+	},
+	{
+		method: http.MethodDelete, path: "/v1/pizzas/{id:[0-9]+}", tag: "pizzas",
+		summary: "Delete a pizza",
+		params: []apiParam{{in: "header", name: "If-Match", value: "", required: true, description: "the ETag the pizza was fetched with"}},
+		status: http.StatusOK, output: envelope{"message": ""},
+		errors: []int{http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+	},
+	{
+		method: http.MethodPost, path: "/v1/graphql", tag: "graphql",
+		summary: "Run a GraphQL query or mutation, a request rejected before it runs answers 400 with only errors",
+		input: graphql.Request{},
+		status: http.StatusOK, output: envelope{"data": map[string]interface{}{}, "errors": []*graphql.Error{}},
+		errors: []int{http.StatusBadRequest},
+	},
+	{
+		method: http.MethodPost, path: "/v1/venuepizza", tag: "venuepizzas",
+		summary: "Record that a venue serves a pizza",
+		input: createVenuePizzaInput{},
+		status: http.StatusCreated, output: envelope{"venuepizza": &data.VenuePizza{}}, outputHeaders: []string{"Location"},
+		errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	{
+		method: http.MethodGet, path: "/v1/venuepizzas", tag: "venuepizzas",
+		summary: "Every venue with the pizzas it serves and their reviews",
+		status: http.StatusOK, output: envelope{"venuepizzas": []*data.VenuePizzaMixin{}}, outputHeaders: []string{"Cache-Control", "Age"},
+	},
+	{
+		method: http.MethodGet, path: "/v1/venuepizzas/{pizzaId:[0-9]+}", tag: "venuepizzas",
+		summary: "A pizza with its review and venue",
+		status: http.StatusOK, output: envelope{"venuepizza": &data.Opinion{}}, outputHeaders: []string{"Cache-Control", "Age"},
+		errors: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, path: "/v1/venuepizzas/{venueId:[0-9]+}/pizzas", tag: "venuepizzas",
+		summary: "Every pizza a venue serves with its review, pages come with metadata and are never cached",
+		params: pageParams("-created_at", "created_at", "price", "flavor", "name"),
+		status: http.StatusOK, output: envelope{"venuepizzas": []*data.Opinion{}, "metadata": data.Metadata{}}, outputHeaders: []string{"Cache-Control", "Age"},
+		errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+}
+
+// statuses any route can answer with
+var commonErrors = []int{http.StatusTooManyRequests, http.StatusInternalServerError}
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, app.openAPI, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// swagger-ui pointed at /v1/openapi.json
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// the files of swaggerUI, they only change with the binary
+func (app *application) docsAssetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["file"]
+
+	b, err := fs.ReadFile(swaggerUI, "swagger-ui/"+name)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+}
+
+// checks the routes registered on router against ops both ways, a route without
+// an operation or an operation without a route is a mistake in this file
+func checkAPIOperations(router *mux.Router, ops []apiOperation) error {
+	documented := map[string]bool{}
+	for _, op := range ops {
+		documented[op.method+" "+op.path] = false
+	}
+
+	var missing []string
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// path prefixes and the like, they don't serve anything themselves
+			return nil
+		}
+
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		for _, method := range methods {
+			key := method + " " + path
+			if _, ok := documented[key]; !ok {
+				missing = append(missing, key)
+				continue
+			}
+			documented[key] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for key, registered := range documented {
+		if !registered {
+			stale = append(stale, key)
+		}
+	}
+
+	sort.Strings(stale)
+
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("openapi: routes without an operation: %s", strings.Join(missing, ", "))
+	case len(stale) > 0:
+		return fmt.Errorf("openapi: operations without a route: %s", strings.Join(stale, ", "))
+	}
+
+	return nil
+}
+
+// matches mux path variables, with or without a pattern
+var pathVariableRX = regexp.MustCompile(`\{([^}:]+)(?::([^}]*))?\}`)
+
+// the OpenAPI 3 document for ops, as an envelope so it can be negotiated and
+// cached like any other response
+func openAPIDocument(ops []apiOperation) envelope {
+	schemas := schemaSet{}
+	paths := map[string]map[string]interface{}{}
+
+	for _, op := range ops {
+		path := pathVariableRX.ReplaceAllString(op.path, "{$1}")
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		var params []map[string]interface{}
+
+		for _, match := range pathVariableRX.FindAllStringSubmatch(op.path, -1) {
+			schema := map[string]interface{}{"type": "string"}
+			if match[2] == "[0-9]+" {
+				schema = map[string]interface{}{"type": "integer", "format": "int64"}
+			}
+
+			params = append(params, map[string]interface{}{
+				"in": "path",
+				"name": match[1],
+				"required": true,
+				"schema": schema,
+			})
+		}
+
+		for _, p := range op.params {
+			param := map[string]interface{}{
+				"in": p.in,
+				"name": p.name,
+				"required": p.required,
+				"schema": schemas.schemaOf(reflect.TypeOf(p.value)),
+			}
+
+			if p.description != "" {
+				param["description"] = p.description
+			}
+
+			params = append(params, param)
+		}
+
+		operation := map[string]interface{}{
+			"tags": []string{op.tag},
+			"summary": op.summary,
+			"responses": op.responses(schemas),
+		}
+
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if op.deprecated {
+			operation["deprecated"] = true
+		}
+
+		if body := op.requestBody(schemas); body != nil {
+			operation["requestBody"] = body
+		}
+
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{
+				"description": "a message, or the failed fields and what is wrong with each",
+				"oneOf": []interface{}{
+					map[string]interface{}{"type": "string"},
+					map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}
+
+	return envelope{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title": "project pizza",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func (op apiOperation) requestBody(schemas schemaSet) map[string]interface{} {
+	content := map[string]interface{}{}
+
+	if op.input != nil {
+		content[mediaTypeJSON] = map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.input))}
+	}
+
+	for _, mediaType := range op.inputTypes {
+		schema := map[string]interface{}{"type": "string", "format": "binary"}
+
+		if mediaType == "multipart/form-data" {
+			schema = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{"file": schema},
+				"required": []string{"file"},
+			}
+		}
+
+		content[mediaType] = map[string]interface{}{"schema": schema}
+	}
+
+	if len(content) == 0 {
+		return nil
+	}
+
+	return map[string]interface{}{"required": true, "content": content}
+}
+
+func (op apiOperation) responses(schemas schemaSet) map[string]interface{} {
+	success := map[string]interface{}{"description": http.StatusText(op.status)}
+
+	content := map[string]interface{}{}
+
+	if op.output != nil {
+		schema := schemas.envelopeSchema(op.output)
+
+		content[mediaTypeJSON] = map[string]interface{}{"schema": schema}
+		content[mediaTypeMsgPack] = map[string]interface{}{"schema": schema}
+
+		if listRows(op.output) != nil {
+			content[mediaTypeCSV] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			}
+		}
+	}
+
+	for _, mediaType := range op.outputTypes {
+		content[mediaType] = map[string]interface{}{
+			"schema": map[string]interface{}{"type": "string", "format": "binary"},
+		}
+	}
+
+	if len(content) > 0 {
+		success["content"] = content
+	}
+
+	if len(op.outputHeaders) > 0 {
+		headers := map[string]interface{}{}
+		for _, name := range op.outputHeaders {
+			headers[name] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		success["headers"] = headers
+	}
+
+	responses := map[string]interface{}{strconv.Itoa(op.status): success}
+
+	statuses := append([]int{}, op.errors...)
+	statuses = append(statuses, commonErrors...)
+
+	if op.output != nil {
+		statuses = append(statuses, http.StatusNotAcceptable)
+
+		if op.method == http.MethodGet {
+			responses[strconv.Itoa(http.StatusNotModified)] = map[string]interface{}{
+				"description": http.StatusText(http.StatusNotModified),
+			}
+		}
+	}
+
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content": map[string]interface{}{
+				mediaTypeJSON: map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+
+	return responses
+}
+
+// the component schemas collected so far, by name
+type schemaSet map[string]interface{}
+
+func (schemas schemaSet) envelopeSchema(env envelope) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for key, value := range env {
+		properties[key] = schemas.schemaOf(reflect.TypeOf(value))
+		required = append(required, key)
+	}
+
+	sort.Strings(required)
+
+	return map[string]interface{}{
+		"type": "object",
+		"properties": properties,
+		"required": required,
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// the schema encoding/json output for t follows, named structs become
+// components and are referenced
+func (schemas schemaSet) schemaOf(t reflect.Type) map[string]interface{} {
+	t = recordType(t)
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Uint:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemas.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.structSchema(t)
+		}
+
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			// claimed before the fields are walked in case the type refers to itself
+			schemas[name] = nil
+			schemas[name] = schemas.structSchema(t)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (schemas schemaSet) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+
+	// nothing is marked required, readJSON accepts any subset of the fields
+	// and leaves validation to the handler
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, ok := jsonField(field)
+		if !ok {
+			continue
+		}
+
+		schema := schemas.schemaOf(field.Type)
+
+		// only struct fields are ever nil, the records in lists and envelopes never are
+		if field.Type.Kind() == reflect.Ptr {
+			if _, ok := schema["$ref"]; ok {
+				schema = map[string]interface{}{"allOf": []interface{}{schema}}
+			}
+			schema["nullable"] = true
+		}
+
+		properties[name] = schema
+	}
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
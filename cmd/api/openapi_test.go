@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIOperationsCoverRoutes(t *testing.T) {
+	app := &application{}
+
+	err := checkAPIOperations(app.router(), apiOperations)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAPIOperations(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/pizzas", noop).Methods("GET")
+	router.HandleFunc("/v1/pizzas/{id:[0-9]+}", noop).Methods("GET", "DELETE")
+
+	tests := []struct {
+		name 	string
+		ops 	[]apiOperation
+		want 	string
+	}{
+		{
+			name: "every route documented",
+			ops: []apiOperation{
+				{method: http.MethodGet, path: "/v1/pizzas"},
+				{method: http.MethodGet, path: "/v1/pizzas/{id:[0-9]+}"},
+				{method: http.MethodDelete, path: "/v1/pizzas/{id:[0-9]+}"},
+			},
+		},
+		{
+			name: "route without an operation",
+			ops: []apiOperation{
+				{method: http.MethodGet, path: "/v1/pizzas"},
+				{method: http.MethodGet, path: "/v1/pizzas/{id:[0-9]+}"},
+			},
+			want: "routes without an operation: DELETE /v1/pizzas/{id:[0-9]+}",
+		},
+		{
+			name: "operation without a route",
+			ops: []apiOperation{
+				{method: http.MethodGet, path: "/v1/pizzas"},
+				{method: http.MethodGet, path: "/v1/pizzas/{id:[0-9]+}"},
+				{method: http.MethodDelete, path: "/v1/pizzas/{id:[0-9]+}"},
+				{method: http.MethodPost, path: "/v1/pizzas"},
+			},
+			want: "operations without a route: POST /v1/pizzas",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAPIOperations(router, tt.ops)
+
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.want != "" && err == nil:
+				t.Fatalf("expected an error containing %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Fatalf("got %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocsLoadNothingFromElsewhere(t *testing.T) {
+	for _, attr := range []string{`src="`, `href="`} {
+		for _, part := range strings.Split(string(docsPage), attr)[1:] {
+			if !strings.HasPrefix(part, "/v1/docs/") {
+				t.Errorf("docs.html loads %s%s, want everything from /v1/docs/", attr, strings.SplitN(part, `"`, 2)[0])
+			}
+		}
+	}
+}
+
+func TestDocsAssetHandler(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelError)}
+	router := app.router()
+
+	tests := []struct {
+		path 	string
+		want 	int
+	}{
+		{"/v1/docs/VERSION", http.StatusOK},
+		{"/v1/docs/missing.js", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
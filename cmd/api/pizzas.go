@@ -12,11 +12,13 @@ import (
 	"github.com/gorilla/mux"
 )
 
+type createPizzaInput struct {
+	Name 		string 	`json:"name"`
+	ReviewId	int64 	`json:"review_id"`
+}
+
 func (app *application) createPizzaHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name 		string 	`json:"name"`
-		ReviewId	int64 	`json:"review_id"`
-	}
+	var input createPizzaInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -36,7 +38,7 @@ func (app *application) createPizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Pizzas.Insert(pizza)
+	err = app.models.Pizzas.Insert(r.Context(), pizza)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -45,7 +47,7 @@ func (app *application) createPizzaHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/pizzas/%d", pizza.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"pizza": pizza}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"pizza": pizza}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -61,7 +63,7 @@ func (app *application) showPizzaHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	pizza, err := app.models.Pizzas.Get(n)
+	pizza, err := app.models.Pizzas.Get(r.Context(), n)
 
 	if err != nil {
 		switch {
@@ -74,12 +76,20 @@ func (app *application) showPizzaHandler(w http.ResponseWriter, r *http.Request)
 	}
 	
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"pizza": pizza}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.versionETag(r, pizza.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"pizza": pizza}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+type updatePizzaInput struct {
+	Name 				*string 	`json:"name"`
+	ReviewId			*int64 	 	`json:"review_id"`
+}
+
 func (app *application) updatePizzaHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -90,7 +100,7 @@ func (app *application) updatePizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	pizza, err := app.models.Pizzas.Get(n)
+	pizza, err := app.models.Pizzas.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -101,12 +111,12 @@ func (app *application) updatePizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Name 				*string 	`json:"name"`
-		ReviewId			*int64 	 	`json:review_id`
-
+	if !app.checkIfMatch(w, r, app.versionETag(r, pizza.Version)) {
+		return
 	}
 
+	var input updatePizzaInput
+
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -128,18 +138,22 @@ func (app *application) updatePizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Pizzas.Update(pizza)
+	err = app.models.Pizzas.Update(r.Context(), pizza)
 	if err != nil {
 		switch {
+		// written since If-Match was checked, just as stale
 		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"pizza": pizza}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.versionETag(r, pizza.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"pizza": pizza}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}	
@@ -155,7 +169,7 @@ func (app *application) deletePizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Pizzas.Delete(n)
+	pizza, err := app.models.Pizzas.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -166,21 +180,60 @@ func (app *application) deletePizzaHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "pizza successfully deleted"}, nil)
+	if !app.checkIfMatch(w, r, app.versionETag(r, pizza.Version)) {
+		return
+	}
+
+	// a write landing between the Get and here makes the delete miss, the
+	// client has to look at the pizza again either way
+	err = app.models.Pizzas.Delete(r.Context(), n, pizza.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "pizza successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listPizzasHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters, paged := app.readFilters(r.URL.Query(), "pizzas", "id", []string{"id", "name", "-id", "-name"}, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if paged {
+		pizzas, metadata, err := app.models.Pizzas.List(r.Context(), filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"pizzas": pizzas, "metadata": app.signMetadata("pizzas", metadata)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
-	pizzas, err := app.models.Pizzas.GetAll()
+	pizzas, err := app.models.Pizzas.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"pizzas": pizzas}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"pizzas": pizzas}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	mediaTypeJSON = "application/json"
+	mediaTypeMsgPack = "application/msgpack"
+	mediaTypeCSV = "text/csv"
+)
+
+// what each Accept-able media type is rendered as, in order of preference when
+// a client weighs several the same. MessagePack has gone by a few names
+var responseFormats = []struct {
+	mediaType string
+	format string
+}{
+	{mediaTypeJSON, mediaTypeJSON},
+	{mediaTypeMsgPack, mediaTypeMsgPack},
+	{"application/x-msgpack", mediaTypeMsgPack},
+	{"application/vnd.msgpack", mediaTypeMsgPack},
+	{mediaTypeCSV, mediaTypeCSV},
+}
+
+// renders data in whichever format the request's Accept header prefers. CSV is
+// only offered for list responses, an envelope holding nothing but one slice
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	format, ok := negotiateFormat(r.Header.Get("Accept"), listRows(data) != nil)
+	if !ok {
+		// an error is better understood in the wrong format than swapped for a 406
+		if status >= http.StatusBadRequest {
+			format = mediaTypeJSON
+		} else {
+			app.notAcceptableResponse(w, r)
+			return nil
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	var body []byte
+	var err error
+
+	switch format {
+	case mediaTypeMsgPack:
+		body, err = renderMsgPack(data)
+	case mediaTypeCSV:
+		body, err = renderCSV(listRows(data))
+		format += "; charset=utf-8"
+	default:
+		body, err = renderJSON(data, app.prettyJSON(r))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// every successful read carries an ETag, records with a version set their own
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && status == http.StatusOK {
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			etag = bodyETag(body)
+			w.Header().Set("ETag", etag)
+		}
+
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+			// a 304 isn't compressed, it repeats the tag the client holds
+			// instead, coding and all
+			if matched, ok := etagListMatches(ifNoneMatch, etag, true); ok {
+				if matched != "*" {
+					w.Header().Set("ETag", matched)
+				}
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+// indented for people reading along in development or asking for it with
+// ?pretty=true, compact everywhere else
+func (app *application) prettyJSON(r *http.Request) bool {
+	pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	if err != nil {
+		return app.config.env == "development"
+	}
+
+	return pretty
+}
+
+// picks the format with the highest q value, the most specific media range
+// decides a type's q. No Accept header at all means JSON
+func negotiateFormat(accept string, csvAllowed bool) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypeJSON, true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q float64
+	}
+
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, offer := range responseFormats {
+		if offer.format == mediaTypeCSV && !csvAllowed {
+			continue
+		}
+
+		q, specificity := 0.0, -1
+		for _, mr := range ranges {
+			s := matchMediaRange(mr.mediaType, offer.mediaType)
+			if s > specificity {
+				q, specificity = mr.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer.format, q
+		}
+	}
+
+	return best, best != ""
+}
+
+// how closely a range like text/* covers mediaType, -1 when it doesn't
+func matchMediaRange(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+func renderJSON(data envelope, pretty bool) ([]byte, error) {
+	var js []byte
+	var err error
+
+	if pretty {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(js, '\n'), nil
+}
+
+// field names and omitempty come from the json tags so both formats agree
+func renderMsgPack(data envelope) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+
+	err := enc.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// the slice of records a list response carries, or nil when data is anything else
+func listRows(data envelope) interface{} {
+	// a page's metadata has no place in the rows
+	rows := len(data)
+	if _, ok := data["metadata"]; ok {
+		rows--
+	}
+
+	if rows != 1 {
+		return nil
+	}
+
+	for key, value := range data {
+		if key == "metadata" {
+			continue
+		}
+
+		t := reflect.TypeOf(value)
+		if t == nil || t.Kind() != reflect.Slice {
+			return nil
+		}
+
+		if recordType(t.Elem()).Kind() != reflect.Struct {
+			return nil
+		}
+
+		return value
+	}
+
+	return nil
+}
+
+func recordType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// one row per element and one column per json field of the element type.
+// Each element goes through encoding/json first so tags and MarshalJSON
+// methods shape the columns; nested objects and arrays stay as JSON in their cell
+func renderCSV(rows interface{}) ([]byte, error) {
+	columns := csvColumns(reflect.TypeOf(rows).Elem())
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+
+	err := cw.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(rows)
+	record := make([]string, len(columns))
+
+	for i := 0; i < v.Len(); i++ {
+		js, err := json.Marshal(v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		var fields map[string]json.RawMessage
+
+		err = json.Unmarshal(js, &fields)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, column := range columns {
+			record[j] = csvCell(fields[column])
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cw.Flush()
+
+	return buf.Bytes(), cw.Error()
+}
+
+func csvColumns(t reflect.Type) []string {
+	t = recordType(t)
+
+	var columns []string
+
+	for i := 0; i < t.NumField(); i++ {
+		name, _, ok := jsonField(t.Field(i))
+		if ok {
+			columns = append(columns, name)
+		}
+	}
+
+	return columns
+}
+
+// the name encoding/json gives a struct field and whether it is omitempty,
+// ok is false for fields it leaves out
+func jsonField(field reflect.StructField) (name string, omitempty bool, ok bool) {
+	if field.PkgPath != "" {
+		return "", false, false
+	}
+
+	opts := strings.Split(field.Tag.Get("json"), ",")
+
+	name = opts[0]
+	switch name {
+	case "-":
+		return "", false, false
+	case "":
+		name = field.Name
+	}
+
+	for _, opt := range opts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+
+	return name, omitempty, true
+}
+
+func csvCell(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var s string
+	if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	return string(raw)
+}
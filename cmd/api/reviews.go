@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,19 +13,23 @@ import (
 	"github.com/gorilla/mux"
 )
 
+type createReviewInput struct {
+	Style 				string 	`json:"style"`
+	Price 				float32 `json:"price"`
+	Cheesiness 			float32 `json:"cheesiness"`
+	Flavor 				float32 `json:"flavor"`
+	Sauciness 			float32 `json:"sauciness"`
+	Saltiness 			float32 `json:"saltiness"`
+	Charness 			float32 `json:"charness"`
+	Spiciness 			float32 `json:"spiciness"`
+	Conclusion 			string 	`json:"conclusion"`
+	ImageId				int64 	`json:"image_id"`
+	ImageIds			[]int64 `json:"image_ids"`
+	Captions			[]string `json:"captions"`
+}
+
 func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Style 				string 	`json:"style"`
-		Price 				float32 `json:"price"`
-		Cheesiness 			float32 `json:"cheesiness"`
-		Flavor 				float32 `json:"flavor"`
-		Sauciness 			float32 `json:"sauciness"`
-		Saltiness 			float32 `json:"saltiness"`
-		Charness 			float32 `json:"charness"`
-		Spiciness 			float32 `json:"spiciness"`
-		Conclusion 			string 	`json:"conclusion"`
-		ImageId				int64 	`json:"image_id"`
-	}
+	var input createReviewInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -32,6 +37,37 @@ func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	v := validator.New()
+
+	review, err := app.newReview(r.Context(), input, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(r.Context(), review)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/Reviews/%d", review.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// turns the input into a review whose photos point at finished uploads, the
+// problems with the input end up in v. Shared by the REST and GraphQL endpoints
+func (app *application) newReview(ctx context.Context, input createReviewInput, v *validator.Validator) (*data.Review, error) {
 	review := &data.Review{
 		Style: 				input.Style,
 		Price: 				input.Price,
@@ -43,28 +79,63 @@ func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Reque
 		Spiciness: 			input.Spiciness,
 		Conclusion:   		input.Conclusion,
 		ImageId:			input.ImageId,
+		Images:				[]*data.ReviewImage{},
 	}
 
-	v := validator.New()
+	// image_id is the old single photo field, treat it as a one element image_ids
+	imageIds := input.ImageIds
+	if len(imageIds) == 0 && input.ImageId != 0 {
+		imageIds = []int64{input.ImageId}
+	}
 
-	if data.ValidateReview(v, review); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+	v.Check(len(input.Captions) == 0 || len(input.Captions) == len(imageIds), "captions", "must have one caption per image")
+
+	for i, id := range imageIds {
+		image := &data.ReviewImage{
+			ImageID: id,
+			Position: i,
+		}
+
+		if i < len(input.Captions) {
+			image.Caption = input.Captions[i]
+		}
+
+		review.Images = append(review.Images, image)
 	}
 
-	err = app.models.Reviews.Insert(review)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	// the first photo doubles as the review's cover image for older clients
+	if len(review.Images) > 0 {
+		review.ImageId = review.Images[0].ImageID
 	}
 
-	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/Reviews/%d", review.ID))
+	data.ValidateReview(v, review)
+	data.ValidateReviewImages(v, review.Images)
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !v.Valid() {
+		return review, nil
+	}
+
+	// every photo has to be an upload that actually finished
+	for _, reviewImage := range review.Images {
+		image, err := app.models.Images.Get(ctx, reviewImage.ImageID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("image_ids", fmt.Sprintf("image %d does not exist", reviewImage.ImageID))
+				continue
+			default:
+				return nil, err
+			}
+		}
+
+		v.Check(image.Status == data.ImageStatusReady, "image_ids", fmt.Sprintf("image %d has not finished uploading", reviewImage.ImageID))
+
+		reviewImage.Filename = image.Filename
+		reviewImage.ContentType = image.ContentType
+		reviewImage.Location = image.Location
 	}
+
+	return review, nil
 }
 
 func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request) {
@@ -78,7 +149,7 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 	// 	return
 	// }
 
-	reviews, err := app.models.Reviews.Get(start, end)
+	reviews, err := app.models.Reviews.Get(r.Context(), start, end)
 
 	if err != nil {
 		switch {
@@ -91,7 +162,7 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 	}
 	
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"reviews": reviews}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -107,7 +178,7 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 // 		return
 // 	}
 
-// 	review, err := app.models.Reviews.Get(n)
+// 	review, err := app.models.Reviews.Get(r.Context(), n)
 // 	if err != nil {
 // 		switch {
 // 		case errors.Is(err, data.ErrRecordNotFound):
@@ -185,7 +256,7 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 // 		return
 // 	}
 
-// 	err = app.models.Reviews.Update(review)
+// 	err = app.models.Reviews.Update(r.Context(), review)
 // 	if err != nil {
 // 		switch {
 // 		case errors.Is(err, data.ErrEditConflict):
@@ -196,7 +267,7 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 // 		return
 // 	}
 
-// 	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+// 	err = app.writeResponse(w, r, http.StatusOK, envelope{"review": review}, nil)
 // 	if err != nil {
 // 		app.serverErrorResponse(w, r, err)
 // 	}	
@@ -212,7 +283,7 @@ func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Reviews.Delete(n)
+	err = app.models.Reviews.Delete(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -223,21 +294,47 @@ func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Review successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "Review successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
-	reviews, err := app.models.Reviews.GetAll()
+	v := validator.New()
+
+	filters, paged := app.readFilters(r.URL.Query(), "reviews", "id", []string{
+		"id", "created_at", "price", "cheesiness", "flavor", "sauciness", "saltiness", "charness", "spiciness",
+		"-id", "-created_at", "-price", "-cheesiness", "-flavor", "-sauciness", "-saltiness", "-charness", "-spiciness",
+	}, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if paged {
+		reviews, metadata, err := app.models.Reviews.List(r.Context(), filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"reviews": reviews, "metadata": app.signMetadata("reviews", metadata)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, err := app.models.Reviews.GetAll(r.Context())
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"reviews": reviews}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
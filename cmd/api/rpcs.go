@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// the gRPC methods, each one mirrors its HTTP handler and shares its model
+// calls and validators
+
+func venueMessage(venue *data.Venue) *dynamicpb.Message {
+	return newRPCMessage("Venue", rpcFields{
+		"id": venue.ID,
+		"name": venue.Name,
+		"lat": venue.Lat,
+		"lon": venue.Lon,
+		"address": venue.Address,
+	})
+}
+
+func pizzaMessage(pizza *data.Pizza) *dynamicpb.Message {
+	return newRPCMessage("Pizza", rpcFields{
+		"id": pizza.ID,
+		"name": pizza.Name,
+		"review_id": pizza.ReviewId,
+	})
+}
+
+func reviewMessage(review *data.Review) *dynamicpb.Message {
+	images := make([]*dynamicpb.Message, len(review.Images))
+	for i, image := range review.Images {
+		images[i] = newRPCMessage("ReviewImage", rpcFields{
+			"image_id": image.ImageID,
+			"position": image.Position,
+			"caption": image.Caption,
+			"filename": image.Filename,
+			"content_type": image.ContentType,
+		})
+	}
+
+	return newRPCMessage("Review", rpcFields{
+		"id": review.ID,
+		"style": review.Style,
+		"price": review.Price,
+		"cheesiness": review.Cheesiness,
+		"flavor": review.Flavor,
+		"sauciness": review.Sauciness,
+		"saltiness": review.Saltiness,
+		"charness": review.Charness,
+		"spiciness": review.Spiciness,
+		"conclusion": review.Conclusion,
+		"created_at": review.CreatedAt,
+		"images": images,
+	})
+}
+
+func (app *application) getVenueRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	venue, err := app.models.Venues.Get(ctx, in.Int64("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, rpcNotFound()
+		default:
+			return nil, app.rpcServerError(ctx, err)
+		}
+	}
+
+	return venueMessage(venue), nil
+}
+
+func (app *application) listVenuesRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	venues, err := app.models.Venues.GetAll(ctx)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	messages := make([]*dynamicpb.Message, len(venues))
+	for i, venue := range venues {
+		messages[i] = venueMessage(venue)
+	}
+
+	return newRPCMessage("ListVenuesResponse", rpcFields{"venues": messages}), nil
+}
+
+func (app *application) createVenueRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	venue := &data.Venue{
+		Name: in.String("name"),
+		Lat: in.Float64("lat"),
+		Lon: in.Float64("lon"),
+		Address: in.String("address"),
+	}
+
+	v := validator.New()
+
+	if data.ValidateVenue(v, venue); !v.Valid() {
+		return nil, rpcFailedValidation(v.Errors)
+	}
+
+	err := app.models.Venues.Insert(ctx, venue)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	return venueMessage(venue), nil
+}
+
+func (app *application) getPizzaRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	pizza, err := app.models.Pizzas.Get(ctx, in.Int64("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, rpcNotFound()
+		default:
+			return nil, app.rpcServerError(ctx, err)
+		}
+	}
+
+	return pizzaMessage(pizza), nil
+}
+
+func (app *application) listPizzasRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	pizzas, err := app.models.Pizzas.GetAll(ctx)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	messages := make([]*dynamicpb.Message, len(pizzas))
+	for i, pizza := range pizzas {
+		messages[i] = pizzaMessage(pizza)
+	}
+
+	return newRPCMessage("ListPizzasResponse", rpcFields{"pizzas": messages}), nil
+}
+
+func (app *application) createPizzaRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	pizza := &data.Pizza{
+		Name: in.String("name"),
+		ReviewId: in.Int64("review_id"),
+	}
+
+	v := validator.New()
+
+	if data.ValidatePizza(v, pizza); !v.Valid() {
+		return nil, rpcFailedValidation(v.Errors)
+	}
+
+	err := app.models.Pizzas.Insert(ctx, pizza)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	return pizzaMessage(pizza), nil
+}
+
+func (app *application) getReviewRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	id := in.Int64("id")
+
+	reviews, err := app.models.Graph.ReviewsByID(ctx, []int64{id})
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	review, ok := reviews[id]
+	if !ok {
+		return nil, rpcNotFound()
+	}
+
+	return reviewMessage(review), nil
+}
+
+func (app *application) createReviewRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	input := createReviewInput{
+		Style: in.String("style"),
+		Price: in.Float32("price"),
+		Cheesiness: in.Float32("cheesiness"),
+		Flavor: in.Float32("flavor"),
+		Sauciness: in.Float32("sauciness"),
+		Saltiness: in.Float32("saltiness"),
+		Charness: in.Float32("charness"),
+		Spiciness: in.Float32("spiciness"),
+		Conclusion: in.String("conclusion"),
+		ImageIds: in.Int64s("image_ids"),
+		Captions: in.Strings("captions"),
+	}
+
+	v := validator.New()
+
+	review, err := app.newReview(ctx, input, v)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	if !v.Valid() {
+		return nil, rpcFailedValidation(v.Errors)
+	}
+
+	err = app.models.Reviews.Insert(ctx, review)
+	if err != nil {
+		return nil, app.rpcServerError(ctx, err)
+	}
+
+	return reviewMessage(review), nil
+}
+
+// streams the same rows as GET /v1/exports/reviews, straight off the cursor
+func (app *application) listReviewsRPC(in rpcRequest, stream grpc.ServerStream) error {
+	filter := data.ReviewExportFilter{
+		From: in.Time("from"),
+		To: in.Time("to"),
+	}
+
+	v := validator.New()
+
+	v.Check(filter.From.IsZero() || filter.To.IsZero() || !filter.From.After(filter.To), "from", "must not be after to")
+
+	if !v.Valid() {
+		return rpcFailedValidation(v.Errors)
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), exportTimeout)
+	defer cancel()
+
+	err := app.models.Reviews.Export(ctx, filter, func(row *data.ReviewExport) error {
+		fields := rpcFields{
+			"review": reviewMessage(&data.Review{
+				ID: row.ID,
+				Style: row.Style,
+				Price: row.Price,
+				Cheesiness: row.Cheesiness,
+				Flavor: row.Flavor,
+				Sauciness: row.Sauciness,
+				Saltiness: row.Saltiness,
+				Charness: row.Charness,
+				Spiciness: row.Spiciness,
+				Conclusion: row.Conclusion,
+				CreatedAt: row.CreatedAt,
+			}),
+		}
+
+		if row.PizzaID != nil {
+			fields["pizza"] = pizzaMessage(&data.Pizza{ID: *row.PizzaID, Name: *row.PizzaName, ReviewId: row.ID})
+		}
+
+		if row.VenueID != nil {
+			fields["venue"] = venueMessage(&data.Venue{
+				ID: *row.VenueID,
+				Name: *row.VenueName,
+				Address: *row.VenueAddress,
+				Lat: *row.Lat,
+				Lon: *row.Lon,
+			})
+		}
+
+		return stream.SendMsg(newRPCMessage("ListReviewsResponse", fields))
+	})
+
+	switch {
+	case err == nil:
+		return nil
+	// the client went away or gave up, nothing to log
+	case stream.Context().Err() != nil:
+		return status.FromContextError(stream.Context().Err()).Err()
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "the export took longer than it is allowed to")
+	default:
+		return app.rpcServerError(stream.Context(), err)
+	}
+}
+
+func (app *application) getImageRPC(ctx context.Context, in rpcRequest) (proto.Message, error) {
+	image, err := app.models.Images.Get(ctx, in.Int64("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, rpcNotFound()
+		default:
+			return nil, app.rpcServerError(ctx, err)
+		}
+	}
+
+	return newRPCMessage("Image", rpcFields{
+		"id": image.ID,
+		"filename": image.Filename,
+		"content_type": image.ContentType,
+		"status": image.Status,
+		"checksum": image.Checksum,
+		"created_at": image.CreatedAt,
+	}), nil
+}
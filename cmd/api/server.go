@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
@@ -18,9 +20,20 @@ func (app *application) serve() error {
 		IdleTimeout:	time.Minute,
 		ReadTimeout:	10 * time.Second,
 		WriteTimeout:	30 * time.Second,
+		// tls handshake failures, panics in handlers etc. come through here
+		ErrorLog:		log.New(app.logger, "", 0),
 	}
 
-	shutdownError := make(chan error)
+	// buffered so the shutdown goroutine never blocks once serve has stopped listening
+	shutdownError := make(chan error, 1)
+
+	shutdownGRPC, err := app.serveGRPC()
+	if err != nil {
+		return err
+	}
+
+	stopImageSweeper := app.startImageSweeper()
+	shutdownMetrics := app.serveMetrics()
 
 	// background goroutine
 	go func() {
@@ -39,13 +52,38 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// fail readiness first and give load balancers time to notice, in-flight
+		// and newly routed requests are still served while we wait
+		atomic.StoreInt32(&app.shuttingDown, 1)
+		time.Sleep(app.config.shutdownDrain)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
 		defer cancel()
 
 		// Exit the application with a 0 (success) status code
 		//os.Exit(0)
 
-		shutdownError <- srv.Shutdown(ctx)
+		// every step runs even when one before it failed, serve reports the first
+		// error. shutdownError is sent on exactly once
+		var shutdownErr error
+
+		for _, shutdown := range []func(context.Context) error{srv.Shutdown, shutdownGRPC, shutdownMetrics} {
+			err := shutdown(ctx)
+			if err != nil && shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+
+		app.logger.PrintInfo("stopping background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		stopImageSweeper()
+
+		// imports in progress are allowed to finish their rows
+		app.wg.Wait()
+
+		shutdownError <- shutdownErr
 	}()
 
 	app.logger.PrintInfo("starting server", map[string]string{
@@ -53,7 +91,7 @@ func (app *application) serve() error {
 		"env": app.config.env,
 	})
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// installs the global tracer provider the http middleware, the models and the
+// sql driver all report to. The returned func flushes buffered spans, call it
+// once the server has stopped taking requests
+func setupTracing(cfg config) (func(ctx context.Context) error, error) {
+	// incoming traceparent headers are honoured whether or not we export
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var (
+		exporter sdktrace.SpanExporter
+		file io.Closer
+		err error
+	)
+
+	switch cfg.tracing.exporter {
+	case "", "none":
+		return func(ctx context.Context) error { return nil }, nil
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+
+		// without an endpoint the exporter falls back to OTEL_EXPORTER_OTLP_ENDPOINT, then localhost:4318
+		if cfg.tracing.endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.tracing.endpoint))
+		}
+
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		var w io.Writer = os.Stdout
+
+		if cfg.tracing.file != "" {
+			f, err := os.OpenFile(cfg.tracing.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			w, file = f, f
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.tracing.exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("pizza-api"),
+		semconv.ServiceVersion(version),
+		semconv.DeploymentEnvironment(cfg.env),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	"github.com/gorilla/mux"
+)
+
+// resumable uploads following the tus 1.0 core protocol plus the creation and
+// expiration extensions, see https://tus.io/protocols/resumable-upload
+const tusVersion = "1.0.0"
+
+func (app *application) tusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(app.config.uploads.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		app.tusVersionMismatchResponse(w, r)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("Upload-Length header must be an integer"))
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	image := &data.Image{
+		Filename: metadata["filename"],
+		ContentType: metadata["filetype"],
+		Status: data.ImageStatusPending,
+	}
+
+	upload := &data.Upload{
+		Length: length,
+	}
+
+	v := validator.New()
+
+	data.ValidateImage(v, image)
+	data.ValidateUpload(v, upload, app.config.uploads.maxSize)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image.Location, err = reserveImageFile(image.ContentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Uploads.Insert(r.Context(), image, upload)
+	if err != nil {
+		os.Remove(imagePath(image))
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/uploads/%d", image.ID))
+	headers.Set("Tus-Resumable", tusVersion)
+	headers.Set("Upload-Expires", app.uploadExpires(upload))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"image": image, "upload": upload}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reports how far an upload got so the client knows where to resume from
+func (app *application) showUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		app.tusVersionMismatchResponse(w, r)
+		return
+	}
+
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", app.uploadExpires(upload))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// appends a chunk at the offset the client claims, once the last byte is in
+// the image is checked and marked ready just like completeImageUploadHandler
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		app.tusVersionMismatchResponse(w, r)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.unsupportedMediaTypeResponse(w, r, "application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("Upload-Offset header must be an integer"))
+		return
+	}
+
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	// the lock and the offset have to outlive a client dropping mid-body,
+	// which cancels the request's context, so they only keep its id
+	ctx := jsonlog.WithProperty(context.Background(), jsonlog.RequestIDProperty, jsonlog.RequestID(r.Context()))
+
+	// two requests at the same offset would truncate and write the file under
+	// each other, the row's offset alone can't stop that
+	release, err := app.models.Uploads.Lock(ctx, upload.ImageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.uploadBusyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	defer release()
+
+	// whoever held the lock before may have moved the offset on since the read above
+	upload, err = app.models.Uploads.Get(r.Context(), upload.ImageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if offset != upload.Offset {
+		app.uploadOffsetConflictResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Get(r.Context(), upload.ImageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	file, err := os.OpenFile(imagePath(image), os.O_WRONLY, 0600)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	defer file.Close()
+
+	// anything past the recorded offset is from a chunk we never acknowledged
+	err = file.Truncate(upload.Offset)
+	if err == nil {
+		_, err = file.Seek(upload.Offset, io.SeekStart)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// keep whatever arrived even if the connection drops, that is the whole point
+	n, copyErr := io.Copy(file, http.MaxBytesReader(w, r.Body, upload.Length - upload.Offset))
+
+	err = file.Sync()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	from := upload.Offset
+	upload.Offset += n
+
+	if n > 0 {
+		err = app.models.Uploads.UpdateOffset(ctx, upload, from)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.uploadOffsetConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if copyErr != nil {
+		if isBodyTooLarge(copyErr) {
+			app.requestTooLargeResponse(w, r)
+			return
+		}
+		app.badRequestResponse(w, r, copyErr)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		v := validator.New()
+
+		err = checkImageContent(v, image)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		image.Checksum, err = fileChecksum(imagePath(image))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		image.Status = data.ImageStatusReady
+
+		err = app.models.Images.Update(r.Context(), image)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", app.uploadExpires(upload))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request) (*data.Upload, bool) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	upload, err := app.models.Uploads.Get(r.Context(), n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// unfinished uploads are pending images, so the image sweeper is what expires them
+func (app *application) uploadExpires(upload *data.Upload) string {
+	return upload.CreatedAt.Add(app.config.gc.grace).UTC().Format(http.TimeFormat)
+}
+
+// Upload-Metadata is a comma separated list of "key base64(value)" pairs
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("Upload-Metadata value for %q must be base64 encoded", fields[0])
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("Upload-Metadata header is badly formed")
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+)
+
+// an upload held in memory. UpdateOffset fails on a done context the way a
+// query would, and reports every offset it saves. Lock reports each claim
+type testUploadModel struct {
+	data.MockUploadModel
+	upload 	data.Upload
+	saved 	chan int64
+	mu 		sync.Mutex
+	locked 	chan struct{}
+}
+
+func (um *testUploadModel) Lock(ctx context.Context, imageID int64) (func(), error) {
+	if !um.mu.TryLock() {
+		return nil, data.ErrEditConflict
+	}
+
+	um.locked <- struct{}{}
+
+	return um.mu.Unlock, nil
+}
+
+func (um *testUploadModel) Get(ctx context.Context, imageID int64) (*data.Upload, error) {
+	if imageID != um.upload.ImageID {
+		return nil, data.ErrRecordNotFound
+	}
+
+	upload := um.upload
+	return &upload, nil
+}
+
+func (um *testUploadModel) UpdateOffset(ctx context.Context, upload *data.Upload, from int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if from != um.upload.Offset {
+		return data.ErrEditConflict
+	}
+
+	um.upload.Offset = upload.Offset
+	um.saved <- upload.Offset
+
+	return nil
+}
+
+type testImageModel struct {
+	data.MockImageModel
+	image 	data.Image
+}
+
+func (im testImageModel) Get(ctx context.Context, id int64) (*data.Image, error) {
+	if id != im.image.ID {
+		return nil, data.ErrRecordNotFound
+	}
+
+	image := im.image
+	return &image, nil
+}
+
+// an application serving one pending 200 byte upload from a temporary FILEPATH
+func newUploadTestApp(t *testing.T) (*application, *testUploadModel, string) {
+	t.Helper()
+
+	root := t.TempDir() + string(filepath.Separator)
+	t.Setenv("FILEPATH", root)
+
+	err := os.Mkdir(root+imageDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location := filepath.Join(imageDir, "upload-1.jpg")
+
+	err = os.WriteFile(root+location, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := &testUploadModel{
+		upload: data.Upload{ImageID: 1, Length: 200, CreatedAt: time.Now()},
+		saved: make(chan int64, 1),
+		locked: make(chan struct{}, 1),
+	}
+
+	models := data.NewMockModels()
+	models.Uploads = uploads
+	models.Images = testImageModel{image: data.Image{ID: 1, Location: location, Status: data.ImageStatusPending}}
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelError),
+		models: models,
+	}
+	app.config.uploads.maxSize = 200
+
+	return app, uploads, root + location
+}
+
+// the start of a PATCH sending the first 100 bytes of the test upload, the body follows
+const testPatchHead = "PATCH /v1/uploads/1 HTTP/1.1\r\n" +
+	"Host: localhost\r\n" +
+	"Tus-Resumable: 1.0.0\r\n" +
+	"Content-Type: application/offset+octet-stream\r\n" +
+	"Upload-Offset: 0\r\n" +
+	"Content-Length: 100\r\n" +
+	"\r\n"
+
+func TestPatchUploadKeepsOffsetWhenClientDrops(t *testing.T) {
+	app, uploads, path := newUploadTestApp(t)
+
+	srv := httptest.NewServer(app.router())
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// promise 100 bytes, send 40 and hang up
+	fmt.Fprint(conn, testPatchHead+strings.Repeat("x", 40))
+	conn.Close()
+
+	select {
+	case offset := <-uploads.saved:
+		if offset != 40 {
+			t.Errorf("saved offset %d, want 40", offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the offset of the bytes that arrived was never saved")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 40 {
+		t.Errorf("the file holds %d bytes, want 40", info.Size())
+	}
+}
+
+func TestPatchUploadRejectsConcurrentWriter(t *testing.T) {
+	app, uploads, path := newUploadTestApp(t)
+
+	srv := httptest.NewServer(app.router())
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the first request is mid-body when the second one arrives at the same offset
+	fmt.Fprint(conn, testPatchHead+strings.Repeat("x", 40))
+
+	select {
+	case <-uploads.locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first request never took the lock")
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, srv.URL+"/v1/uploads/1", strings.NewReader(strings.Repeat("y", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("the second request got %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+
+	fmt.Fprint(conn, strings.Repeat("x", 60))
+
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("the first request got %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != strings.Repeat("x", 100) {
+		t.Errorf("the file holds %q, want only the first request's bytes", content)
+	}
+}
@@ -12,11 +12,13 @@ import (
 	"github.com/gorilla/mux"
 )
 
+type createVenuePizzaInput struct {
+	VenueId int64 `json:"venue_id"`
+	PizzaId int64 `json:"pizza_id"`
+}
+
 func (app *application) createVenuePizzaHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		VenueId int64 `json:"venue_id"`
-		PizzaId int64 `json:"pizza_id"`
-	}
+	var input createVenuePizzaInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -35,7 +37,7 @@ func (app *application) createVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.models.VenuePizzas.Insert(venuepizza)
+	err = app.models.VenuePizzas.Insert(r.Context(), venuepizza)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -44,7 +46,7 @@ func (app *application) createVenuePizzaHandler(w http.ResponseWriter, r *http.R
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/venuepizza/%d", venuepizza.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"venuepizza": venuepizza}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"venuepizza": venuepizza}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -61,7 +63,9 @@ func (app *application) showVenuePizzaHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	// MARK: -- getting pizza
-	venuepizza, err := app.models.VenuePizzas.GetPizza(n)
+	ctx, cacheStatus := data.WithCacheStatus(r.Context())
+
+	venuepizza, err := app.models.VenuePizzas.GetPizza(ctx, n)
 
 	if err != nil {
 		switch {
@@ -74,7 +78,7 @@ func (app *application) showVenuePizzaHandler(w http.ResponseWriter, r *http.Req
 	}
 	
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venuepizza": venuepizza}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venuepizza": venuepizza}, app.cacheHeaders(cacheStatus))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -90,7 +94,42 @@ func (app *application) showOtherPizzasFromVenue(w http.ResponseWriter, r *http.
 		return
 	}
 
-	venuepizzas, err := app.models.VenuePizzas.GetPizzasFromVenue(n)
+	v := validator.New()
+
+	scope := fmt.Sprintf("opinions:%d", n)
+
+	filters, paged := app.readFilters(r.URL.Query(), scope, "-created_at", []string{
+		"created_at", "price", "flavor", "name", "-created_at", "-price", "-flavor", "-name",
+	}, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// pages are read straight from the database, only the whole list is cached
+	if paged {
+		venuepizzas, metadata, err := app.models.VenuePizzas.ListPizzasFromVenue(r.Context(), n, filters)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"venuepizzas": venuepizzas, "metadata": app.signMetadata(scope, metadata)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cacheStatus := data.WithCacheStatus(r.Context())
+
+	venuepizzas, err := app.models.VenuePizzas.GetPizzasFromVenue(ctx, n)
 
 	if err != nil {
 		switch {
@@ -102,12 +141,17 @@ func (app *application) showOtherPizzasFromVenue(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venuepizzas": venuepizzas}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venuepizzas": venuepizzas}, app.cacheHeaders(cacheStatus))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+type updateVenuePizzaInput struct {
+	VenueId *int64 `json:"venue_id"`
+	PizzaId *int64 `json:"pizza_id"`
+}
+
 func (app *application) updateVenuePizzaHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -118,7 +162,7 @@ func (app *application) updateVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	venuepizza, err := app.models.VenuePizzas.Get(n)
+	venuepizza, err := app.models.VenuePizzas.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -129,10 +173,7 @@ func (app *application) updateVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	var input struct {
-		VenueId *int64 `json:"venue_id"`
-		PizzaId *int64 `json:"pizza_id"`
-	}
+	var input updateVenuePizzaInput
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -155,7 +196,7 @@ func (app *application) updateVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.models.VenuePizzas.Update(venuepizza)
+	err = app.models.VenuePizzas.Update(r.Context(), venuepizza)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -166,7 +207,7 @@ func (app *application) updateVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venuepizza": venuepizza}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venuepizza": venuepizza}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -182,7 +223,7 @@ func (app *application) deleteVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.models.VenuePizzas.Delete(n)
+	err = app.models.VenuePizzas.Delete(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -193,7 +234,7 @@ func (app *application) deleteVenuePizzaHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "pizza venue connection successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "pizza venue connection successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -201,14 +242,16 @@ func (app *application) deleteVenuePizzaHandler(w http.ResponseWriter, r *http.R
 
 
 func (app *application) listVenuePizzaHandler(w http.ResponseWriter, r *http.Request) {
-	venuepizzas, err := app.models.VenuePizzas.GetAll()
+	ctx, cacheStatus := data.WithCacheStatus(r.Context())
+
+	venuepizzas, err := app.models.VenuePizzas.GetAll(ctx)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venuepizzas": venuepizzas}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venuepizzas": venuepizzas}, app.cacheHeaders(cacheStatus))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -12,13 +12,15 @@ import (
 	"github.com/gorilla/mux"
 )
 
+type createVenueInput struct {
+	Name 	string `json:"name"`
+	Lat 	float64 `json:"lat"`
+	Lon 	float64 `json:"lon"`
+	Address string `json:"address"`
+}
+
 func (app *application) createVenueHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name 	string `json:"name"`
-		Lat 	float64 `json:"lat"`
-		Lon 	float64 `json:"lon"`
-		Address string `json:"address"`
-	}
+	var input createVenueInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -40,7 +42,7 @@ func (app *application) createVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Venues.Insert(venue)
+	err = app.models.Venues.Insert(r.Context(), venue)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -49,7 +51,7 @@ func (app *application) createVenueHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/venues/%d", venue.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"venue": venue}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"venue": venue}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -65,7 +67,7 @@ func (app *application) showVenueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	venue, err := app.models.Venues.Get(n)
+	venue, err := app.models.Venues.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -76,7 +78,53 @@ func (app *application) showVenueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venue": venue}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.versionETag(r, venue.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venue": venue}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type updateVenueInput struct {
+	Name 	*string `json:"name"`
+	Lat 	*float64 `json:"lat"`
+	Lon 	*float64 `json:"lon"`
+	Address *string `json:"address"`
+}
+
+func (app *application) listVenuesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters, paged := app.readFilters(r.URL.Query(), "venues", "id", []string{"id", "name", "-id", "-name"}, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if paged {
+		venues, metadata, err := app.models.Venues.List(r.Context(), filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"venues": venues, "metadata": app.signMetadata("venues", metadata)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	venues, err := app.models.Venues.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venues": venues}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -92,7 +140,7 @@ func (app *application) updateVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	venue, err := app.models.Venues.Get(n)
+	venue, err := app.models.Venues.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -103,13 +151,12 @@ func (app *application) updateVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Name 	*string `json:"name"`
-		Lat 	*float64 `json:"lat"`
-		Lon 	*float64 `json:"lon"`
-		Address *string `json:"address"`
+	if !app.checkIfMatch(w, r, app.versionETag(r, venue.Version)) {
+		return
 	}
 
+	var input updateVenueInput
+
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -139,18 +186,22 @@ func (app *application) updateVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Venues.Update(venue)
+	err = app.models.Venues.Update(r.Context(), venue)
 	if err != nil {
 		switch {
+		// written since If-Match was checked, just as stale
 		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"venue": venue}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.versionETag(r, venue.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"venue": venue}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -166,7 +217,7 @@ func (app *application) deleteVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Venues.Delete(n)
+	venue, err := app.models.Venues.Get(r.Context(), n)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -177,7 +228,24 @@ func (app *application) deleteVenueHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "venue successfully deleted"}, nil)
+	if !app.checkIfMatch(w, r, app.versionETag(r, venue.Version)) {
+		return
+	}
+
+	// a write landing between the Get and here makes the delete miss, the
+	// client has to look at the venue again either way
+	err = app.models.Venues.Delete(r.Context(), n, venue.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "venue successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+func (a *app) createUser(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("users create", flag.ContinueOnError)
+
+	name := fs.String("name", "", "Display name")
+	email := fs.String("email", "", "Email address, must be unique")
+	plaintext := fs.String("password", "", "Password, read from stdin when empty so it stays out of shell history")
+	activated := fs.Bool("activated", false, "Create the account already activated")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *plaintext == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		*plaintext = strings.TrimRight(line, "\r\n")
+	}
+
+	user := &data.User{
+		Name: *name,
+		Email: *email,
+		Activated: *activated,
+	}
+
+	err = user.Password.Set(*plaintext)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = a.models.Users.Insert(ctx, user)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return fmt.Errorf("a user with email %s already exists", user.Email)
+		}
+		return err
+	}
+
+	return a.printUsers([]*data.User{user})
+}
+
+func (a *app) listUsers(ctx context.Context) error {
+	users, err := a.models.Users.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.printUsers(users)
+}
+
+func (a *app) activateUser(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	user, err := a.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	if user.Activated {
+		return a.out.message(user.Email + " is already activated")
+	}
+
+	user.Activated = true
+
+	err = a.models.Users.Update(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return a.printUsers([]*data.User{user})
+}
+
+func (a *app) listPermissions(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	user, err := a.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return a.printPermissions(ctx, user)
+}
+
+func (a *app) grantPermissions(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	user, err := a.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = a.models.Permissions.AddForUser(ctx, user.ID, args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	return a.printPermissions(ctx, user)
+}
+
+func (a *app) revokePermissions(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	user, err := a.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = a.models.Permissions.RemoveForUser(ctx, user.ID, args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	return a.printPermissions(ctx, user)
+}
+
+func (a *app) mergeVenues(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	err = a.models.Venues.Merge(ctx, ids[0], ids[1])
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("venues %d and %d must be two different existing venues", ids[0], ids[1])
+		}
+		return err
+	}
+
+	return a.out.message(fmt.Sprintf("merged venue %d into %d", ids[0], ids[1]))
+}
+
+// stops at the first failure, reviews deleted before it stay deleted
+func (a *app) deleteReviews(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := a.models.Reviews.Delete(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return fmt.Errorf("review %d not found", id)
+			}
+			return err
+		}
+	}
+
+	return a.out.message(fmt.Sprintf("deleted %d reviews", len(ids)))
+}
+
+func (a *app) getUser(ctx context.Context, email string) (*data.User, error) {
+	user, err := a.models.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no user with email %s", email)
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (a *app) printUsers(users []*data.User) error {
+	rows := make([][]string, 0, len(users))
+
+	for _, user := range users {
+		rows = append(rows, []string{
+			strconv.FormatInt(user.ID, 10),
+			user.Name,
+			user.Email,
+			strconv.FormatBool(user.Activated),
+			user.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return a.out.print(users, []string{"ID", "NAME", "EMAIL", "ACTIVATED", "CREATED"}, rows)
+}
+
+func (a *app) printPermissions(ctx context.Context, user *data.User) error {
+	permissions, err := a.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(permissions))
+	for _, code := range permissions {
+		rows = append(rows, []string{user.Email, code})
+	}
+
+	v := map[string]interface{}{"email": user.Email, "permissions": permissions}
+
+	return a.out.print(v, []string{"EMAIL", "PERMISSION"}, rows)
+}
+
+func parseIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func validationError(v *validator.Validator) error {
+	problems := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		problems = append(problems, key+" "+message)
+	}
+
+	sort.Strings(problems)
+
+	return errors.New(strings.Join(problems, ", "))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tclohm/project-pizza/internal/data"
+)
+
+// the same import POST /v1/imports does, but it waits for the job to finish
+func (a *app) runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("imports run", flag.ContinueOnError)
+
+	format := fs.String("format", "", "csv or ndjson (default from the file extension)")
+	batchSize := fs.Int("batch-size", 100, "Rows written per transaction")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	path := fs.Arg(0)
+
+	if *format == "" {
+		switch filepath.Ext(path) {
+		case ".csv":
+			*format = data.ImportFormatCSV
+		case ".ndjson", ".jsonl":
+			*format = data.ImportFormatNDJSON
+		default:
+			return errors.New("can't tell the format from the file name, pass -format")
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	rows, err := data.ParseImport(*format, f)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		return errors.New("import contains no rows")
+	}
+
+	job := &data.ImportJob{
+		Format: *format,
+		Status: data.ImportStatusPending,
+		TotalRows: len(rows),
+	}
+
+	err = a.models.Imports.Insert(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	err = a.models.Imports.Run(ctx, job, rows, *batchSize)
+	if err != nil {
+		return err
+	}
+
+	err = a.printImport(job)
+	if err != nil {
+		return err
+	}
+
+	if job.FailedRows > 0 {
+		fmt.Fprintf(os.Stderr, "%d rows failed, see pizzactl imports errors %d\n", job.FailedRows, job.ID)
+	}
+
+	return nil
+}
+
+func (a *app) showImport(ctx context.Context, args []string) error {
+	job, err := a.getImport(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	return a.printImport(job)
+}
+
+func (a *app) listImportErrors(ctx context.Context, args []string) error {
+	job, err := a.getImport(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	importErrors, err := a.models.Imports.GetErrors(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(importErrors))
+	for _, importError := range importErrors {
+		rows = append(rows, []string{strconv.Itoa(importError.Line), importError.Field, importError.Message})
+	}
+
+	return a.out.print(importErrors, []string{"LINE", "FIELD", "MESSAGE"}, rows)
+}
+
+func (a *app) getImport(ctx context.Context, args []string) (*data.ImportJob, error) {
+	if len(args) != 1 {
+		return nil, errUsage
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := a.models.Imports.Get(ctx, ids[0])
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, fmt.Errorf("import %d not found", ids[0])
+		}
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (a *app) printImport(job *data.ImportJob) error {
+	row := []string{
+		strconv.FormatInt(job.ID, 10),
+		job.Format,
+		job.Status,
+		strconv.Itoa(job.TotalRows),
+		strconv.Itoa(job.ImportedRows),
+		strconv.Itoa(job.FailedRows),
+	}
+
+	return a.out.print(job, []string{"ID", "FORMAT", "STATUS", "TOTAL", "IMPORTED", "FAILED"}, [][]string{row})
+}
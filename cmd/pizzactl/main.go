@@ -0,0 +1,168 @@
+// pizzactl fixes data without hand written SQL against production, run
+// pizzactl -help for the commands
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/cache"
+	"github.com/tclohm/project-pizza/internal/data"
+
+	_ "github.com/lib/pq"
+)
+
+const usage = `usage: pizzactl [flags] <command> <subcommand> [args]
+
+commands:
+  users create -name NAME -email EMAIL [-password PASSWORD] [-activated]
+  users list
+  users activate EMAIL
+  permissions list EMAIL
+  permissions grant EMAIL CODE...
+  permissions revoke EMAIL CODE...
+  venues merge DUPLICATE_ID KEEP_ID
+  reviews delete ID...
+  imports run [-format csv|ndjson] [-batch-size N] FILE
+  imports show ID
+  imports errors ID
+
+flags:
+`
+
+var errUsage = errors.New("invalid arguments, run pizzactl -help")
+
+type app struct {
+	models data.Models
+	out output
+}
+
+func main() {
+	var (
+		dsn string
+		redisAddr string
+		format string
+		timeout time.Duration
+	)
+
+	flag.StringVar(&dsn, "db-ds", os.Getenv("PIZZA_DB_DSN"), "PostgreSQL DSN")
+	flag.StringVar(&redisAddr, "cache-redis-addr", os.Getenv("PIZZA_REDIS_ADDR"), "Redis cache the API runs with -cache=redis, writes purge it")
+	flag.StringVar(&format, "output", "table", "Output format (table|json)")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "Deadline for the whole command")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if format != "table" && format != "json" {
+		fail(errors.New("-output must be table or json"))
+	}
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		fail(err)
+	}
+
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		fail(err)
+	}
+
+	// an API with -cache=memory can't be reached from here, its entries expire on
+	// their own. pizzactl only writes, the ttl is the API's default and goes unused
+	models := data.NewModels(db)
+	if redisAddr != "" {
+		models = data.NewCachedModels(db, cache.NewRedis(redisAddr, "pizza:", 1), 30*time.Second)
+	}
+
+	a := &app{
+		models: models,
+		out: output{format: format, w: os.Stdout},
+	}
+
+	err = a.run(ctx, flag.Arg(0), flag.Arg(1), flag.Args()[2:])
+	if err != nil {
+		db.Close()
+		fail(err)
+	}
+}
+
+func (a *app) run(ctx context.Context, command, subcommand string, args []string) error {
+	switch command + " " + subcommand {
+	case "users create":
+		return a.createUser(ctx, args)
+	case "users list":
+		return a.listUsers(ctx)
+	case "users activate":
+		return a.activateUser(ctx, args)
+	case "permissions list":
+		return a.listPermissions(ctx, args)
+	case "permissions grant":
+		return a.grantPermissions(ctx, args)
+	case "permissions revoke":
+		return a.revokePermissions(ctx, args)
+	case "venues merge":
+		return a.mergeVenues(ctx, args)
+	case "reviews delete":
+		return a.deleteReviews(ctx, args)
+	case "imports run":
+		return a.runImport(ctx, args)
+	case "imports show":
+		return a.showImport(ctx, args)
+	case "imports errors":
+		return a.listImportErrors(ctx, args)
+	default:
+		return errUsage
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "pizzactl:", err)
+	os.Exit(1)
+}
+
+// table output is for people, json for scripts piping into jq
+type output struct {
+	format string
+	w io.Writer
+}
+
+func (o output) print(v interface{}, headers []string, rows [][]string) error {
+	if o.format == "json" {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// used by commands that change something, so json callers still get an object back
+func (o output) message(message string) error {
+	return o.print(map[string]string{"message": message}, []string{"MESSAGE"}, [][]string{{message}})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/tclohm/project-pizza/internal/data"
+)
+
+var (
+	venuePrefixes = []string{"Tony's", "Mama Rosa's", "Luigi's", "Slice of", "Brooklyn", "Sal's", "Golden Crust", "Fire & Dough", "Nonna's", "Little Napoli"}
+	venueSuffixes = []string{"Pizzeria", "Pizza", "Slice Shop", "Pizza Bar", "Trattoria", "Pie Co."}
+	streets = []string{"Main St", "Sunset Blvd", "Olive Ave", "Broadway", "Vermont Ave", "Pico Blvd", "Figueroa St", "Melrose Ave"}
+	pizzaNames = []string{"Margherita", "Pepperoni", "Marinara", "Quattro Formaggi", "Diavola", "Hawaiian", "Sausage & Peppers", "White Pie", "Grandma", "Vodka"}
+	// styles and what a pie of that style roughly costs
+	styles = []struct {
+		name string
+		price float64
+	}{
+		{"Neapolitan", 18},
+		{"New York", 4},
+		{"Detroit", 22},
+		{"Chicago deep dish", 28},
+		{"Sicilian", 20},
+		{"Roman", 16},
+	}
+)
+
+type generator struct {
+	rnd *rand.Rand
+	bbox box
+}
+
+func newGenerator(rnd *rand.Rand, bbox box) *generator {
+	return &generator{rnd: rnd, bbox: bbox}
+}
+
+func (g *generator) pick(list []string) string {
+	return list[g.rnd.Intn(len(list))]
+}
+
+func (g *generator) venue() *data.Venue {
+	return &data.Venue{
+		Name: g.pick(venuePrefixes) + " " + g.pick(venueSuffixes),
+		Lat: g.bbox.minLat + g.rnd.Float64()*(g.bbox.maxLat-g.bbox.minLat),
+		Lon: g.bbox.minLon + g.rnd.Float64()*(g.bbox.maxLon-g.bbox.minLon),
+		Address: fmt.Sprintf("%d %s", 100+g.rnd.Intn(9900), g.pick(streets)),
+	}
+}
+
+// a venue's overall standard on the 0-5 scale, most places are middling
+func (g *generator) quality() float64 {
+	return clamp(3+g.rnd.NormFloat64()*0.9, 0.5, 5)
+}
+
+func (g *generator) pizzaName() string {
+	return g.pick(pizzaNames)
+}
+
+// the flavor scores all move with the venue's quality, a good kitchen tends to be
+// good at everything. Spiciness is a matter of taste so it ignores it
+func (g *generator) review(quality float64) *data.Review {
+	style := styles[g.rnd.Intn(len(styles))]
+
+	score := func() float32 {
+		return float32(round(clamp(quality+g.rnd.NormFloat64()*0.6, 0, 5)))
+	}
+
+	review := &data.Review{
+		Style: style.name,
+		Price: float32(math.Round(clamp(style.price*(0.8+g.rnd.Float64()*0.5), 1, 500))),
+		Cheesiness: score(),
+		Flavor: score(),
+		Sauciness: score(),
+		Saltiness: score(),
+		Charness: score(),
+		Spiciness: float32(round(g.rnd.Float64() * 5)),
+	}
+
+	mean := (review.Cheesiness + review.Flavor + review.Sauciness + review.Saltiness + review.Charness) / 5
+
+	switch {
+	case mean >= 4.2:
+		review.Conclusion = "RECOMMENDED"
+	case mean >= 3.4:
+		review.Conclusion = "SATISFIED"
+	case mean >= 2.5:
+		review.Conclusion = "CONTENT"
+	case mean >= 1.5:
+		review.Conclusion = "DISSATISFIED"
+	default:
+		review.Conclusion = "STAY AWAY"
+	}
+
+	return review
+}
+
+// a crust coloured disc with a sauce centre and a few toppings, enough to tell photos apart
+func (g *generator) writePNG(path string) (string, error) {
+	const size = 320
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	background := color.RGBA{uint8(200 + g.rnd.Intn(56)), uint8(200 + g.rnd.Intn(56)), uint8(200 + g.rnd.Intn(56)), 255}
+	crust := color.RGBA{214, 160, 90, 255}
+	sauce := color.RGBA{uint8(170 + g.rnd.Intn(60)), 40, 30, 255}
+	topping := color.RGBA{uint8(g.rnd.Intn(120)), uint8(80 + g.rnd.Intn(120)), uint8(g.rnd.Intn(80)), 255}
+
+	type dot struct{ x, y, r float64 }
+	toppings := make([]dot, 6+g.rnd.Intn(10))
+	for i := range toppings {
+		angle := g.rnd.Float64() * 2 * math.Pi
+		dist := g.rnd.Float64() * 100
+		toppings[i] = dot{size/2 + math.Cos(angle)*dist, size/2 + math.Sin(angle)*dist, 8 + g.rnd.Float64()*8}
+	}
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := math.Hypot(float64(x)-size/2, float64(y)-size/2)
+
+			c := background
+			switch {
+			case d < 120:
+				c = sauce
+				for _, t := range toppings {
+					if math.Hypot(float64(x)-t.x, float64(y)-t.y) < t.r {
+						c = topping
+						break
+					}
+				}
+			case d < 140:
+				c = crust
+			}
+
+			img.Set(x, y, c)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+
+	err = png.Encode(io.MultiWriter(f, hash), img)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// scores are entered in half points
+func round(v float64) float64 {
+	return math.Round(v*2) / 2
+}
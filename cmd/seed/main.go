@@ -0,0 +1,201 @@
+// seed fills an empty database with made up venues, pizzas and reviews so
+// GET /v1/venuepizzas has something to show. The same -seed always produces the same data
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tclohm/project-pizza/internal/data"
+	"github.com/tclohm/project-pizza/internal/jsonlog"
+
+	_ "github.com/lib/pq"
+)
+
+type config struct {
+	dsn string
+	seed int64
+	venues int
+	maxReviews int
+	bbox box
+	imageDir string
+	force bool
+}
+
+// a lat/lon bounding box venues are scattered in
+type box struct {
+	minLat, minLon, maxLat, maxLon float64
+}
+
+func (b *box) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.minLat, b.minLon, b.maxLat, b.maxLon)
+}
+
+func (b *box) Set(val string) error {
+	parts := strings.Split(val, ",")
+	if len(parts) != 4 {
+		return errors.New("want minLat,minLon,maxLat,maxLon")
+	}
+
+	var values [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return err
+		}
+		values[i] = f
+	}
+
+	if values[0] >= values[2] || values[1] >= values[3] {
+		return errors.New("min must be below max")
+	}
+
+	b.minLat, b.minLon, b.maxLat, b.maxLon = values[0], values[1], values[2], values[3]
+	return nil
+}
+
+func main() {
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	// Los Angeles
+	cfg := config{bbox: box{33.70, -118.67, 34.34, -118.15}}
+
+	flag.StringVar(&cfg.dsn, "db-ds", os.Getenv("PIZZA_DB_DSN"), "PostgreSQL DSN")
+	flag.Int64Var(&cfg.seed, "seed", 1, "Random seed, the same seed gives the same data")
+	flag.IntVar(&cfg.venues, "venues", 25, "Number of venues to create")
+	flag.IntVar(&cfg.maxReviews, "max-reviews", 6, "Most reviewed pizzas per venue")
+	flag.Var(&cfg.bbox, "bbox", "City bounding box as minLat,minLon,maxLat,maxLon")
+	flag.StringVar(&cfg.imageDir, "image-dir", "uploads", "Directory placeholder images are written to, relative to where the API runs")
+	flag.BoolVar(&cfg.force, "force", false, "Seed even if the database already has venues")
+	flag.Parse()
+
+	if cfg.venues < 1 || cfg.maxReviews < 1 {
+		logger.PrintFatal(errors.New("-venues and -max-reviews must be at least 1"), nil)
+	}
+
+	db, err := sql.Open("postgres", cfg.dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	counts, err := seed(ctx, cfg, data.NewModels(db))
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("database seeded", counts)
+}
+
+func seed(ctx context.Context, cfg config, models data.Models) (map[string]string, error) {
+	existing, err := models.Venues.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(existing) > 0 && !cfg.force {
+		return nil, errors.New("database already has venues, pass -force to seed anyway")
+	}
+
+	err = os.MkdirAll(cfg.imageDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newGenerator(rand.New(rand.NewSource(cfg.seed)), cfg.bbox)
+
+	var reviews, images int
+
+	for i := 0; i < cfg.venues; i++ {
+		venue := g.venue()
+
+		err := models.Venues.Insert(ctx, venue)
+		if err != nil {
+			return nil, err
+		}
+
+		// every venue has a house standard its reviews scatter around
+		quality := g.quality()
+		count := 1 + g.rnd.Intn(cfg.maxReviews)
+
+		for j := 0; j < count; j++ {
+			image, err := writeImage(ctx, cfg, g, models, images)
+			if err != nil {
+				return nil, err
+			}
+			images++
+
+			review := g.review(quality)
+			review.ImageId = image.ID
+			review.Images = []*data.ReviewImage{{ImageID: image.ID, Position: 0, Caption: review.Style}}
+
+			err = models.Reviews.Insert(ctx, review)
+			if err != nil {
+				return nil, err
+			}
+			reviews++
+
+			pizza := &data.Pizza{Name: g.pizzaName(), ReviewId: review.ID}
+
+			err = models.Pizzas.Insert(ctx, pizza)
+			if err != nil {
+				return nil, err
+			}
+
+			err = models.VenuePizzas.Insert(ctx, &data.VenuePizza{VenueId: venue.ID, PizzaId: pizza.ID})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return map[string]string{
+		"seed": strconv.FormatInt(cfg.seed, 10),
+		"venues": strconv.Itoa(cfg.venues),
+		"reviews": strconv.Itoa(reviews),
+		"images": strconv.Itoa(images),
+	}, nil
+}
+
+// draws a placeholder pizza, saves it where the API serves images from and records it as ready
+func writeImage(ctx context.Context, cfg config, g *generator, models data.Models, n int) (*data.Image, error) {
+	name := fmt.Sprintf("seed-%d-%d.png", cfg.seed, n)
+	location := filepath.Join(cfg.imageDir, name)
+
+	checksum, err := g.writePNG(location)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &data.Image{
+		Filename: name,
+		ContentType: "image/png",
+		Location: location,
+		Status: data.ImageStatusReady,
+		Checksum: checksum,
+	}
+
+	err = models.Images.Insert(ctx, image)
+	if err != nil {
+		os.Remove(location)
+		return nil, err
+	}
+
+	return image, nil
+}
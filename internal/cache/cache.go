@@ -0,0 +1,15 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a byte store for read-through caching. Entries expire after their ttl
+// and Purge drops every entry at once, there is no per-key invalidation
+type Cache interface {
+	// Get returns the value and when it was stored, ok is false on a miss
+	Get(ctx context.Context, key string) (value []byte, storedAt time.Time, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Purge(ctx context.Context) error
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process LRU, once it holds maxEntries the least recently
+// read entry makes room for the next one
+type Memory struct {
+	mu			sync.Mutex
+	maxEntries	int
+	entries		map[string]*list.Element
+	order		*list.List
+}
+
+type memoryEntry struct {
+	key			string
+	value		[]byte
+	storedAt	time.Time
+	expires		time.Time
+}
+
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		entries: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+
+	entry := el.Value.(*memoryEntry)
+
+	if time.Now().After(entry.expires) {
+		m.remove(el)
+		return nil, time.Time{}, false, nil
+	}
+
+	m.order.MoveToFront(el)
+
+	return entry.value, entry.storedAt, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := m.entries[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.value = value
+		entry.storedAt = now
+		entry.expires = now.Add(ttl)
+		m.order.MoveToFront(el)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{
+		key: key,
+		value: value,
+		storedAt: now,
+		expires: now.Add(ttl),
+	})
+
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+
+	return nil
+}
+
+func (m *Memory) Purge(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = make(map[string]*list.Element)
+	m.order.Init()
+
+	return nil
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.entries, el.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// reads key and fails the test unless it holds want, "" meaning a miss
+func expectEntry(t *testing.T, c Cache, key, want string) {
+	t.Helper()
+
+	value, _, ok, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case want == "" && ok:
+		t.Errorf("%s: got %q, want a miss", key, value)
+	case want != "" && !ok:
+		t.Errorf("%s: got a miss, want %q", key, want)
+	case want != "" && string(value) != want:
+		t.Errorf("%s: got %q, want %q", key, value, want)
+	}
+}
+
+func TestMemoryEvictsLeastRecentlyRead(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+
+	m.Set(ctx, "a", []byte("1"), time.Minute)
+	m.Set(ctx, "b", []byte("2"), time.Minute)
+
+	// reading a makes b the least recently used
+	expectEntry(t, m, "a", "1")
+
+	m.Set(ctx, "c", []byte("3"), time.Minute)
+
+	expectEntry(t, m, "a", "1")
+	expectEntry(t, m, "b", "")
+	expectEntry(t, m, "c", "3")
+}
+
+func TestMemoryOverwriteKeepsOneEntry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+
+	m.Set(ctx, "a", []byte("1"), time.Minute)
+	m.Set(ctx, "b", []byte("2"), time.Minute)
+	m.Set(ctx, "a", []byte("3"), time.Minute)
+	m.Set(ctx, "c", []byte("4"), time.Minute)
+
+	// overwriting a moved it to the front, so b went to make room for c
+	expectEntry(t, m, "a", "3")
+	expectEntry(t, m, "b", "")
+	expectEntry(t, m, "c", "4")
+}
+
+func TestMemoryExpires(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(0)
+
+	m.Set(ctx, "short", []byte("1"), 10*time.Millisecond)
+	m.Set(ctx, "long", []byte("2"), time.Minute)
+
+	time.Sleep(20 * time.Millisecond)
+
+	expectEntry(t, m, "short", "")
+	expectEntry(t, m, "long", "2")
+
+	if m.order.Len() != 1 {
+		t.Errorf("holds %d entries, want the expired one gone", m.order.Len())
+	}
+}
+
+func TestMemoryPurge(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(0)
+
+	before := time.Now()
+	m.Set(ctx, "a", []byte("1"), time.Minute)
+
+	_, storedAt, _, _ := m.Get(ctx, "a")
+	if storedAt.Before(before) || storedAt.After(time.Now()) {
+		t.Errorf("stored at %v, want between %v and now", storedAt, before)
+	}
+
+	err := m.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEntry(t, m, "a", "")
+
+	m.Set(ctx, "a", []byte("2"), time.Minute)
+	expectEntry(t, m, "a", "2")
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// how long a command may take when the caller's context has no deadline
+const redisTimeout = time.Second
+
+// Redis keeps entries in anything that speaks the Redis protocol, so every
+// instance of the API shares them. Only GET, SET with PX and INCR are used.
+// Keys carry a generation number that Purge bumps, the old generation's
+// entries are never read again and age out on their own
+type Redis struct {
+	addr	string
+	prefix	string
+	conns	chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r	*bufio.Reader
+	w	*bufio.Writer
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis doesn't connect until the first command. At most poolSize idle
+// connections are kept, prefix namespaces the keys
+func NewRedis(addr, prefix string, poolSize int) *Redis {
+	return &Redis{
+		addr: addr,
+		prefix: prefix,
+		conns: make(chan *redisConn, poolSize),
+	}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, time.Time, bool, error) {
+	key, err := c.key(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, time.Time{}, false, err
+	}
+
+	// the first 8 bytes are when the entry was stored, in unix milliseconds
+	value, ok := reply.([]byte)
+	if !ok || len(value) < 8 {
+		return nil, time.Time{}, false, fmt.Errorf("redis: malformed entry for %s", key)
+	}
+
+	storedAt := time.UnixMilli(int64(binary.BigEndian.Uint64(value)))
+
+	return value[8:], storedAt, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	key, err := c.key(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	entry := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(entry, uint64(time.Now().UnixMilli()))
+	copy(entry[8:], value)
+
+	_, err = c.do(ctx, "SET", key, string(entry), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (c *Redis) Purge(ctx context.Context) error {
+	_, err := c.do(ctx, "INCR", c.prefix+"generation")
+	return err
+}
+
+// prefixes key with the current generation
+func (c *Redis) key(ctx context.Context, key string) (string, error) {
+	reply, err := c.do(ctx, "GET", c.prefix+"generation")
+	if err != nil {
+		return "", err
+	}
+
+	generation := "0"
+	if b, ok := reply.([]byte); ok {
+		generation = string(b)
+	}
+
+	return c.prefix + generation + ":" + key, nil
+}
+
+// sends one command and reads its reply. A connection that failed partway is
+// closed rather than returned to the pool, it could still hold half a reply
+func (c *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	fmt.Fprintf(conn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(conn.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	err = conn.w.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reply, err := readReply(conn.r)
+	if err != nil {
+		var re redisError
+		if !errors.As(err, &re) {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c.release(conn)
+
+	return reply, err
+}
+
+func (c *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+func (c *Redis) release(conn *redisConn) {
+	select {
+	case c.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// parses one RESP reply: strings and bulk strings come back as []byte,
+// integers as int64, arrays as []interface{} and a nil bulk string as nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(body), nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readReply(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name 	string
+		reply 	string
+		want 	interface{}
+		err 	string
+	}{
+		{name: "simple string", reply: "+OK\r\n", want: []byte("OK")},
+		{name: "error", reply: "-ERR wrong type\r\n", err: "redis: ERR wrong type"},
+		{name: "integer", reply: ":42\r\n", want: int64(42)},
+		{name: "bulk string", reply: "$5\r\na\r\nbc\r\n", want: []byte("a\r\nbc")},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", want: []byte{}},
+		{name: "nil bulk string", reply: "$-1\r\n", want: nil},
+		{name: "array", reply: "*2\r\n$1\r\na\r\n:1\r\n", want: []interface{}{[]byte("a"), int64(1)}},
+		{name: "nil array", reply: "*-1\r\n", want: nil},
+		{name: "unknown type", reply: "?x\r\n", err: `redis: unknown reply type '?'`},
+		{name: "no carriage return", reply: "+OK\n", err: "redis: malformed reply"},
+		{name: "short bulk string", reply: "$5\r\nab", err: "unexpected EOF"},
+		{name: "bad integer", reply: ":4x\r\n", err: `strconv.ParseInt: parsing "4x": invalid syntax`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.reply)))
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// a local stand-in speaking just enough of the Redis protocol for the cache:
+// GET, SET key value PX ms and INCR
+type fakeRedis struct {
+	ln 			net.Listener
+	mu 			sync.Mutex
+	values 		map[string]string
+	ttls 		map[string]string
+	accepted 	int
+	// sent instead of the reply to the next command
+	injected 	string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{ln: ln, values: map[string]string{}, ttls: map[string]string{}}
+
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.accepted++
+		f.mu.Unlock()
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		// commands are arrays of bulk strings, the same thing replies can be
+		command, err := readReply(r)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range command.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		fmt.Fprint(conn, f.reply(args))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.injected != "" {
+		reply := f.injected
+		f.injected = ""
+		return reply
+	}
+
+	switch {
+	case args[0] == "GET" && len(args) == 2:
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case args[0] == "SET" && len(args) == 5 && args[3] == "PX":
+		f.values[args[1]] = args[2]
+		f.ttls[args[1]] = args[4]
+		return "+OK\r\n"
+	case args[0] == "INCR" && len(args) == 2:
+		n, _ := strconv.Atoi(f.values[args[1]])
+		f.values[args[1]] = strconv.Itoa(n + 1)
+		return fmt.Sprintf(":%d\r\n", n+1)
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeRedis) inject(reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.injected = reply
+}
+
+// copies of what the stand-in holds
+func (f *fakeRedis) snapshot() (values, ttls map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values, ttls = map[string]string{}, map[string]string{}
+	for k, v := range f.values {
+		values[k] = v
+	}
+	for k, v := range f.ttls {
+		ttls[k] = v
+	}
+
+	return values, ttls
+}
+
+func (f *fakeRedis) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+func TestRedisSetGet(t *testing.T) {
+	f := newFakeRedis(t)
+	c := NewRedis(f.ln.Addr().String(), "pizza:", 1)
+	ctx := context.Background()
+
+	expectEntry(t, c, "a", "")
+
+	before := time.Now().Truncate(time.Millisecond)
+
+	err := c.Set(ctx, "a", []byte("margherita"), 90*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, storedAt, ok, err := c.Get(ctx, "a")
+	if err != nil || !ok {
+		t.Fatalf("got ok %t and error %v, want a hit", ok, err)
+	}
+
+	if string(value) != "margherita" {
+		t.Errorf("got %q, want \"margherita\"", value)
+	}
+
+	if storedAt.Before(before) || storedAt.After(time.Now()) {
+		t.Errorf("stored at %v, want between %v and now", storedAt, before)
+	}
+
+	if _, ttls := f.snapshot(); ttls["pizza:0:a"] != "90000" {
+		t.Errorf("set with PX %q, want 90000", ttls["pizza:0:a"])
+	}
+}
+
+func TestRedisPurgeMovesToNextGeneration(t *testing.T) {
+	f := newFakeRedis(t)
+	c := NewRedis(f.ln.Addr().String(), "pizza:", 1)
+	ctx := context.Background()
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+
+	err := c.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old entry is still there, it is just never read again
+	expectEntry(t, c, "a", "")
+
+	values, _ := f.snapshot()
+	if values["pizza:generation"] != "1" || values["pizza:0:a"] == "" {
+		t.Errorf("got keys %v, want generation 1 and the old entry left to expire", values)
+	}
+
+	c.Set(ctx, "a", []byte("2"), time.Minute)
+	expectEntry(t, c, "a", "2")
+}
+
+func TestRedisMalformedEntry(t *testing.T) {
+	f := newFakeRedis(t)
+	c := NewRedis(f.ln.Addr().String(), "pizza:", 1)
+
+	// shorter than the stored-at time every entry starts with
+	f.mu.Lock()
+	f.values["pizza:0:a"] = "abc"
+	f.mu.Unlock()
+
+	_, _, ok, err := c.Get(context.Background(), "a")
+	if err == nil || ok {
+		t.Errorf("got ok %t and error %v, want a malformed entry error", ok, err)
+	}
+}
+
+func TestRedisConnectionReuse(t *testing.T) {
+	f := newFakeRedis(t)
+	c := NewRedis(f.ln.Addr().String(), "pizza:", 1)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		c.Set(ctx, "a", []byte("1"), time.Minute)
+		expectEntry(t, c, "a", "1")
+	}
+
+	if n := f.connections(); n != 1 {
+		t.Fatalf("opened %d connections for commands in a row, want 1", n)
+	}
+
+	// an error reply is a whole reply, the connection is still in step
+	f.inject("-ERR busy\r\n")
+
+	err := c.Purge(ctx)
+
+	var re redisError
+	if !errors.As(err, &re) {
+		t.Fatalf("got %v, want the error reply", err)
+	}
+
+	expectEntry(t, c, "a", "1")
+
+	if n := f.connections(); n != 1 {
+		t.Errorf("opened %d connections after an error reply, want it reused", n)
+	}
+
+	// after a reply that can't be parsed the rest of it may still be coming
+	f.inject("?\r\n")
+
+	err = c.Purge(ctx)
+	if err == nil {
+		t.Fatal("got no error for a malformed reply")
+	}
+
+	expectEntry(t, c, "a", "1")
+
+	if n := f.connections(); n != 2 {
+		t.Errorf("opened %d connections after a malformed reply, want a fresh one", n)
+	}
+}
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/cache"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CacheStatus tells a handler whether a cached read was answered from the cache
+// and, if so, when that entry was stored
+type CacheStatus struct {
+	Hit 		bool
+	StoredAt 	time.Time
+}
+
+type cacheStatusContextKey struct{}
+
+// WithCacheStatus returns a context the cached models report into, read the
+// status after the model call returns
+func WithCacheStatus(ctx context.Context) (context.Context, *CacheStatus) {
+	status := &CacheStatus{}
+	return context.WithValue(ctx, cacheStatusContextKey{}, status), status
+}
+
+// NewCachedModels is NewModels with the venue pizza reads served from c for up
+// to ttl. Any write to a review, pizza, venue or venue pizza purges the cache,
+// so does changing or deleting an image, whose delete cascades to its reviews.
+// Writes made by other processes show up once the entries expire
+func NewCachedModels(db *sql.DB, c cache.Cache, ttl time.Duration) Models {
+	models := NewModels(db)
+
+	models.Reviews = purgingReviewModel{tracedReviewModel{ReviewModel{DB: db}}, c}
+	models.Pizzas = purgingPizzaModel{tracedPizzaModel{PizzaModel{DB: db}}, c}
+	models.Venues = purgingVenueModel{tracedVenueModel{VenueModel{DB: db}}, c}
+	models.VenuePizzas = cachedVenuePizzaModel{tracedVenuePizzaModel{VenuePizzaModel{DB: db}}, c, ttl}
+	models.Imports = purgingImportModel{tracedImportModel{ImportModel{DB: db}}, c}
+	models.Images = purgingImageModel{tracedImageModel{ImageModel{DB: db}}, c}
+
+	return models
+}
+
+// a broken cache only costs the round trip to postgres, its errors go on the span
+// instead of failing the request
+func recordCacheError(ctx context.Context, err error) {
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+func purge(ctx context.Context, c cache.Cache, err error) {
+	if err == nil {
+		recordCacheError(ctx, c.Purge(ctx))
+	}
+}
+
+type cachedVenuePizzaModel struct {
+	tracedVenuePizzaModel
+	cache 	cache.Cache
+	ttl 	time.Duration
+}
+
+// decodes the entry for key into dst, false on a miss
+func (cm cachedVenuePizzaModel) load(ctx context.Context, key string, dst interface{}) bool {
+	value, storedAt, ok, err := cm.cache.Get(ctx, key)
+	if err != nil || !ok {
+		recordCacheError(ctx, err)
+		return false
+	}
+
+	err = json.Unmarshal(value, dst)
+	if err != nil {
+		recordCacheError(ctx, err)
+		return false
+	}
+
+	if status, ok := ctx.Value(cacheStatusContextKey{}).(*CacheStatus); ok {
+		status.Hit = true
+		status.StoredAt = storedAt
+	}
+
+	return true
+}
+
+func (cm cachedVenuePizzaModel) store(ctx context.Context, key string, src interface{}) {
+	value, err := json.Marshal(src)
+	if err != nil {
+		recordCacheError(ctx, err)
+		return
+	}
+
+	recordCacheError(ctx, cm.cache.Set(ctx, key, value, cm.ttl))
+}
+
+func (cm cachedVenuePizzaModel) GetAll(ctx context.Context) ([]*VenuePizzaMixin, error) {
+	key := "venuepizzas"
+
+	var venuePizzas []*VenuePizzaMixin
+	if cm.load(ctx, key, &venuePizzas) {
+		return venuePizzas, nil
+	}
+
+	venuePizzas, err := cm.tracedVenuePizzaModel.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.store(ctx, key, venuePizzas)
+
+	return venuePizzas, nil
+}
+
+func (cm cachedVenuePizzaModel) GetPizza(ctx context.Context, id int64) (*Opinion, error) {
+	key := "opinion:" + strconv.FormatInt(id, 10)
+
+	var opinion *Opinion
+	if cm.load(ctx, key, &opinion) {
+		return opinion, nil
+	}
+
+	opinion, err := cm.tracedVenuePizzaModel.GetPizza(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.store(ctx, key, opinion)
+
+	return opinion, nil
+}
+
+func (cm cachedVenuePizzaModel) GetPizzasFromVenue(ctx context.Context, id int64) ([]*Opinion, error) {
+	key := "venue:" + strconv.FormatInt(id, 10) + ":opinions"
+
+	var opinions []*Opinion
+	if cm.load(ctx, key, &opinions) {
+		return opinions, nil
+	}
+
+	opinions, err := cm.tracedVenuePizzaModel.GetPizzasFromVenue(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.store(ctx, key, opinions)
+
+	return opinions, nil
+}
+
+func (cm cachedVenuePizzaModel) Insert(ctx context.Context, venuePizza *VenuePizza) error {
+	err := cm.tracedVenuePizzaModel.Insert(ctx, venuePizza)
+	purge(ctx, cm.cache, err)
+	return err
+}
+
+func (cm cachedVenuePizzaModel) Update(ctx context.Context, venuePizza *VenuePizza) error {
+	err := cm.tracedVenuePizzaModel.Update(ctx, venuePizza)
+	purge(ctx, cm.cache, err)
+	return err
+}
+
+func (cm cachedVenuePizzaModel) Delete(ctx context.Context, id int64) error {
+	err := cm.tracedVenuePizzaModel.Delete(ctx, id)
+	purge(ctx, cm.cache, err)
+	return err
+}
+
+type purgingReviewModel struct {
+	tracedReviewModel
+	cache cache.Cache
+}
+
+func (pm purgingReviewModel) Insert(ctx context.Context, review *Review) error {
+	err := pm.tracedReviewModel.Insert(ctx, review)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingReviewModel) Update(ctx context.Context, review *Review) error {
+	err := pm.tracedReviewModel.Update(ctx, review)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingReviewModel) Delete(ctx context.Context, id int64) error {
+	err := pm.tracedReviewModel.Delete(ctx, id)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+type purgingPizzaModel struct {
+	tracedPizzaModel
+	cache cache.Cache
+}
+
+func (pm purgingPizzaModel) Insert(ctx context.Context, pizza *Pizza) error {
+	err := pm.tracedPizzaModel.Insert(ctx, pizza)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingPizzaModel) Update(ctx context.Context, pizza *Pizza) error {
+	err := pm.tracedPizzaModel.Update(ctx, pizza)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingPizzaModel) Delete(ctx context.Context, id int64, version int) error {
+	err := pm.tracedPizzaModel.Delete(ctx, id, version)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+type purgingVenueModel struct {
+	tracedVenueModel
+	cache cache.Cache
+}
+
+func (pm purgingVenueModel) Insert(ctx context.Context, venue *Venue) error {
+	err := pm.tracedVenueModel.Insert(ctx, venue)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingVenueModel) Update(ctx context.Context, venue *Venue) error {
+	err := pm.tracedVenueModel.Update(ctx, venue)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingVenueModel) Delete(ctx context.Context, id int64, version int) error {
+	err := pm.tracedVenueModel.Delete(ctx, id, version)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingVenueModel) Merge(ctx context.Context, duplicateID, keepID int64) error {
+	err := pm.tracedVenueModel.Merge(ctx, duplicateID, keepID)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+// new images aren't part of any cached read until a review points at them
+type purgingImageModel struct {
+	tracedImageModel
+	cache cache.Cache
+}
+
+func (pm purgingImageModel) Update(ctx context.Context, image *Image) error {
+	err := pm.tracedImageModel.Update(ctx, image)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingImageModel) Delete(ctx context.Context, id int64) error {
+	err := pm.tracedImageModel.Delete(ctx, id)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+func (pm purgingImageModel) DeleteOrphaned(ctx context.Context, id int64) error {
+	err := pm.tracedImageModel.DeleteOrphaned(ctx, id)
+	purge(ctx, pm.cache, err)
+	return err
+}
+
+type purgingImportModel struct {
+	tracedImportModel
+	cache cache.Cache
+}
+
+// batches that made it in stay committed when a later one fails, so purge either way
+func (pm purgingImportModel) Run(ctx context.Context, job *ImportJob, rows []*ImportRow, batchSize int) error {
+	err := pm.tracedImportModel.Run(ctx, job, rows, batchSize)
+	purge(ctx, pm.cache, nil)
+	return err
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/cache"
+)
+
+// a database whose statements all affect rowsAffected rows and whose queries
+// all fail, enough to run the models' deletes without postgres
+type stubConnector struct {
+	rowsAffected int64
+}
+
+func (c stubConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return stubConn{c.rowsAffected}, nil
+}
+
+func (c stubConnector) Driver() driver.Driver {
+	return stubDriver{}
+}
+
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("stub: open through the connector")
+}
+
+type stubConn struct {
+	rowsAffected int64
+}
+
+func (c stubConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("stub: no prepared statements")
+}
+
+func (c stubConn) Close() error {
+	return nil
+}
+
+func (c stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("stub: no transactions")
+}
+
+func (c stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(c.rowsAffected), nil
+}
+
+func (c stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, errors.New("stub: no queries")
+}
+
+func TestCachedModelsPurgeOnWrites(t *testing.T) {
+	deletes := []struct {
+		name 	string
+		delete 	func(ctx context.Context, m Models) error
+	}{
+		{"review", func(ctx context.Context, m Models) error { return m.Reviews.Delete(ctx, 1) }},
+		{"pizza", func(ctx context.Context, m Models) error { return m.Pizzas.Delete(ctx, 1, 1) }},
+		{"venue", func(ctx context.Context, m Models) error { return m.Venues.Delete(ctx, 1, 1) }},
+		{"venue pizza", func(ctx context.Context, m Models) error { return m.VenuePizzas.Delete(ctx, 1) }},
+		{"image", func(ctx context.Context, m Models) error { return m.Images.Delete(ctx, 1) }},
+		{"orphaned image", func(ctx context.Context, m Models) error { return m.Images.DeleteOrphaned(ctx, 1) }},
+	}
+
+	for _, tt := range deletes {
+		for _, rows := range []int64{1, 0} {
+			db := sql.OpenDB(stubConnector{rowsAffected: rows})
+			defer db.Close()
+
+			c := cache.NewMemory(0)
+			models := NewCachedModels(db, c, time.Minute)
+
+			ctx := context.Background()
+			c.Set(ctx, "venuepizzas", []byte("[]"), time.Minute)
+
+			err := tt.delete(ctx, models)
+
+			_, _, cached, _ := c.Get(ctx, "venuepizzas")
+
+			switch {
+			case rows == 1 && err != nil:
+				t.Errorf("%s: %v", tt.name, err)
+			case rows == 1 && cached:
+				t.Errorf("%s: the cache survived a delete", tt.name)
+			case rows == 0 && err == nil:
+				t.Errorf("%s: deleting nothing succeeded", tt.name)
+			case rows == 0 && !cached:
+				t.Errorf("%s: a failed delete purged the cache", tt.name)
+			}
+		}
+	}
+}
+
+func TestCachedModelsServeReadsFromCache(t *testing.T) {
+	db := sql.OpenDB(stubConnector{rowsAffected: 1})
+	defer db.Close()
+
+	c := cache.NewMemory(0)
+	models := NewCachedModels(db, c, time.Minute)
+
+	ctx, status := WithCacheStatus(context.Background())
+	c.Set(ctx, "venuepizzas", []byte(`[{"venue_id":7,"venue_name":"Lucali"}]`), time.Minute)
+
+	// the stub can't answer queries, so this comes from the cache or not at all
+	venuePizzas, err := models.VenuePizzas.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(venuePizzas) != 1 || venuePizzas[0].VenueName != "Lucali" || !status.Hit {
+		t.Fatalf("got %+v with status %+v, want the cached venue and a hit", venuePizzas, status)
+	}
+
+	err = models.Reviews.Delete(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.VenuePizzas.GetAll(ctx)
+	if err == nil {
+		t.Error("got the cached venue pizzas after a write, want the query to run")
+	}
+}
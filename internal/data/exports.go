@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// one review flattened together with its pizza and the venue serving it,
+// the pizza and venue are nil when the review was never linked to one
+type ReviewExport struct {
+	ID 				int64 		`json:"id"`
+	CreatedAt 		time.Time 	`json:"created_at"`
+	Style 			string 		`json:"style"`
+	Price 			float32 	`json:"price"`
+	Cheesiness 		float32 	`json:"cheesiness"`
+	Flavor 			float32 	`json:"flavor"`
+	Sauciness 		float32 	`json:"sauciness"`
+	Saltiness 		float32 	`json:"saltiness"`
+	Charness 		float32 	`json:"charness"`
+	Spiciness 		float32 	`json:"spiciness"`
+	Conclusion 		string 		`json:"conclusion"`
+	PizzaID 		*int64 		`json:"pizza_id"`
+	PizzaName 		*string 	`json:"pizza_name"`
+	VenueID 		*int64 		`json:"venue_id"`
+	VenueName 		*string 	`json:"venue_name"`
+	VenueAddress 	*string 	`json:"venue_address"`
+	Lat 			*float64 	`json:"lat"`
+	Lon 			*float64 	`json:"lon"`
+}
+
+// zero times leave that end of the range open
+type ReviewExportFilter struct {
+	From 	time.Time
+	To 		time.Time
+}
+
+// Export hands every matching review to fn as it comes off the connection so
+// the caller never holds the whole table, returning an error from fn stops the scan.
+// It has no default timeout, the caller's context bounds how long it may run
+func (rm ReviewModel) Export(ctx context.Context, filter ReviewExportFilter, fn func(*ReviewExport) error) error {
+	query := `
+		SELECT
+			reviews.id,
+			reviews.created_at,
+			reviews.style,
+			reviews.price,
+			reviews.cheesiness,
+			reviews.flavor,
+			reviews.sauciness,
+			reviews.saltiness,
+			reviews.charness,
+			reviews.spiciness,
+			reviews.conclusion,
+			pizzas.id,
+			pizzas.name,
+			venues.id,
+			venues.name,
+			venues.address,
+			venues.lat,
+			venues.lon
+		FROM reviews
+		LEFT JOIN pizzas ON pizzas.review_id = reviews.id
+		LEFT JOIN venuepizzas ON venuepizzas.pizza_id = pizzas.id
+		LEFT JOIN venues ON venues.id = venuepizzas.venue_id
+		WHERE ($1::timestamptz IS NULL OR reviews.created_at >= $1)
+		AND ($2::timestamptz IS NULL OR reviews.created_at <= $2)
+		ORDER BY reviews.id, pizzas.id, venues.id
+	`
+
+	rows, err := rm.DB.QueryContext(ctx, tag(ctx, query), nullTime(filter.From), nullTime(filter.To))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			export 			ReviewExport
+			pizzaID 		sql.NullInt64
+			pizzaName 		sql.NullString
+			venueID 		sql.NullInt64
+			venueName 		sql.NullString
+			venueAddress 	sql.NullString
+			lat 			sql.NullFloat64
+			lon 			sql.NullFloat64
+		)
+
+		err := rows.Scan(
+			&export.ID,
+			&export.CreatedAt,
+			&export.Style,
+			&export.Price,
+			&export.Cheesiness,
+			&export.Flavor,
+			&export.Sauciness,
+			&export.Saltiness,
+			&export.Charness,
+			&export.Spiciness,
+			&export.Conclusion,
+			&pizzaID,
+			&pizzaName,
+			&venueID,
+			&venueName,
+			&venueAddress,
+			&lat,
+			&lon,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if pizzaID.Valid {
+			export.PizzaID = &pizzaID.Int64
+			export.PizzaName = &pizzaName.String
+		}
+
+		if venueID.Valid {
+			export.VenueID = &venueID.Int64
+			export.VenueName = &venueName.String
+			export.VenueAddress = &venueAddress.String
+			export.Lat = &lat.Float64
+			export.Lon = &lon.Float64
+		}
+
+		err = fn(&export)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -1,16 +1,31 @@
 package data
 
 import (
+	"fmt"
 	"strings"
 	"math"
+	"reflect"
 	"github.com/tclohm/project-pizza/internal/validator"
 )
 
+// Page > 0 pages by offset. Otherwise the list is paged by keyset: PageSize
+// rows after or before a cursor, or the first PageSize rows without one
 type Filters struct {
 	Page 			int
 	PageSize 		int
 	Sort 			string
 	SortSafelist 	[]string
+	After 			*Cursor
+	Before 			*Cursor
+}
+
+// Cursor is a row's position in a sorted list: the sort, the row's sort key as
+// postgres prints it and the row's id to break ties. Postgres parses its own
+// output back to the same value, floats and timestamps included
+type Cursor struct {
+	Sort 	string 	`json:"s"`
+	Key 	string 	`json:"k"`
+	ID 		int64 	`json:"i"`
 }
 
 // stop sql injection attack
@@ -40,6 +55,113 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// the clauses of a paged query, idColumn must be unique across the rows
+type pageQuery struct {
+	// selects the total for offset paging, 0 for keyset paging where nobody counts
+	total 	string
+	// selects the sort key as text, for the cursors
+	key 	string
+	id 		string
+	// "AND ..." or empty
+	where 	string
+	orderBy string
+	limit 	string
+	args 	[]interface{}
+}
+
+// column is the sort column as the query has to spell it, first the number of
+// the first placeholder the clauses may use
+func (f Filters) pageQuery(column, idColumn string, first int) pageQuery {
+	q := pageQuery{
+		total: "0",
+		key: column + "::text",
+		id: idColumn,
+	}
+
+	ascending := f.sortDirection() == "ASC"
+
+	if f.Page > 0 {
+		q.total = "count(*) OVER()"
+		q.orderBy = fmt.Sprintf("ORDER BY %s %s, %s %s", column, f.sortDirection(), idColumn, f.sortDirection())
+		q.limit = fmt.Sprintf("LIMIT %d OFFSET %d", f.limit(), f.offset())
+		return q
+	}
+
+	cursor := f.After
+
+	// before a cursor the rows are read backwards from it and put back in order afterwards
+	if f.Before != nil {
+		cursor = f.Before
+		ascending = !ascending
+	}
+
+	direction, comparison := "ASC", ">"
+	if !ascending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if cursor != nil {
+		q.where = fmt.Sprintf("AND (%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND %[3]s %[2]s $%[5]d))", column, comparison, idColumn, first, first+1)
+		q.args = []interface{}{cursor.Key, cursor.ID}
+	}
+
+	q.orderBy = fmt.Sprintf("ORDER BY %s %s, %s %s", column, direction, idColumn, direction)
+	// one more than asked for tells whether there is another page
+	q.limit = fmt.Sprintf("LIMIT %d", f.limit()+1)
+
+	return q
+}
+
+// finishes a page read with pageQuery. rows points at the slice the query
+// filled, keys holds the position of each of its rows
+func (f Filters) paginate(rows interface{}, keys []Cursor, total int) Metadata {
+	if f.Page > 0 {
+		return calculateMetadata(total, f.Page, f.PageSize)
+	}
+
+	slice := reflect.ValueOf(rows).Elem()
+
+	more := slice.Len() > f.limit()
+	if more {
+		slice.SetLen(f.limit())
+		keys = keys[:f.limit()]
+	}
+
+	if f.Before != nil {
+		swap := reflect.Swapper(slice.Interface())
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+
+	if len(keys) == 0 {
+		return metadata
+	}
+
+	first, last := keys[0], keys[len(keys)-1]
+
+	// a page read after a cursor has rows before it, one read before a cursor has rows after it
+	switch {
+	case f.Before != nil:
+		metadata.Next = &last
+		if more {
+			metadata.Prev = &first
+		}
+	case f.After != nil:
+		metadata.Prev = &first
+		if more {
+			metadata.Next = &last
+		}
+	case more:
+		metadata.Next = &last
+	}
+
+	return metadata
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be maximum of 10 million")
@@ -48,12 +170,31 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 }
 
+// checks keyset paging, the cursors themselves are checked as they are decoded
+func ValidateCursorFilters(v *validator.Validator, f Filters) {
+	v.Check(f.PageSize > 0, "limit", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "limit", "must be a maximum of 100")
+	v.Check(f.After == nil || f.Before == nil, "after", "cannot be used together with before")
+	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	for _, cursor := range []*Cursor{f.After, f.Before} {
+		if cursor != nil {
+			v.Check(cursor.Sort == f.Sort, "sort", "must be the sort the cursor was made for")
+		}
+	}
+}
+
 type Metadata struct {
 	CurrentPage 	int `json:"current_page,omitempty"`
 	PageSize		int `json:"page_size,omitempty"`
 	FirstPage		int `json:"first_page,omitempty"`
 	LastPage 		int `json:"last_page,omitempty"`
 	TotalRecords	int `json:"total_records,omitempty"`
+	// keyset paging, the API hands these out signed as next_cursor and prev_cursor
+	Next 			*Cursor `json:"-"`
+	Prev 			*Cursor `json:"-"`
+	NextCursor 		string `json:"next_cursor,omitempty"`
+	PrevCursor 		string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type pagedRow struct {
+	Key 	string
+	ID 		int64
+}
+
+// runs q over rows the way postgres would, comparing the keys as text
+func runPageQuery(rows []pagedRow, q pageQuery) []pagedRow {
+	var limit int
+	fmt.Sscanf(q.limit, "LIMIT %d", &limit)
+
+	descending := strings.Contains(q.orderBy, "DESC")
+
+	less := func(a, b pagedRow) bool {
+		return a.Key < b.Key || (a.Key == b.Key && a.ID < b.ID)
+	}
+
+	page := []pagedRow{}
+	for _, row := range rows {
+		if len(q.args) == 2 {
+			cursor := pagedRow{q.args[0].(string), q.args[1].(int64)}
+
+			if strings.Contains(q.where, ">") && !less(cursor, row) || strings.Contains(q.where, "<") && !less(row, cursor) {
+				continue
+			}
+		}
+		page = append(page, row)
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		if descending {
+			return less(page[j], page[i])
+		}
+		return less(page[i], page[j])
+	})
+
+	if len(page) > limit {
+		page = page[:limit]
+	}
+
+	return page
+}
+
+// reads a page the way the models do
+func readPage(rows []pagedRow, f Filters) ([]pagedRow, Metadata) {
+	page := runPageQuery(rows, f.pageQuery(f.sortColumn(), "id", 1))
+
+	keys := []Cursor{}
+	for _, row := range page {
+		keys = append(keys, Cursor{Sort: f.Sort, Key: row.Key, ID: row.ID})
+	}
+
+	return page, f.paginate(&page, keys, 0)
+}
+
+// prices with ties, in the order of a price sort
+var pagedRows = []pagedRow{{"1", 2}, {"1", 5}, {"1", 9}, {"2", 1}, {"2", 4}, {"3", 3}}
+
+func TestKeysetPaging(t *testing.T) {
+	for _, sortBy := range []string{"price", "-price"} {
+		t.Run(sortBy, func(t *testing.T) {
+			want := append([]pagedRow{}, pagedRows...)
+			if sortBy == "-price" {
+				for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+					want[i], want[j] = want[j], want[i]
+				}
+			}
+
+			f := Filters{PageSize: 2, Sort: sortBy, SortSafelist: []string{"price", "-price"}}
+
+			var forward []pagedRow
+			var last Metadata
+
+			for pages := 0; ; pages++ {
+				page, metadata := readPage(pagedRows, f)
+				forward = append(forward, page...)
+
+				if pages == 0 && metadata.Prev != nil {
+					t.Errorf("the first page has a previous page at %+v", metadata.Prev)
+				}
+
+				if metadata.Next == nil || pages > len(pagedRows) {
+					last = metadata
+					break
+				}
+
+				f.After, f.Before = metadata.Next, nil
+			}
+
+			if !reflect.DeepEqual(forward, want) {
+				t.Fatalf("paging forward got %v, want %v", forward, want)
+			}
+
+			// and back again from the last page
+			var backward []pagedRow
+			metadata := last
+
+			for pages := 0; metadata.Prev != nil && pages <= len(pagedRows); pages++ {
+				f.After, f.Before = nil, metadata.Prev
+
+				var page []pagedRow
+				page, metadata = readPage(pagedRows, f)
+				backward = append(page, backward...)
+
+				if metadata.Next == nil {
+					t.Errorf("a page read backwards has no next page")
+				}
+			}
+
+			if !reflect.DeepEqual(backward, want[:len(want)-2]) {
+				t.Errorf("paging backward got %v, want %v", backward, want[:len(want)-2])
+			}
+		})
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	tests := []struct {
+		name 	string
+		f 		Filters
+		want 	pageQuery
+	}{
+		{
+			name: "by offset",
+			f: Filters{Page: 3, PageSize: 20, Sort: "-price"},
+			want: pageQuery{total: "count(*) OVER()", key: "price::text", id: "id", orderBy: "ORDER BY price DESC, id DESC", limit: "LIMIT 20 OFFSET 40"},
+		},
+		{
+			name: "first page",
+			f: Filters{PageSize: 20, Sort: "price"},
+			want: pageQuery{total: "0", key: "price::text", id: "id", orderBy: "ORDER BY price ASC, id ASC", limit: "LIMIT 21"},
+		},
+		{
+			name: "after",
+			f: Filters{PageSize: 20, Sort: "price", After: &Cursor{Sort: "price", Key: "12.5", ID: 7}},
+			want: pageQuery{
+				total: "0", key: "price::text", id: "id",
+				where: "AND (price > $2 OR (price = $2 AND id > $3))",
+				orderBy: "ORDER BY price ASC, id ASC", limit: "LIMIT 21",
+				args: []interface{}{"12.5", int64(7)},
+			},
+		},
+		{
+			name: "before, descending",
+			f: Filters{PageSize: 20, Sort: "-price", Before: &Cursor{Sort: "-price", Key: "12.5", ID: 7}},
+			want: pageQuery{
+				total: "0", key: "price::text", id: "id",
+				where: "AND (price > $2 OR (price = $2 AND id > $3))",
+				orderBy: "ORDER BY price ASC, id ASC", limit: "LIMIT 21",
+				args: []interface{}{"12.5", int64(7)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.f.pageQuery("price", "id", 2)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,484 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// narrows SearchVenues, zero values leave a filter out
+type VenueFilter struct {
+	// a case-insensitive substring of the name
+	Name 		string
+	// venues within RadiusKm of Lat, Lon, nearest first
+	Lat 		float64
+	Lon 		float64
+	RadiusKm 	float64
+	// venues inside the box, West may be greater than East for a box across the antimeridian
+	Bounds 		*Bounds
+	Limit 		int
+}
+
+type Bounds struct {
+	South 	float64
+	West 	float64
+	North 	float64
+	East 	float64
+}
+
+func ValidateVenueFilter(v *validator.Validator, filter VenueFilter) {
+	v.Check(filter.Lat >= -90 && filter.Lat <= 90, "lat", "must be between -90 and 90")
+	v.Check(filter.Lon >= -180 && filter.Lon <= 180, "lon", "must be between -180 and 180")
+	v.Check(filter.RadiusKm >= 0, "radius_km", "must not be negative")
+	v.Check(filter.RadiusKm <= 20000, "radius_km", "must not be more than 20000")
+	v.Check(filter.Limit > 0, "limit", "must be greater than zero")
+	v.Check(filter.Limit <= 500, "limit", "must be a maximum of 500")
+
+	if b := filter.Bounds; b != nil {
+		v.Check(b.South >= -90 && b.North <= 90, "bounds", "latitudes must be between -90 and 90")
+		v.Check(b.West >= -180 && b.East <= 180 && b.East >= -180 && b.West <= 180, "bounds", "longitudes must be between -180 and 180")
+		v.Check(b.South <= b.North, "bounds", "south must not be north of north")
+	}
+}
+
+// review scores averaged over every review of a venue's pizzas, the averages
+// are nil while there are no reviews
+type Scores struct {
+	Reviews 	int 		`json:"reviews"`
+	Price 		*float64 	`json:"price"`
+	Cheesiness 	*float64 	`json:"cheesiness"`
+	Flavor 		*float64 	`json:"flavor"`
+	Sauciness 	*float64 	`json:"sauciness"`
+	Saltiness 	*float64 	`json:"saltiness"`
+	Charness 	*float64 	`json:"charness"`
+	Spiciness 	*float64 	`json:"spiciness"`
+}
+
+// GraphModel answers the lookups behind the GraphQL resolvers. Each takes the
+// ids of every parent in a query level at once so a list of venues with their
+// pizzas is two queries rather than one per venue. Ids that match nothing are
+// missing from the maps
+type GraphModel struct {
+	DB *sql.DB
+}
+
+func (gm GraphModel) SearchVenues(ctx context.Context, filter VenueFilter) ([]*Venue, error) {
+	query := `
+		SELECT id, name, lat, lon, address, version
+		FROM venues
+		WHERE ($1::text = '' OR name ILIKE '%' || $1::text || '%' ESCAPE '\')
+		AND ($4::float8 = 0 OR 12742 * asin(least(1, sqrt(
+			power(sin(radians(lat - $2::float8) / 2), 2) +
+			cos(radians($2::float8)) * cos(radians(lat)) * power(sin(radians(lon - $3::float8) / 2), 2)
+		))) <= $4::float8)
+		AND ($5::float8 IS NULL OR lat BETWEEN $5::float8 AND $7::float8)
+		AND ($5::float8 IS NULL OR
+			($6::float8 <= $8::float8 AND lon BETWEEN $6::float8 AND $8::float8) OR
+			($6::float8 > $8::float8 AND (lon >= $6::float8 OR lon <= $8::float8)))
+		ORDER BY
+			CASE WHEN $4::float8 = 0 THEN 0
+			ELSE (lat - $2::float8) ^ 2 + ((lon - $3::float8) * cos(radians($2::float8))) ^ 2 END,
+			id
+		LIMIT $9`
+
+	var south, west, north, east sql.NullFloat64
+	if b := filter.Bounds; b != nil {
+		south = sql.NullFloat64{Float64: b.South, Valid: true}
+		west = sql.NullFloat64{Float64: b.West, Valid: true}
+		north = sql.NullFloat64{Float64: b.North, Valid: true}
+		east = sql.NullFloat64{Float64: b.East, Valid: true}
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query),
+		likeEscape(filter.Name), filter.Lat, filter.Lon, filter.RadiusKm,
+		south, west, north, east, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	venues := []*Venue{}
+
+	for rows.Next() {
+		var venue Venue
+
+		err := rows.Scan(&venue.ID, &venue.Name, &venue.Lat, &venue.Lon, &venue.Address, &venue.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		venues = append(venues, &venue)
+	}
+
+	return venues, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// s with the LIKE wildcards escaped, so a name containing % or _ matches them
+// literally rather than anything
+func likeEscape(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func (gm GraphModel) VenuesByID(ctx context.Context, ids []int64) (map[int64]*Venue, error) {
+	query := `
+		SELECT id, name, lat, lon, address, version
+		FROM venues
+		WHERE id = ANY($1)`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	venues := map[int64]*Venue{}
+
+	for rows.Next() {
+		var venue Venue
+
+		err := rows.Scan(&venue.ID, &venue.Name, &venue.Lat, &venue.Lon, &venue.Address, &venue.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		venues[venue.ID] = &venue
+	}
+
+	return venues, rows.Err()
+}
+
+// the venues serving each pizza
+func (gm GraphModel) VenuesByPizza(ctx context.Context, pizzaIDs []int64) (map[int64][]*Venue, error) {
+	query := `
+		SELECT venuepizzas.pizza_id, venues.id, venues.name, venues.lat, venues.lon, venues.address, venues.version
+		FROM venuepizzas
+		JOIN venues ON venues.id = venuepizzas.venue_id
+		WHERE venuepizzas.pizza_id = ANY($1)
+		ORDER BY venues.id`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), pq.Array(pizzaIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	venues := map[int64][]*Venue{}
+
+	for rows.Next() {
+		var pizzaID int64
+		var venue Venue
+
+		err := rows.Scan(&pizzaID, &venue.ID, &venue.Name, &venue.Lat, &venue.Lon, &venue.Address, &venue.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		venues[pizzaID] = append(venues[pizzaID], &venue)
+	}
+
+	return venues, rows.Err()
+}
+
+func (gm GraphModel) PizzasByID(ctx context.Context, ids []int64) (map[int64]*Pizza, error) {
+	query := `
+		SELECT id, name, COALESCE(review_id, 0), version
+		FROM pizzas
+		WHERE id = ANY($1)`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	pizzas := map[int64]*Pizza{}
+
+	for rows.Next() {
+		var pizza Pizza
+
+		err := rows.Scan(&pizza.ID, &pizza.Name, &pizza.ReviewId, &pizza.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		pizzas[pizza.ID] = &pizza
+	}
+
+	return pizzas, rows.Err()
+}
+
+// the pizzas each venue serves
+func (gm GraphModel) PizzasByVenue(ctx context.Context, venueIDs []int64) (map[int64][]*Pizza, error) {
+	query := `
+		SELECT venuepizzas.venue_id, pizzas.id, pizzas.name, COALESCE(pizzas.review_id, 0), pizzas.version
+		FROM venuepizzas
+		JOIN pizzas ON pizzas.id = venuepizzas.pizza_id
+		WHERE venuepizzas.venue_id = ANY($1)
+		ORDER BY pizzas.id`
+
+	return gm.groupedPizzas(ctx, query, venueIDs)
+}
+
+// the pizzas each review was written about
+func (gm GraphModel) PizzasByReview(ctx context.Context, reviewIDs []int64) (map[int64][]*Pizza, error) {
+	query := `
+		SELECT review_id, id, name, review_id, version
+		FROM pizzas
+		WHERE review_id = ANY($1)
+		ORDER BY id`
+
+	return gm.groupedPizzas(ctx, query, reviewIDs)
+}
+
+// runs a query whose rows are a parent id followed by a pizza
+func (gm GraphModel) groupedPizzas(ctx context.Context, query string, ids []int64) (map[int64][]*Pizza, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	pizzas := map[int64][]*Pizza{}
+
+	for rows.Next() {
+		var parentID int64
+		var pizza Pizza
+
+		err := rows.Scan(&parentID, &pizza.ID, &pizza.Name, &pizza.ReviewId, &pizza.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		pizzas[parentID] = append(pizzas[parentID], &pizza)
+	}
+
+	return pizzas, rows.Err()
+}
+
+// the reviews with their photos
+func (gm GraphModel) ReviewsByID(ctx context.Context, ids []int64) (map[int64]*Review, error) {
+	query := `
+		SELECT
+			id,
+			style,
+			price,
+			cheesiness,
+			flavor,
+			sauciness,
+			saltiness,
+			charness,
+			spiciness,
+			conclusion,
+			COALESCE(image_id, 0),
+			created_at
+		FROM reviews
+		WHERE id = ANY($1)`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reviews := map[int64]*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&review.ID,
+			&review.Style,
+			&review.Price,
+			&review.Cheesiness,
+			&review.Flavor,
+			&review.Sauciness,
+			&review.Saltiness,
+			&review.Charness,
+			&review.Spiciness,
+			&review.Conclusion,
+			&review.ImageId,
+			&review.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reviews[review.ID] = &review
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	found := make([]int64, 0, len(reviews))
+	for id := range reviews {
+		found = append(found, id)
+	}
+
+	images, err := getReviewImages(ctx, gm.DB, found)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, review := range reviews {
+		review.Images = images.of(review.ID)
+	}
+
+	return reviews, nil
+}
+
+// the scores of every listed venue, venues without reviews get zero reviews
+// and nil averages. A nil venueIDs averages every review instead, under key 0
+func (gm GraphModel) Scores(ctx context.Context, venueIDs []int64) (map[int64]*Scores, error) {
+	query := `
+		SELECT
+			venuepizzas.venue_id,
+			count(reviews.id),
+			avg(reviews.price),
+			avg(reviews.cheesiness),
+			avg(reviews.flavor),
+			avg(reviews.sauciness),
+			avg(reviews.saltiness),
+			avg(reviews.charness),
+			avg(reviews.spiciness)
+		FROM venuepizzas
+		JOIN pizzas ON pizzas.id = venuepizzas.pizza_id
+		JOIN reviews ON reviews.id = pizzas.review_id
+		WHERE venuepizzas.venue_id = ANY($1)
+		GROUP BY venuepizzas.venue_id`
+
+	args := []interface{}{pq.Array(venueIDs)}
+
+	if venueIDs == nil {
+		// reviews of pizzas no venue serves count towards the overall scores too
+		query = `
+			SELECT
+				0,
+				count(id),
+				avg(price),
+				avg(cheesiness),
+				avg(flavor),
+				avg(sauciness),
+				avg(saltiness),
+				avg(charness),
+				avg(spiciness)
+			FROM reviews`
+
+		args = nil
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, tag(ctx, query), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	scores := map[int64]*Scores{}
+
+	for rows.Next() {
+		var id int64
+		var s Scores
+		var averages [7]sql.NullFloat64
+
+		err := rows.Scan(&id, &s.Reviews,
+			&averages[0], &averages[1], &averages[2], &averages[3], &averages[4], &averages[5], &averages[6])
+		if err != nil {
+			return nil, err
+		}
+
+		fields := []**float64{&s.Price, &s.Cheesiness, &s.Flavor, &s.Sauciness, &s.Saltiness, &s.Charness, &s.Spiciness}
+		for i, avg := range averages {
+			if avg.Valid {
+				value := avg.Float64
+				*fields[i] = &value
+			}
+		}
+
+		scores[id] = &s
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range venueIDs {
+		if _, ok := scores[id]; !ok {
+			scores[id] = &Scores{}
+		}
+	}
+
+	if venueIDs == nil && scores[0] == nil {
+		scores[0] = &Scores{}
+	}
+
+	return scores, nil
+}
+
+type MockGraphModel struct {}
+
+func (gm MockGraphModel) SearchVenues(ctx context.Context, filter VenueFilter) ([]*Venue, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) VenuesByID(ctx context.Context, ids []int64) (map[int64]*Venue, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) VenuesByPizza(ctx context.Context, pizzaIDs []int64) (map[int64][]*Venue, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) PizzasByID(ctx context.Context, ids []int64) (map[int64]*Pizza, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) PizzasByVenue(ctx context.Context, venueIDs []int64) (map[int64][]*Pizza, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) PizzasByReview(ctx context.Context, reviewIDs []int64) (map[int64][]*Pizza, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) ReviewsByID(ctx context.Context, ids []int64) (map[int64]*Review, error) {
+	return nil, nil
+}
+
+func (gm MockGraphModel) Scores(ctx context.Context, venueIDs []int64) (map[int64]*Scores, error) {
+	return nil, nil
+}
@@ -0,0 +1,22 @@
+package data
+
+import "testing"
+
+func TestLikeEscape(t *testing.T) {
+	tests := []struct {
+		name 	string
+		want 	string
+	}{
+		{"Lucali", "Lucali"},
+		{"100% Pizza", `100\% Pizza`},
+		{"Joe_s", `Joe\_s`},
+		{`Back\slash`, `Back\\slash`},
+		{`\%_`, `\\\%\_`},
+	}
+
+	for _, tt := range tests {
+		if got := likeEscape(tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -12,45 +12,56 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// a pending image has a row but its bytes have not been uploaded and verified yet
+const (
+	ImageStatusPending = "pending"
+	ImageStatusReady = "ready"
+)
+
 type Image struct {
 	ID int64 `json:"id"`
 	Filename string `json:"filename"`
 	ContentType string `json:"content_type"`
 	Location string `json:"location"`
+	Status string `json:"status"`
+	Checksum string `json:"checksum,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 func ValidateImage(v *validator.Validator, image *Image) {
 	v.Check(image.Filename != "", "name", "must be provided")
 	v.Check(image.ContentType == "image/png" || image.ContentType == "image/jpeg", "type", "must be either a jpeg or png")
+	v.Check(validator.In(image.Status, ImageStatusPending, ImageStatusReady), "status", "must be either pending or ready")
 }
 
 type ImageModel struct {
 	DB *sql.DB
 }
 
-func (im ImageModel) Insert(image *Image) error {
+func (im ImageModel) Insert(ctx context.Context, image *Image) error {
 	query := `
 	INSERT INTO images (
 		filename,
 		content_type,
-		location
+		location,
+		status,
+		checksum
 	)
-	VALUES($1, $2, $3)
-	RETURNING id
+	VALUES($1, $2, $3, $4, $5)
+	RETURNING id, created_at
 	`
 
 	args := []interface{}{
-		image.Filename, image.ContentType, image.Location,
+		image.Filename, image.ContentType, image.Location, image.Status, image.Checksum,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	return im.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID)
+	return im.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&image.ID, &image.CreatedAt)
 }
 
-func (im ImageModel) Get(id int64) (*Image, error) {
+func (im ImageModel) Get(ctx context.Context, id int64) (*Image, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -59,22 +70,27 @@ func (im ImageModel) Get(id int64) (*Image, error) {
 		SELECT id, 
 		filename,
 		content_type,
-		location
+		location,
+		status,
+		checksum,
+		created_at
 		FROM images WHERE id = $1
 	`
 
 	var image Image
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	err := im.DB.QueryRowContext(ctx, query, id).Scan(
+	err := im.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
 		&image.ID,
 		&image.Filename,
 		&image.ContentType,
 		&image.Location,
+		&image.Status,
+		&image.Checksum,
+		&image.CreatedAt,
 	)
 
 	if err != nil {
@@ -89,13 +105,15 @@ func (im ImageModel) Get(id int64) (*Image, error) {
 	return &image, nil
 }
 
-func (im ImageModel) Update(image *Image) error {
+func (im ImageModel) Update(ctx context.Context, image *Image) error {
 	query := `
 		UPDATE images
 		SET filename = $1,
 		content_type = $2, 
-		location = $3, 
-		WHERE id = $4
+		location = $3,
+		status = $4,
+		checksum = $5
+		WHERE id = $6
 		RETURNING id
 	`
 
@@ -103,13 +121,15 @@ func (im ImageModel) Update(image *Image) error {
 		image.Filename,
 		image.ContentType,
 		image.Location,
+		image.Status,
+		image.Checksum,
 		image.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 	// query and scan the new value in
-	err := im.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID)
+	err := im.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&image.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -121,7 +141,7 @@ func (im ImageModel) Update(image *Image) error {
 	return nil
 }
 
-func (im ImageModel) Delete(id int64) error {
+func (im ImageModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -130,7 +150,10 @@ func (im ImageModel) Delete(id int64) error {
 		DELETE FROM images
 		WHERE id = $1`
 
-	result, err := im.DB.Exec(query, id)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	result, err := im.DB.ExecContext(ctx, tag(ctx, query), id)
 	if err != nil {
 		return err
 	}
@@ -148,20 +171,126 @@ func (im ImageModel) Delete(id int64) error {
 	return nil
 }
 
+// images created before the cutoff that no review points at
+func (im ImageModel) GetOrphaned(ctx context.Context, cutoff time.Time) ([]*Image, error) {
+	query := `
+		SELECT id,
+		filename,
+		content_type,
+		location,
+		status,
+		checksum,
+		created_at
+		FROM images
+		WHERE created_at < $1
+		AND NOT EXISTS (
+			SELECT 1 FROM reviews WHERE reviews.image_id = images.id
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM review_images WHERE review_images.image_id = images.id
+		)
+		ORDER BY id
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := im.DB.QueryContext(ctx, tag(ctx, query), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	images := []*Image{}
+
+	for rows.Next() {
+		var image Image
+
+		err := rows.Scan(
+			&image.ID,
+			&image.Filename,
+			&image.ContentType,
+			&image.Location,
+			&image.Status,
+			&image.Checksum,
+			&image.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// only deletes the row if it is still unreferenced, a review may have
+// claimed the image since GetOrphaned ran
+func (im ImageModel) DeleteOrphaned(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM images
+		WHERE id = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM reviews WHERE reviews.image_id = images.id
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM review_images WHERE review_images.image_id = images.id
+		)`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	result, err := im.DB.ExecContext(ctx, tag(ctx, query), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 type MockImageModel struct {}
 
-func (pm MockImageModel) Insert(image *Image) error {
+func (pm MockImageModel) Insert(ctx context.Context, image *Image) error {
 	return nil
 }
 
-func (pm MockImageModel) Get(id int64) (*Image, error) {
+func (pm MockImageModel) Get(ctx context.Context, id int64) (*Image, error) {
 	return nil, nil
 }
 
-func (pm MockImageModel) Update(image *Image) error {
+func (pm MockImageModel) Update(ctx context.Context, image *Image) error {
+	return nil
+}
+
+func (pm MockImageModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (pm MockImageModel) Delete(id int64) error {
+func (pm MockImageModel) GetOrphaned(ctx context.Context, cutoff time.Time) ([]*Image, error) {
+	return nil, nil
+}
+
+func (pm MockImageModel) DeleteOrphaned(ctx context.Context, id int64) error {
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,670 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+const (
+	ImportStatusPending = "pending"
+	ImportStatusRunning = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed = "failed"
+)
+
+const (
+	ImportFormatCSV = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// how long a single batch transaction may take
+const importBatchTimeout = time.Minute
+
+// venues with the same name closer than this, in degrees (about 100m), are the same venue
+const venueMatchDistance = 0.001
+
+type ImportJob struct {
+	ID 				int64 		`json:"id"`
+	Format 			string 		`json:"format"`
+	Status 			string 		`json:"status"`
+	TotalRows 		int 		`json:"total_rows"`
+	ImportedRows 	int 		`json:"imported_rows"`
+	FailedRows 		int 		`json:"failed_rows"`
+	CreatedAt 		time.Time 	`json:"created_at"`
+	FinishedAt 		*time.Time 	`json:"finished_at,omitempty"`
+}
+
+// why a line of the input didn't make it in, field is empty for whole-row problems
+type ImportError struct {
+	Line 	int 	`json:"line"`
+	Field 	string 	`json:"field,omitempty"`
+	Message string 	`json:"message"`
+}
+
+// one venue+pizza+review line of an import. Errors holds anything that went wrong
+// turning the line into values, validation happens later
+type ImportRow struct {
+	Line 	int
+	Venue 	Venue
+	Pizza 	Pizza
+	Review 	Review
+	Errors 	map[string]string
+}
+
+// the columns of a csv import and the keys of an ndjson one
+type importRecord struct {
+	VenueName 		string 	`json:"venue_name"`
+	VenueAddress 	string 	`json:"venue_address"`
+	Lat 			float64 `json:"lat"`
+	Lon 			float64 `json:"lon"`
+	PizzaName 		string 	`json:"pizza_name"`
+	Style 			string 	`json:"style"`
+	Price 			float32 `json:"price"`
+	Cheesiness 		float32 `json:"cheesiness"`
+	Flavor 			float32 `json:"flavor"`
+	Sauciness 		float32 `json:"sauciness"`
+	Saltiness 		float32 `json:"saltiness"`
+	Charness 		float32 `json:"charness"`
+	Spiciness 		float32 `json:"spiciness"`
+	Conclusion 		string 	`json:"conclusion"`
+}
+
+var ImportColumns = []string{
+	"venue_name", "venue_address", "lat", "lon", "pizza_name", "style", "price",
+	"cheesiness", "flavor", "sauciness", "saltiness", "charness", "spiciness", "conclusion",
+}
+
+func (rec importRecord) row(line int) *ImportRow {
+	return &ImportRow{
+		Line: line,
+		Venue: Venue{
+			Name: rec.VenueName,
+			Address: rec.VenueAddress,
+			Lat: rec.Lat,
+			Lon: rec.Lon,
+		},
+		Pizza: Pizza{Name: rec.PizzaName},
+		Review: Review{
+			Style: rec.Style,
+			Price: rec.Price,
+			Cheesiness: rec.Cheesiness,
+			Flavor: rec.Flavor,
+			Sauciness: rec.Sauciness,
+			Saltiness: rec.Saltiness,
+			Charness: rec.Charness,
+			Spiciness: rec.Spiciness,
+			Conclusion: rec.Conclusion,
+		},
+		Errors: map[string]string{},
+	}
+}
+
+// ParseImport reads every row of a csv (with a header line) or ndjson import. A
+// row that can't be parsed comes back with Errors set, the error return is only
+// for input that can't be read at all
+func ParseImport(format string, r io.Reader) ([]*ImportRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return parseImportCSV(r)
+	case ImportFormatNDJSON:
+		return parseImportNDJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+func parseImportCSV(r io.Reader) ([]*ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv is empty")
+		}
+		return nil, err
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, column := range ImportColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("csv header is missing the %s column", column)
+		}
+	}
+
+	rows := []*ImportRow{}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			// a malformed line doesn't stop the rest of the file from importing
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rows = append(rows, &ImportRow{Line: parseErr.Line, Errors: map[string]string{"": parseErr.Err.Error()}})
+				continue
+			}
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, csvRow(line, record, index))
+	}
+
+	return rows, nil
+}
+
+func csvRow(line int, record []string, index map[string]int) *ImportRow {
+	field := func(column string) string {
+		i := index[column]
+		if i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	errs := map[string]string{}
+
+	number := func(column string) float64 {
+		value := field(column)
+		if value == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			errs[column] = "must be a number"
+		}
+		return f
+	}
+
+	rec := importRecord{
+		VenueName: field("venue_name"),
+		VenueAddress: field("venue_address"),
+		Lat: number("lat"),
+		Lon: number("lon"),
+		PizzaName: field("pizza_name"),
+		Style: field("style"),
+		Price: float32(number("price")),
+		Cheesiness: float32(number("cheesiness")),
+		Flavor: float32(number("flavor")),
+		Sauciness: float32(number("sauciness")),
+		Saltiness: float32(number("saltiness")),
+		Charness: float32(number("charness")),
+		Spiciness: float32(number("spiciness")),
+		Conclusion: field("conclusion"),
+	}
+
+	row := rec.row(line)
+	row.Errors = errs
+	return row
+}
+
+func parseImportNDJSON(r io.Reader) ([]*ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []*ImportRow{}
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec importRecord
+
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&rec)
+		if err != nil {
+			rows = append(rows, &ImportRow{Line: line, Errors: map[string]string{"": err.Error()}})
+			continue
+		}
+
+		rows = append(rows, rec.row(line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// runs the same checks as the single record endpoints, keys are prefixed with
+// venue./pizza./review. since the three share field names
+func ValidateImportRow(row *ImportRow) map[string]string {
+	errs := map[string]string{}
+
+	for k, v := range row.Errors {
+		errs[k] = v
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	checks := []struct {
+		prefix string
+		validate func(v *validator.Validator)
+	}{
+		{"venue.", func(v *validator.Validator) { ValidateVenue(v, &row.Venue) }},
+		{"pizza.", func(v *validator.Validator) { ValidatePizza(v, &row.Pizza) }},
+		{"review.", func(v *validator.Validator) { ValidateReview(v, &row.Review) }},
+	}
+
+	for _, check := range checks {
+		v := validator.New()
+		check.validate(v)
+
+		for k, message := range v.Errors {
+			errs[check.prefix+k] = message
+		}
+	}
+
+	return errs
+}
+
+type ImportModel struct {
+	DB *sql.DB
+}
+
+func (im ImportModel) Insert(ctx context.Context, job *ImportJob) error {
+	query := `
+	INSERT INTO import_jobs (
+		format,
+		status,
+		total_rows
+	) VALUES ($1, $2, $3)
+	RETURNING id, created_at
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	return im.DB.QueryRowContext(ctx, tag(ctx, query), job.Format, job.Status, job.TotalRows).Scan(&job.ID, &job.CreatedAt)
+}
+
+func (im ImportModel) Get(ctx context.Context, id int64) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id,
+		format,
+		status,
+		total_rows,
+		imported_rows,
+		failed_rows,
+		created_at,
+		finished_at
+		FROM import_jobs
+		WHERE id = $1
+	`
+
+	var job ImportJob
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := im.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
+		&job.ID,
+		&job.Format,
+		&job.Status,
+		&job.TotalRows,
+		&job.ImportedRows,
+		&job.FailedRows,
+		&job.CreatedAt,
+		&job.FinishedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+func (im ImportModel) GetErrors(ctx context.Context, id int64) ([]*ImportError, error) {
+	query := `
+		SELECT line, field, message
+		FROM import_errors
+		WHERE job_id = $1
+		ORDER BY line, id
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := im.DB.QueryContext(ctx, tag(ctx, query), id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	importErrors := []*ImportError{}
+
+	for rows.Next() {
+		var importError ImportError
+
+		err := rows.Scan(&importError.Line, &importError.Field, &importError.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		importErrors = append(importErrors, &importError)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return importErrors, nil
+}
+
+// Run validates rows and writes the good ones batchSize at a time, each batch in
+// its own transaction so a failure only loses that batch. The job's counts and
+// status are kept up to date in the database as it goes. Run is meant to be
+// called on a job that was just inserted and may take a while, ctx should not
+// carry a request deadline
+func (im ImportModel) Run(ctx context.Context, job *ImportJob, rows []*ImportRow, batchSize int) error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	job.Status = ImportStatusRunning
+
+	err := im.updateJob(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	valid := []*ImportRow{}
+	importErrors := []*ImportError{}
+
+	for _, row := range rows {
+		errs := ValidateImportRow(row)
+		if len(errs) == 0 {
+			valid = append(valid, row)
+			continue
+		}
+
+		job.FailedRows++
+		for field, message := range errs {
+			importErrors = append(importErrors, &ImportError{Line: row.Line, Field: field, Message: message})
+		}
+	}
+
+	err = im.insertErrors(ctx, job.ID, importErrors)
+	if err != nil {
+		return im.fail(job, err)
+	}
+
+	for start := 0; start < len(valid); start += batchSize {
+		end := start + batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+
+		batch := valid[start:end]
+
+		err := im.importBatch(ctx, batch)
+		if err != nil {
+			// the context is gone, nothing more can be written
+			if ctx.Err() != nil {
+				return im.fail(job, err)
+			}
+
+			job.FailedRows += len(batch)
+
+			batchErrors := make([]*ImportError, 0, len(batch))
+			for _, row := range batch {
+				batchErrors = append(batchErrors, &ImportError{Line: row.Line, Message: "batch rolled back: " + err.Error()})
+			}
+
+			err = im.insertErrors(ctx, job.ID, batchErrors)
+			if err != nil {
+				return im.fail(job, err)
+			}
+		} else {
+			job.ImportedRows += len(batch)
+		}
+
+		err = im.updateJob(ctx, job)
+		if err != nil {
+			return im.fail(job, err)
+		}
+	}
+
+	now := time.Now()
+	job.Status = ImportStatusCompleted
+	job.FinishedAt = &now
+
+	return im.updateJob(ctx, job)
+}
+
+// marks the job failed on a fresh context, the one Run was given may be why it failed
+func (im ImportModel) fail(job *ImportJob, cause error) error {
+	now := time.Now()
+	job.Status = ImportStatusFailed
+	job.FinishedAt = &now
+
+	err := im.updateJob(context.Background(), job)
+	if err != nil {
+		return fmt.Errorf("%w (marking the job failed also failed: %s)", cause, err)
+	}
+
+	return cause
+}
+
+func (im ImportModel) updateJob(ctx context.Context, job *ImportJob) error {
+	query := `
+		UPDATE import_jobs
+		SET status = $1,
+		total_rows = $2,
+		imported_rows = $3,
+		failed_rows = $4,
+		finished_at = $5
+		WHERE id = $6
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	_, err := im.DB.ExecContext(ctx, tag(ctx, query), job.Status, job.TotalRows, job.ImportedRows, job.FailedRows, job.FinishedAt, job.ID)
+	return err
+}
+
+func (im ImportModel) insertErrors(ctx context.Context, jobID int64, importErrors []*ImportError) error {
+	if len(importErrors) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO import_errors (
+		job_id,
+		line,
+		field,
+		message
+	) VALUES ($1, $2, $3, $4)
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	tx, err := im.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, importError := range importErrors {
+		_, err := tx.ExecContext(ctx, tag(ctx, query), jobID, importError.Line, importError.Field, importError.Message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (im ImportModel) importBatch(ctx context.Context, batch []*ImportRow) error {
+	// a whole batch shares one deadline, the per query default is too short for it
+	ctx, cancel := context.WithTimeout(ctx, importBatchTimeout)
+	defer cancel()
+
+	tx, err := im.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, row := range batch {
+		err := importRow(ctx, tx, row)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", row.Line, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// venue (found or created), then the review, the pizza pointing at it and the link to the venue
+func importRow(ctx context.Context, q querier, row *ImportRow) error {
+	err := findOrInsertVenue(ctx, q, &row.Venue)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO reviews (
+		style,
+		price,
+		cheesiness,
+		flavor,
+		sauciness,
+		saltiness,
+		charness,
+		spiciness,
+		conclusion
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, created_at
+	`
+
+	review := &row.Review
+
+	args := []interface{}{
+		review.Style,
+		review.Price,
+		review.Cheesiness,
+		review.Flavor,
+		review.Sauciness,
+		review.Saltiness,
+		review.Charness,
+		review.Spiciness,
+		review.Conclusion,
+	}
+
+	err = q.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&review.ID, &review.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	row.Pizza.ReviewId = review.ID
+
+	query = `INSERT INTO pizzas (name, review_id) VALUES ($1, $2) RETURNING id`
+
+	err = q.QueryRowContext(ctx, tag(ctx, query), row.Pizza.Name, row.Pizza.ReviewId).Scan(&row.Pizza.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO venuepizzas (venue_id, pizza_id) VALUES ($1, $2)`
+
+	_, err = q.ExecContext(ctx, tag(ctx, query), row.Venue.ID, row.Pizza.ID)
+	return err
+}
+
+// spreadsheets spell the same place slightly differently, so match on the name
+// ignoring case and a position within venueMatchDistance rather than the address
+func findOrInsertVenue(ctx context.Context, q querier, venue *Venue) error {
+	query := `
+		SELECT id FROM venues
+		WHERE lower(name) = lower($1)
+		AND abs(lat - $2) < $4
+		AND abs(lon - $3) < $4
+		ORDER BY abs(lat - $2) + abs(lon - $3)
+		LIMIT 1
+	`
+
+	err := q.QueryRowContext(ctx, tag(ctx, query), venue.Name, venue.Lat, venue.Lon, venueMatchDistance).Scan(&venue.ID)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query = `
+	INSERT INTO venues (
+		name,
+		lat,
+		lon,
+		address
+	) VALUES ($1, $2, $3, $4)
+	RETURNING id
+	`
+
+	return q.QueryRowContext(ctx, tag(ctx, query), venue.Name, venue.Lat, venue.Lon, venue.Address).Scan(&venue.ID)
+}
+
+type MockImportModel struct {}
+
+func (im MockImportModel) Insert(ctx context.Context, job *ImportJob) error {
+	return nil
+}
+
+func (im MockImportModel) Get(ctx context.Context, id int64) (*ImportJob, error) {
+	return nil, nil
+}
+
+func (im MockImportModel) GetErrors(ctx context.Context, id int64) ([]*ImportError, error) {
+	return nil, nil
+}
+
+func (im MockImportModel) Run(ctx context.Context, job *ImportJob, rows []*ImportRow, batchSize int) error {
+	return nil
+}
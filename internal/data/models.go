@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/jsonlog"
 )
 
 var (
@@ -12,51 +16,97 @@ var (
 
 type Models struct {
 	Reviews interface {
-		Insert(review *Review) error
-		Get(startDate, endDate string) ([]*ReviewWithPizzaName, error)
-		Update(review *Review) error
-		Delete(id int64) error
-		GetAll() ([]*Review, error)
+		Insert(ctx context.Context, review *Review) error
+		Get(ctx context.Context, startDate, endDate string) ([]*ReviewWithPizzaName, error)
+		Update(ctx context.Context, review *Review) error
+		Delete(ctx context.Context, id int64) error
+		GetAll(ctx context.Context) ([]*Review, error)
+		List(ctx context.Context, f Filters) ([]*Review, Metadata, error)
+		Export(ctx context.Context, filter ReviewExportFilter, fn func(*ReviewExport) error) error
 	}
 	Pizzas interface {
-		Insert(pizza *Pizza) error
-		Get(id int64) (*Pizza, error)
-		Update(pizza *Pizza) error
-		Delete(id int64) error
-		GetAll() ([]*Pizza, error)
+		Insert(ctx context.Context, pizza *Pizza) error
+		Get(ctx context.Context, id int64) (*Pizza, error)
+		Update(ctx context.Context, pizza *Pizza) error
+		Delete(ctx context.Context, id int64, version int) error
+		GetAll(ctx context.Context) ([]*Pizza, error)
+		List(ctx context.Context, f Filters) ([]*Pizza, Metadata, error)
 	}
 	Images interface {
-		Insert(image *Image) error
-		Get(id int64) (*Image, error)
-		Update(image *Image) error
-		Delete(id int64) error
+		Insert(ctx context.Context, image *Image) error
+		Get(ctx context.Context, id int64) (*Image, error)
+		Update(ctx context.Context, image *Image) error
+		Delete(ctx context.Context, id int64) error
+		GetOrphaned(ctx context.Context, cutoff time.Time) ([]*Image, error)
+		DeleteOrphaned(ctx context.Context, id int64) error
+	}
+	Uploads interface {
+		Insert(ctx context.Context, image *Image, upload *Upload) error
+		Get(ctx context.Context, imageID int64) (*Upload, error)
+		UpdateOffset(ctx context.Context, upload *Upload, from int64) error
+		Lock(ctx context.Context, imageID int64) (release func(), err error)
 	}
 	Venues interface {
-		Insert(venue *Venue) error
-		Get(id int64) (*Venue, error)
-		Update(venue *Venue) error
-		Delete(id int64) error
-		GetAll() ([]*Venue, error)
+		Insert(ctx context.Context, venue *Venue) error
+		Get(ctx context.Context, id int64) (*Venue, error)
+		Update(ctx context.Context, venue *Venue) error
+		Delete(ctx context.Context, id int64, version int) error
+		GetAll(ctx context.Context) ([]*Venue, error)
+		List(ctx context.Context, f Filters) ([]*Venue, Metadata, error)
+		Merge(ctx context.Context, duplicateID, keepID int64) error
 	}
 	VenuePizzas interface {
-		Insert(venuePizza *VenuePizza) error
-		GetPizza(id int64) (*Opinion, error)
-		Get(id int64) (*VenuePizza, error) 
-		GetPizzasFromVenue(id int64) ([]*Opinion, error)
-		Update(venuePizza *VenuePizza) error
-		Delete(id int64) error
-		GetAll() ([]*VenuePizzaMixin, error)
+		Insert(ctx context.Context, venuePizza *VenuePizza) error
+		GetPizza(ctx context.Context, id int64) (*Opinion, error)
+		Get(ctx context.Context, id int64) (*VenuePizza, error) 
+		GetPizzasFromVenue(ctx context.Context, id int64) ([]*Opinion, error)
+		ListPizzasFromVenue(ctx context.Context, id int64, f Filters) ([]*Opinion, Metadata, error)
+		Update(ctx context.Context, venuePizza *VenuePizza) error
+		Delete(ctx context.Context, id int64) error
+		GetAll(ctx context.Context) ([]*VenuePizzaMixin, error)
+	}
+	Users interface {
+		Insert(ctx context.Context, user *User) error
+		GetByEmail(ctx context.Context, email string) (*User, error)
+		Update(ctx context.Context, user *User) error
+		GetAll(ctx context.Context) ([]*User, error)
+	}
+	Imports interface {
+		Insert(ctx context.Context, job *ImportJob) error
+		Get(ctx context.Context, id int64) (*ImportJob, error)
+		GetErrors(ctx context.Context, id int64) ([]*ImportError, error)
+		Run(ctx context.Context, job *ImportJob, rows []*ImportRow, batchSize int) error
+	}
+	Permissions interface {
+		GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+		RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+	}
+	Graph interface {
+		SearchVenues(ctx context.Context, filter VenueFilter) ([]*Venue, error)
+		VenuesByID(ctx context.Context, ids []int64) (map[int64]*Venue, error)
+		VenuesByPizza(ctx context.Context, pizzaIDs []int64) (map[int64][]*Venue, error)
+		PizzasByID(ctx context.Context, ids []int64) (map[int64]*Pizza, error)
+		PizzasByVenue(ctx context.Context, venueIDs []int64) (map[int64][]*Pizza, error)
+		PizzasByReview(ctx context.Context, reviewIDs []int64) (map[int64][]*Pizza, error)
+		ReviewsByID(ctx context.Context, ids []int64) (map[int64]*Review, error)
+		Scores(ctx context.Context, venueIDs []int64) (map[int64]*Scores, error)
 	}
 
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Reviews: ReviewModel{DB: db},
-		Pizzas: PizzaModel{DB: db},
-		Images: ImageModel{DB: db},
-		Venues: VenueModel{DB: db},
-		VenuePizzas: VenuePizzaModel{DB: db},
+		Reviews: tracedReviewModel{ReviewModel{DB: db}},
+		Pizzas: tracedPizzaModel{PizzaModel{DB: db}},
+		Images: tracedImageModel{ImageModel{DB: db}},
+		Uploads: tracedUploadModel{UploadModel{DB: db}},
+		Venues: tracedVenueModel{VenueModel{DB: db}},
+		VenuePizzas: tracedVenuePizzaModel{VenuePizzaModel{DB: db}},
+		Users: tracedUserModel{UserModel{DB: db}},
+		Permissions: tracedPermissionModel{PermissionModel{DB: db}},
+		Imports: tracedImportModel{ImportModel{DB: db}},
+		Graph: tracedGraphModel{GraphModel{DB: db}},
 	}
 }
 
@@ -65,7 +115,37 @@ func NewMockModels() Models {
 		Reviews: MockReviewModel{},
 		Pizzas: MockPizzaModel{},
 		Images: MockImageModel{},
+		Uploads: MockUploadModel{},
 		Venues: MockVenueModel{},
 		VenuePizzas: MockVenuePizzaModel{},
+		Users: MockUserModel{},
+		Permissions: MockPermissionModel{},
+		Imports: MockImportModel{},
+		Graph: MockGraphModel{},
 	}
+}
+
+// how long a query may run when the caller didn't set a deadline, e.g. background jobs
+const defaultTimeout = 3 * time.Second
+
+// queries share whatever deadline the caller's context already carries, for
+// handlers that is the per-request budget, so one slow query eats into the next
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
+}
+
+// prefixes the query with an sqlcommenter style comment holding the request id,
+// so a slow or failing statement in the postgres logs can be traced back to the request
+func tag(ctx context.Context, query string) string {
+	id := jsonlog.RequestID(ctx)
+
+	// the id ends up inside the sql text, never trust it to be clean
+	if !jsonlog.ValidRequestID(id) {
+		return query
+	}
+
+	return "/* request_id='" + id + "' */ " + query
 }
\ No newline at end of file
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// permission codes, e.g. "reviews:write", held by a single user
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (pm PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := pm.DB.QueryContext(ctx, tag(ctx, query), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// granting a code the user already holds is a no-op
+func (pm PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := pm.checkCodes(ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	_, err = pm.DB.ExecContext(ctx, tag(ctx, query), userID, pq.Array(codes))
+	return err
+}
+
+func (pm PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := pm.checkCodes(ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	_, err = pm.DB.ExecContext(ctx, tag(ctx, query), userID, pq.Array(codes))
+	return err
+}
+
+// a typo in a code would otherwise silently grant or revoke nothing
+func (pm PermissionModel) checkCodes(ctx context.Context, codes []string) error {
+	query := `SELECT count(*) FROM permissions WHERE code = ANY($1)`
+
+	var known int
+
+	err := pm.DB.QueryRowContext(ctx, tag(ctx, query), pq.Array(codes)).Scan(&known)
+	if err != nil {
+		return err
+	}
+
+	unique := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		unique[code] = true
+	}
+
+	if known != len(unique) {
+		return ErrUnknownPermission
+	}
+
+	return nil
+}
+
+type MockPermissionModel struct {}
+
+func (pm MockPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	return nil, nil
+}
+
+func (pm MockPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+
+func (pm MockPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
@@ -1,11 +1,10 @@
 package data
 
 import (
-	"time"
 	"database/sql"
 	"errors"
 	"context"
-	_ "fmt"
+	"fmt"
 
 	"github.com/tclohm/project-pizza/internal/validator"
 
@@ -16,6 +15,7 @@ type Pizza struct {
 	ID int64 `json:"id"`
 	Name string `json:"name"`
 	ReviewId int64 `json:"review_id"`
+	Version int `json:"-"`
 }
 
 func ValidatePizza(v *validator.Validator, pizza *Pizza) {
@@ -28,14 +28,14 @@ type PizzaModel struct {
 	DB *sql.DB
 }
 
-func (pm PizzaModel) Insert(pizza *Pizza) error {
+func (pm PizzaModel) Insert(ctx context.Context, pizza *Pizza) error {
 
 	query := `
 	INSERT INTO pizzas (
 		name, 
 		review_id
 	) VALUES ($1, $2)
-	RETURNING id
+	RETURNING id, version
 	`
 	// args slices containing values for the placeholder parameters from the pizza struct
 	args := []interface{}{
@@ -43,15 +43,15 @@ func (pm PizzaModel) Insert(pizza *Pizza) error {
 		pizza.ReviewId,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	// passing in the slice and scanning the system generated id
-	return pm.DB.QueryRowContext(ctx, query, args...).Scan(&pizza.ID)
+	return pm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&pizza.ID, &pizza.Version)
 
 }
 
-func (pm PizzaModel) Get(id int64) (*Pizza, error) {
+func (pm PizzaModel) Get(ctx context.Context, id int64) (*Pizza, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -59,21 +59,23 @@ func (pm PizzaModel) Get(id int64) (*Pizza, error) {
 	query := `
 	SELECT id, 
 		name,
-		review_id
+		review_id,
+		version
 	FROM pizzas 
 	WHERE id = $1
 	`
 
 	var pizza Pizza
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	err := pm.DB.QueryRowContext(ctx, query, id).Scan(
+	err := pm.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
 		&pizza.ID,
+		&pizza.Name,
 		&pizza.ReviewId,
+		&pizza.Version,
 	)
 
 	if err != nil {
@@ -88,25 +90,27 @@ func (pm PizzaModel) Get(id int64) (*Pizza, error) {
 	return &pizza, nil
 }
 
-func (pm PizzaModel) Update(pizza *Pizza) error {
+func (pm PizzaModel) Update(ctx context.Context, pizza *Pizza) error {
 	query := `
 	UPDATE pizzas SET 
 		name = $1,
-		review_id = $2
-	WHERE id = $3
-	RETURNING id
+		review_id = $2,
+		version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version
 	`
 
 	args := []interface{}{
 		pizza.Name,
 		pizza.ReviewId,
 		pizza.ID,
+		pizza.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 	// query and scan the new value in
-	err := pm.DB.QueryRowContext(ctx, query, args...).Scan(&pizza.ID)
+	err := pm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&pizza.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -118,16 +122,19 @@ func (pm PizzaModel) Update(pizza *Pizza) error {
 	return nil
 }
 
-func (pm PizzaModel) Delete(id int64) error {
+func (pm PizzaModel) Delete(ctx context.Context, id int64, version int) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 	DELETE FROM pizzas
-	WHERE id = $1`
+	WHERE id = $1 AND version = $2`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
 
-	result, err := pm.DB.Exec(query, id)
+	result, err := pm.DB.ExecContext(ctx, tag(ctx, query), id, version)
 	if err != nil {
 		return err
 	}
@@ -138,28 +145,30 @@ func (pm PizzaModel) Delete(id int64) error {
 		return err
 	}
 
+	// the row is gone or has moved on from the version the caller saw
 	if rows == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 	
 	return nil
 }
 
-func (pm PizzaModel) GetAll() ([]*Pizza, error) {
+func (pm PizzaModel) GetAll(ctx context.Context) ([]*Pizza, error) {
 	query := `
 	SELECT 
 		id,
 		name,
 		review_id,
+		version
 	FROM pizzas
 	` 
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	args := []interface{}{}
 
-	rows, err := pm.DB.QueryContext(ctx, query, args...)
+	rows, err := pm.DB.QueryContext(ctx, tag(ctx, query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +184,7 @@ func (pm PizzaModel) GetAll() ([]*Pizza, error) {
 			&pizza.ID,
 			&pizza.Name,
 			&pizza.ReviewId, 
+			&pizza.Version,
 		)
 
 		if err != nil {
@@ -191,25 +201,90 @@ func (pm PizzaModel) GetAll() ([]*Pizza, error) {
 	return pizzas, nil
 }
 
+// a page of the pizzas in the order f asks for, paged by offset or by cursor
+func (pm PizzaModel) List(ctx context.Context, f Filters) ([]*Pizza, Metadata, error) {
+	page := f.pageQuery(f.sortColumn(), "id", 1)
+
+	query := fmt.Sprintf(`
+	SELECT 
+		%s,
+		%s,
+		id,
+		name,
+		review_id,
+		version
+	FROM pizzas
+	WHERE true %s
+	%s
+	%s`, page.total, page.key, page.where, page.orderBy, page.limit)
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := pm.DB.QueryContext(ctx, tag(ctx, query), page.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	pizzas := []*Pizza{}
+	keys := []Cursor{}
+
+	for rows.Next() {
+		var pizza Pizza
+		key := Cursor{Sort: f.Sort}
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Key,
+			&pizza.ID,
+			&pizza.Name,
+			&pizza.ReviewId, 
+			&pizza.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = pizza.ID
+
+		pizzas = append(pizzas, &pizza)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return pizzas, f.paginate(&pizzas, keys, totalRecords), nil
+}
+
 
 type MockPizzaModel struct {}
 
-func (pm MockPizzaModel) Insert(pizza *Pizza) error {
+func (pm MockPizzaModel) Insert(ctx context.Context, pizza *Pizza) error {
 	return nil
 }
 
-func (pm MockPizzaModel) Get(id int64) (*Pizza, error) {
+func (pm MockPizzaModel) Get(ctx context.Context, id int64) (*Pizza, error) {
 	return nil, nil
 }
 
-func (pm MockPizzaModel) Update(pizza *Pizza) error {
+func (pm MockPizzaModel) Update(ctx context.Context, pizza *Pizza) error {
 	return nil
 }
 
-func (pm MockPizzaModel) Delete(id int64) error {
+func (pm MockPizzaModel) Delete(ctx context.Context, id int64, version int) error {
 	return nil
 }
 
-func (pm MockPizzaModel) GetAll() ([]*Pizza, error) {
+func (pm MockPizzaModel) GetAll(ctx context.Context) ([]*Pizza, error) {
 	return nil, nil
-}
\ No newline at end of file
+}
+
+func (pm MockPizzaModel) List(ctx context.Context, f Filters) ([]*Pizza, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// the most photos a single review can carry
+const MaxReviewImages = 10
+
+// the longest caption, in bytes
+const MaxCaptionLength = 500
+
+// a photo attached to a review, ordered by position
+type ReviewImage struct {
+	ImageID 		int64 		`json:"image_id"`
+	Position 		int 		`json:"position"`
+	Caption 		string 		`json:"caption"`
+	Filename 		string 		`json:"filename,omitempty"`
+	ContentType 	string 		`json:"content_type,omitempty"`
+	Location 		string 		`json:"location,omitempty"`
+}
+
+func ValidateReviewImages(v *validator.Validator, images []*ReviewImage) {
+	v.Check(len(images) <= MaxReviewImages, "image_ids", fmt.Sprintf("must not contain more than %d images", MaxReviewImages))
+
+	seen := make(map[int64]bool)
+
+	for _, image := range images {
+		v.Check(image.ImageID > 0, "image_ids", "must only contain ids greater than 0")
+		v.Check(!seen[image.ImageID], "image_ids", "must not contain duplicate ids")
+		v.Check(len(image.Caption) <= MaxCaptionLength, "captions", fmt.Sprintf("must not be more than %d bytes long", MaxCaptionLength))
+
+		seen[image.ImageID] = true
+	}
+}
+
+// used by any query that runs either on its own or inside a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertReviewImages(ctx context.Context, q querier, reviewID int64, images []*ReviewImage) error {
+	query := `
+	INSERT INTO review_images (
+		review_id,
+		image_id,
+		position,
+		caption
+	) VALUES ($1, $2, $3, $4)
+	`
+
+	for _, image := range images {
+		_, err := q.ExecContext(ctx, tag(ctx, query), reviewID, image.ImageID, image.Position, image.Caption)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// photos keyed by review id
+type reviewImageSet map[int64][]*ReviewImage
+
+// an empty slice rather than nil so reviews without photos render as []
+func (s reviewImageSet) of(reviewID int64) []*ReviewImage {
+	if images, ok := s[reviewID]; ok {
+		return images
+	}
+	return []*ReviewImage{}
+}
+
+// loads the photos for many reviews in one query so list endpoints don't go N+1
+func getReviewImages(ctx context.Context, q querier, reviewIDs []int64) (reviewImageSet, error) {
+	images := make(reviewImageSet)
+
+	if len(reviewIDs) == 0 {
+		return images, nil
+	}
+
+	query := `
+	SELECT
+		review_images.review_id,
+		review_images.image_id,
+		review_images.position,
+		review_images.caption,
+		images.filename,
+		images.content_type,
+		images.location
+	FROM review_images
+	JOIN images ON images.id = review_images.image_id
+	WHERE review_images.review_id = ANY($1)
+	AND images.status = 'ready'
+	ORDER BY review_images.review_id, review_images.position
+	`
+
+	rows, err := q.QueryContext(ctx, tag(ctx, query), pq.Array(reviewIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var reviewID int64
+		var image ReviewImage
+
+		err := rows.Scan(
+			&reviewID,
+			&image.ImageID,
+			&image.Position,
+			&image.Caption,
+			&image.Filename,
+			&image.ContentType,
+			&image.Location,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		images[reviewID] = append(images[reviewID], &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// NULL instead of 0 so an image-less review doesn't trip the image foreign key
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
\ No newline at end of file
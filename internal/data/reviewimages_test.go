@@ -0,0 +1,45 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+)
+
+func TestValidateReviewImages(t *testing.T) {
+	images := func(n int, caption string) []*ReviewImage {
+		var out []*ReviewImage
+		for i := 1; i <= n; i++ {
+			out = append(out, &ReviewImage{ImageID: int64(i), Caption: caption})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name 	string
+		images 	[]*ReviewImage
+		want 	map[string]string
+	}{
+		{"most images", images(MaxReviewImages, ""), map[string]string{}},
+		{"too many images", images(MaxReviewImages+1, ""), map[string]string{"image_ids": "must not contain more than 10 images"}},
+		{"longest caption", images(1, strings.Repeat("a", MaxCaptionLength)), map[string]string{}},
+		{"caption too long", images(1, strings.Repeat("a", MaxCaptionLength+1)), map[string]string{"captions": "must not be more than 500 bytes long"}},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateReviewImages(v, tt.images)
+
+		if len(v.Errors) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, v.Errors, tt.want)
+			continue
+		}
+
+		for key, message := range tt.want {
+			if v.Errors[key] != message {
+				t.Errorf("%s: got %q for %s, want %q", tt.name, v.Errors[key], key, message)
+			}
+		}
+	}
+}
@@ -26,6 +26,7 @@ type Review struct {
 	Spiciness 	float32 	`json:"spiciness"`
 	CreatedAt 	time.Time 	`json:"created_at"`
 	ImageId 	int64 		`json:"image_id"`
+	Images 		[]*ReviewImage `json:"images"`
 }
 
 type ReviewWithPizzaName struct {
@@ -43,6 +44,7 @@ type ReviewWithPizzaName struct {
 	Spiciness 	float32 	`json:"spiciness"`
 	CreatedAt 	time.Time 	`json:"created_at"`
 	ImageId 	int64 		`json:"image_id"`
+	Images 		[]*ReviewImage `json:"images"`
 }
 
 
@@ -82,7 +84,7 @@ type ReviewModel struct {
 	DB *sql.DB
 }
 
-func (rm ReviewModel) Insert(review *Review) error {
+func (rm ReviewModel) Insert(ctx context.Context, review *Review) error {
 	query := `
 	INSERT INTO reviews (
 		style,
@@ -96,7 +98,7 @@ func (rm ReviewModel) Insert(review *Review) error {
 		conclusion,
 		image_id
 	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	RETURNING id
+	RETURNING id, created_at
 	`
 	// args slices containing values for the placeholder parameters from the review struct
 	args := []interface{}{
@@ -109,17 +111,35 @@ func (rm ReviewModel) Insert(review *Review) error {
 		review.Charness,
 		review.Spiciness,
 		review.Conclusion,
-		review.ImageId,
+		nullableID(review.ImageId),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
+	// the review and its photos go in together or not at all
+	tx, err := rm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
 	// passing in the slice and scanning the system generated id
-	return rm.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID)
+	err = tx.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&review.ID, &review.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertReviewImages(ctx, tx, review.ID, review.Images)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (rm ReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, error) {
+func (rm ReviewModel) Get(ctx context.Context, startDate, endDate string) ([]*ReviewWithPizzaName, error) {
 	start, err := time.Parse(time.RFC3339, startDate)
 	if err != nil {
 		return nil, err
@@ -156,7 +176,8 @@ func (rm ReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, er
 		charness,
 		spiciness,
 		conclusion,
-		image_id
+		COALESCE(image_id, 0),
+		created_at
 	FROM reviews 
 	JOIN pizzas ON reviews.id = pizzas.review_id
 	WHERE created_at BETWEEN $1 and $2
@@ -167,12 +188,12 @@ func (rm ReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, er
 		end,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	rows, err := rm.DB.QueryContext(ctx, query, args...)
+	rows, err := rm.DB.QueryContext(ctx, tag(ctx, query), args...)
 
 	if err != nil {
 		return nil, err
@@ -199,6 +220,7 @@ func (rm ReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, er
 			&review.Spiciness,
 			&review.Conclusion,
 			&review.ImageId,
+			&review.CreatedAt,
 		)
 
 		if err != nil {
@@ -212,11 +234,25 @@ func (rm ReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, er
 		}
 	}
 
+	ids := make([]int64, len(reviews))
+	for i, review := range reviews {
+		ids[i] = review.ID
+	}
+
+	images, err := getReviewImages(ctx, rm.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, review := range reviews {
+		review.Images = images.of(review.ID)
+	}
+
 	return reviews, nil
 }
 
 // MARK: -- UPDATE
-func (rm ReviewModel) Update(review *Review) error {
+func (rm ReviewModel) Update(ctx context.Context, review *Review) error {
 	query := `
 	UPDATE reviews
 		SET
@@ -244,14 +280,14 @@ func (rm ReviewModel) Update(review *Review) error {
 		review.Charness,
 		review.Spiciness,
 		review.Conclusion,
-		review.ImageId,
+		nullableID(review.ImageId),
 		review.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 	// query and scan the new value in
-	err := rm.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID)
+	err := rm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&review.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -263,7 +299,7 @@ func (rm ReviewModel) Update(review *Review) error {
 	return nil
 }
 
-func (rm ReviewModel) Delete(id int64) error {
+func (rm ReviewModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -273,7 +309,10 @@ func (rm ReviewModel) Delete(id int64) error {
 		reviews
 	WHERE id = $1`
 
-	result, err := rm.DB.Exec(query, id)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	result, err := rm.DB.ExecContext(ctx, tag(ctx, query), id)
 	if err != nil {
 		return err
 	}
@@ -291,7 +330,7 @@ func (rm ReviewModel) Delete(id int64) error {
 	return nil
 }
 
-func (rm ReviewModel) GetAll() ([]*Review, error) {
+func (rm ReviewModel) GetAll(ctx context.Context) ([]*Review, error) {
 	query := `
 		SELECT
 			id, 
@@ -304,16 +343,16 @@ func (rm ReviewModel) GetAll() ([]*Review, error) {
 			charness,
 			spiciness,
 			conclusion,
-			image_id,
+			COALESCE(image_id, 0),
 			created_at
 		FROM reviews`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	args := []interface{}{}
 
-	rows, err := rm.DB.QueryContext(ctx, query, args...)
+	rows, err := rm.DB.QueryContext(ctx, tag(ctx, query), args...)
 
 	if err != nil {
 		return nil, err
@@ -352,28 +391,143 @@ func (rm ReviewModel) GetAll() ([]*Review, error) {
 		}
 	}
 
+	ids := make([]int64, len(reviews))
+	for i, review := range reviews {
+		ids[i] = review.ID
+	}
+
+	images, err := getReviewImages(ctx, rm.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, review := range reviews {
+		review.Images = images.of(review.ID)
+	}
+
 	return reviews, nil
 }
 
+// a page of the reviews in the order f asks for, paged by offset or by cursor
+func (rm ReviewModel) List(ctx context.Context, f Filters) ([]*Review, Metadata, error) {
+	page := f.pageQuery(f.sortColumn(), "id", 1)
+
+	query := fmt.Sprintf(`
+		SELECT
+			%s,
+			%s,
+			id, 
+			style,
+			price,
+			cheesiness, 
+			flavor, 
+			sauciness, 
+			saltiness, 
+			charness,
+			spiciness,
+			conclusion,
+			COALESCE(image_id, 0),
+			created_at
+		FROM reviews
+		WHERE true %s
+		%s
+		%s`, page.total, page.key, page.where, page.orderBy, page.limit)
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := rm.DB.QueryContext(ctx, tag(ctx, query), page.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	keys := []Cursor{}
+
+	for rows.Next() {
+		var review Review
+		key := Cursor{Sort: f.Sort}
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Key,
+			&review.ID,
+			&review.Style,
+			&review.Price,
+			&review.Cheesiness,
+			&review.Flavor,
+			&review.Sauciness,
+			&review.Saltiness,
+			&review.Charness,
+			&review.Spiciness,
+			&review.Conclusion,
+			&review.ImageId,
+			&review.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = review.ID
+
+		reviews = append(reviews, &review)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := f.paginate(&reviews, keys, totalRecords)
+
+	ids := make([]int64, len(reviews))
+	for i, review := range reviews {
+		ids[i] = review.ID
+	}
+
+	images, err := getReviewImages(ctx, rm.DB, ids)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for _, review := range reviews {
+		review.Images = images.of(review.ID)
+	}
+
+	return reviews, metadata, nil
+}
+
 
 type MockReviewModel struct {}
 
-func (rm MockReviewModel) Insert(review *Review) error {
+func (rm MockReviewModel) Insert(ctx context.Context, review *Review) error {
 	return nil
 }
 
-func (rm MockReviewModel) Get(startDate, endDate string) ([]*ReviewWithPizzaName, error) {
+func (rm MockReviewModel) Get(ctx context.Context, startDate, endDate string) ([]*ReviewWithPizzaName, error) {
 	return nil, nil
 }
 
-func (rm MockReviewModel) Update(review *Review) error {
+func (rm MockReviewModel) Update(ctx context.Context, review *Review) error {
 	return nil
 }
 
-func (rm MockReviewModel) Delete(id int64) error {
+func (rm MockReviewModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (rm MockReviewModel) GetAll() ([]*Review, error) {
+func (rm MockReviewModel) GetAll(ctx context.Context) ([]*Review, error) {
 	return nil, nil
-}
\ No newline at end of file
+}
+
+func (rm MockReviewModel) List(ctx context.Context, f Filters) ([]*Review, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (rm MockReviewModel) Export(ctx context.Context, filter ReviewExportFilter, fn func(*ReviewExport) error) error {
+	return nil
+}
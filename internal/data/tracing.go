@@ -0,0 +1,488 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the global tracer forwards to whatever provider main installs, until then it is a no-op
+var tracer = otel.Tracer("github.com/tclohm/project-pizza/internal/data")
+
+// opens a span for a model call, the sql statements it runs become its children
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("db.query.name", name)))
+}
+
+// rows is how many records the call handed back, -1 when it doesn't return any.
+// A missing record is an answer, not a failure, so it doesn't mark the span as errored
+func endSpan(span trace.Span, rows int, err error) {
+	if rows >= 0 {
+		span.SetAttributes(attribute.Int("db.rows_returned", rows))
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrRecordNotFound):
+		span.SetAttributes(attribute.Int("db.rows_returned", 0))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// the traced* types wrap the postgres models so every call gets a span without
+// touching the query code
+
+type tracedReviewModel struct {
+	ReviewModel
+}
+
+func (t tracedReviewModel) Insert(ctx context.Context, review *Review) error {
+	ctx, span := startSpan(ctx, "ReviewModel.Insert")
+	err := t.ReviewModel.Insert(ctx, review)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedReviewModel) Get(ctx context.Context, startDate, endDate string) ([]*ReviewWithPizzaName, error) {
+	ctx, span := startSpan(ctx, "ReviewModel.Get")
+	reviews, err := t.ReviewModel.Get(ctx, startDate, endDate)
+	endSpan(span, len(reviews), err)
+	return reviews, err
+}
+
+func (t tracedReviewModel) Update(ctx context.Context, review *Review) error {
+	ctx, span := startSpan(ctx, "ReviewModel.Update")
+	err := t.ReviewModel.Update(ctx, review)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedReviewModel) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "ReviewModel.Delete")
+	err := t.ReviewModel.Delete(ctx, id)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedReviewModel) GetAll(ctx context.Context) ([]*Review, error) {
+	ctx, span := startSpan(ctx, "ReviewModel.GetAll")
+	reviews, err := t.ReviewModel.GetAll(ctx)
+	endSpan(span, len(reviews), err)
+	return reviews, err
+}
+
+func (t tracedReviewModel) List(ctx context.Context, f Filters) ([]*Review, Metadata, error) {
+	ctx, span := startSpan(ctx, "ReviewModel.List")
+	reviews, metadata, err := t.ReviewModel.List(ctx, f)
+	endSpan(span, len(reviews), err)
+	return reviews, metadata, err
+}
+
+func (t tracedReviewModel) Export(ctx context.Context, filter ReviewExportFilter, fn func(*ReviewExport) error) error {
+	ctx, span := startSpan(ctx, "ReviewModel.Export")
+
+	rows := 0
+	err := t.ReviewModel.Export(ctx, filter, func(export *ReviewExport) error {
+		rows++
+		return fn(export)
+	})
+
+	endSpan(span, rows, err)
+	return err
+}
+
+type tracedPizzaModel struct {
+	PizzaModel
+}
+
+func (t tracedPizzaModel) Insert(ctx context.Context, pizza *Pizza) error {
+	ctx, span := startSpan(ctx, "PizzaModel.Insert")
+	err := t.PizzaModel.Insert(ctx, pizza)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedPizzaModel) Get(ctx context.Context, id int64) (*Pizza, error) {
+	ctx, span := startSpan(ctx, "PizzaModel.Get")
+	pizza, err := t.PizzaModel.Get(ctx, id)
+	endSpan(span, 1, err)
+	return pizza, err
+}
+
+func (t tracedPizzaModel) Update(ctx context.Context, pizza *Pizza) error {
+	ctx, span := startSpan(ctx, "PizzaModel.Update")
+	err := t.PizzaModel.Update(ctx, pizza)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedPizzaModel) Delete(ctx context.Context, id int64, version int) error {
+	ctx, span := startSpan(ctx, "PizzaModel.Delete")
+	err := t.PizzaModel.Delete(ctx, id, version)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedPizzaModel) GetAll(ctx context.Context) ([]*Pizza, error) {
+	ctx, span := startSpan(ctx, "PizzaModel.GetAll")
+	pizzas, err := t.PizzaModel.GetAll(ctx)
+	endSpan(span, len(pizzas), err)
+	return pizzas, err
+}
+
+func (t tracedPizzaModel) List(ctx context.Context, f Filters) ([]*Pizza, Metadata, error) {
+	ctx, span := startSpan(ctx, "PizzaModel.List")
+	pizzas, metadata, err := t.PizzaModel.List(ctx, f)
+	endSpan(span, len(pizzas), err)
+	return pizzas, metadata, err
+}
+
+type tracedImageModel struct {
+	ImageModel
+}
+
+func (t tracedImageModel) Insert(ctx context.Context, image *Image) error {
+	ctx, span := startSpan(ctx, "ImageModel.Insert")
+	err := t.ImageModel.Insert(ctx, image)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedImageModel) Get(ctx context.Context, id int64) (*Image, error) {
+	ctx, span := startSpan(ctx, "ImageModel.Get")
+	image, err := t.ImageModel.Get(ctx, id)
+	endSpan(span, 1, err)
+	return image, err
+}
+
+func (t tracedImageModel) Update(ctx context.Context, image *Image) error {
+	ctx, span := startSpan(ctx, "ImageModel.Update")
+	err := t.ImageModel.Update(ctx, image)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedImageModel) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "ImageModel.Delete")
+	err := t.ImageModel.Delete(ctx, id)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedImageModel) GetOrphaned(ctx context.Context, cutoff time.Time) ([]*Image, error) {
+	ctx, span := startSpan(ctx, "ImageModel.GetOrphaned")
+	images, err := t.ImageModel.GetOrphaned(ctx, cutoff)
+	endSpan(span, len(images), err)
+	return images, err
+}
+
+func (t tracedImageModel) DeleteOrphaned(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "ImageModel.DeleteOrphaned")
+	err := t.ImageModel.DeleteOrphaned(ctx, id)
+	endSpan(span, -1, err)
+	return err
+}
+
+type tracedUploadModel struct {
+	UploadModel
+}
+
+func (t tracedUploadModel) Insert(ctx context.Context, image *Image, upload *Upload) error {
+	ctx, span := startSpan(ctx, "UploadModel.Insert")
+	err := t.UploadModel.Insert(ctx, image, upload)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedUploadModel) Get(ctx context.Context, imageID int64) (*Upload, error) {
+	ctx, span := startSpan(ctx, "UploadModel.Get")
+	upload, err := t.UploadModel.Get(ctx, imageID)
+	endSpan(span, 1, err)
+	return upload, err
+}
+
+func (t tracedUploadModel) UpdateOffset(ctx context.Context, upload *Upload, from int64) error {
+	ctx, span := startSpan(ctx, "UploadModel.UpdateOffset")
+	err := t.UploadModel.UpdateOffset(ctx, upload, from)
+	endSpan(span, -1, err)
+	return err
+}
+
+// the span covers taking the lock, not holding it
+func (t tracedUploadModel) Lock(ctx context.Context, imageID int64) (func(), error) {
+	ctx, span := startSpan(ctx, "UploadModel.Lock")
+	release, err := t.UploadModel.Lock(ctx, imageID)
+	endSpan(span, -1, err)
+	return release, err
+}
+
+type tracedVenueModel struct {
+	VenueModel
+}
+
+func (t tracedVenueModel) Insert(ctx context.Context, venue *Venue) error {
+	ctx, span := startSpan(ctx, "VenueModel.Insert")
+	err := t.VenueModel.Insert(ctx, venue)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenueModel) Get(ctx context.Context, id int64) (*Venue, error) {
+	ctx, span := startSpan(ctx, "VenueModel.Get")
+	venue, err := t.VenueModel.Get(ctx, id)
+	endSpan(span, 1, err)
+	return venue, err
+}
+
+func (t tracedVenueModel) Update(ctx context.Context, venue *Venue) error {
+	ctx, span := startSpan(ctx, "VenueModel.Update")
+	err := t.VenueModel.Update(ctx, venue)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenueModel) Delete(ctx context.Context, id int64, version int) error {
+	ctx, span := startSpan(ctx, "VenueModel.Delete")
+	err := t.VenueModel.Delete(ctx, id, version)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenueModel) GetAll(ctx context.Context) ([]*Venue, error) {
+	ctx, span := startSpan(ctx, "VenueModel.GetAll")
+	venues, err := t.VenueModel.GetAll(ctx)
+	endSpan(span, len(venues), err)
+	return venues, err
+}
+
+func (t tracedVenueModel) List(ctx context.Context, f Filters) ([]*Venue, Metadata, error) {
+	ctx, span := startSpan(ctx, "VenueModel.List")
+	venues, metadata, err := t.VenueModel.List(ctx, f)
+	endSpan(span, len(venues), err)
+	return venues, metadata, err
+}
+
+func (t tracedVenueModel) Merge(ctx context.Context, duplicateID, keepID int64) error {
+	ctx, span := startSpan(ctx, "VenueModel.Merge")
+	err := t.VenueModel.Merge(ctx, duplicateID, keepID)
+	endSpan(span, -1, err)
+	return err
+}
+
+type tracedVenuePizzaModel struct {
+	VenuePizzaModel
+}
+
+func (t tracedVenuePizzaModel) Insert(ctx context.Context, venuePizza *VenuePizza) error {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.Insert")
+	err := t.VenuePizzaModel.Insert(ctx, venuePizza)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenuePizzaModel) GetPizza(ctx context.Context, id int64) (*Opinion, error) {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.GetPizza")
+	opinion, err := t.VenuePizzaModel.GetPizza(ctx, id)
+	endSpan(span, 1, err)
+	return opinion, err
+}
+
+func (t tracedVenuePizzaModel) Get(ctx context.Context, id int64) (*VenuePizza, error) {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.Get")
+	venuePizza, err := t.VenuePizzaModel.Get(ctx, id)
+	endSpan(span, 1, err)
+	return venuePizza, err
+}
+
+func (t tracedVenuePizzaModel) GetPizzasFromVenue(ctx context.Context, id int64) ([]*Opinion, error) {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.GetPizzasFromVenue")
+	opinions, err := t.VenuePizzaModel.GetPizzasFromVenue(ctx, id)
+	endSpan(span, len(opinions), err)
+	return opinions, err
+}
+
+func (t tracedVenuePizzaModel) ListPizzasFromVenue(ctx context.Context, id int64, f Filters) ([]*Opinion, Metadata, error) {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.ListPizzasFromVenue")
+	opinions, metadata, err := t.VenuePizzaModel.ListPizzasFromVenue(ctx, id, f)
+	endSpan(span, len(opinions), err)
+	return opinions, metadata, err
+}
+
+func (t tracedVenuePizzaModel) Update(ctx context.Context, venuePizza *VenuePizza) error {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.Update")
+	err := t.VenuePizzaModel.Update(ctx, venuePizza)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenuePizzaModel) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.Delete")
+	err := t.VenuePizzaModel.Delete(ctx, id)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedVenuePizzaModel) GetAll(ctx context.Context) ([]*VenuePizzaMixin, error) {
+	ctx, span := startSpan(ctx, "VenuePizzaModel.GetAll")
+	mixins, err := t.VenuePizzaModel.GetAll(ctx)
+	endSpan(span, len(mixins), err)
+	return mixins, err
+}
+
+type tracedUserModel struct {
+	UserModel
+}
+
+func (t tracedUserModel) Insert(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserModel.Insert")
+	err := t.UserModel.Insert(ctx, user)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail")
+	user, err := t.UserModel.GetByEmail(ctx, email)
+	endSpan(span, 1, err)
+	return user, err
+}
+
+func (t tracedUserModel) Update(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserModel.Update")
+	err := t.UserModel.Update(ctx, user)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedUserModel) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, span := startSpan(ctx, "UserModel.GetAll")
+	users, err := t.UserModel.GetAll(ctx)
+	endSpan(span, len(users), err)
+	return users, err
+}
+
+type tracedPermissionModel struct {
+	PermissionModel
+}
+
+func (t tracedPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, span := startSpan(ctx, "PermissionModel.GetAllForUser")
+	permissions, err := t.PermissionModel.GetAllForUser(ctx, userID)
+	endSpan(span, len(permissions), err)
+	return permissions, err
+}
+
+func (t tracedPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "PermissionModel.AddForUser")
+	err := t.PermissionModel.AddForUser(ctx, userID, codes...)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, span := startSpan(ctx, "PermissionModel.RemoveForUser")
+	err := t.PermissionModel.RemoveForUser(ctx, userID, codes...)
+	endSpan(span, -1, err)
+	return err
+}
+
+type tracedImportModel struct {
+	ImportModel
+}
+
+func (t tracedImportModel) Insert(ctx context.Context, job *ImportJob) error {
+	ctx, span := startSpan(ctx, "ImportModel.Insert")
+	err := t.ImportModel.Insert(ctx, job)
+	endSpan(span, -1, err)
+	return err
+}
+
+func (t tracedImportModel) Get(ctx context.Context, id int64) (*ImportJob, error) {
+	ctx, span := startSpan(ctx, "ImportModel.Get")
+	job, err := t.ImportModel.Get(ctx, id)
+	endSpan(span, 1, err)
+	return job, err
+}
+
+func (t tracedImportModel) GetErrors(ctx context.Context, id int64) ([]*ImportError, error) {
+	ctx, span := startSpan(ctx, "ImportModel.GetErrors")
+	importErrors, err := t.ImportModel.GetErrors(ctx, id)
+	endSpan(span, len(importErrors), err)
+	return importErrors, err
+}
+
+func (t tracedImportModel) Run(ctx context.Context, job *ImportJob, rows []*ImportRow, batchSize int) error {
+	ctx, span := startSpan(ctx, "ImportModel.Run")
+	err := t.ImportModel.Run(ctx, job, rows, batchSize)
+	endSpan(span, -1, err)
+	return err
+}
+
+type tracedGraphModel struct {
+	GraphModel
+}
+
+func (t tracedGraphModel) SearchVenues(ctx context.Context, filter VenueFilter) ([]*Venue, error) {
+	ctx, span := startSpan(ctx, "GraphModel.SearchVenues")
+	venues, err := t.GraphModel.SearchVenues(ctx, filter)
+	endSpan(span, len(venues), err)
+	return venues, err
+}
+
+func (t tracedGraphModel) VenuesByID(ctx context.Context, ids []int64) (map[int64]*Venue, error) {
+	ctx, span := startSpan(ctx, "GraphModel.VenuesByID")
+	venues, err := t.GraphModel.VenuesByID(ctx, ids)
+	endSpan(span, len(venues), err)
+	return venues, err
+}
+
+func (t tracedGraphModel) VenuesByPizza(ctx context.Context, pizzaIDs []int64) (map[int64][]*Venue, error) {
+	ctx, span := startSpan(ctx, "GraphModel.VenuesByPizza")
+	venues, err := t.GraphModel.VenuesByPizza(ctx, pizzaIDs)
+	endSpan(span, len(venues), err)
+	return venues, err
+}
+
+func (t tracedGraphModel) PizzasByID(ctx context.Context, ids []int64) (map[int64]*Pizza, error) {
+	ctx, span := startSpan(ctx, "GraphModel.PizzasByID")
+	pizzas, err := t.GraphModel.PizzasByID(ctx, ids)
+	endSpan(span, len(pizzas), err)
+	return pizzas, err
+}
+
+func (t tracedGraphModel) PizzasByVenue(ctx context.Context, venueIDs []int64) (map[int64][]*Pizza, error) {
+	ctx, span := startSpan(ctx, "GraphModel.PizzasByVenue")
+	pizzas, err := t.GraphModel.PizzasByVenue(ctx, venueIDs)
+	endSpan(span, len(pizzas), err)
+	return pizzas, err
+}
+
+func (t tracedGraphModel) PizzasByReview(ctx context.Context, reviewIDs []int64) (map[int64][]*Pizza, error) {
+	ctx, span := startSpan(ctx, "GraphModel.PizzasByReview")
+	pizzas, err := t.GraphModel.PizzasByReview(ctx, reviewIDs)
+	endSpan(span, len(pizzas), err)
+	return pizzas, err
+}
+
+func (t tracedGraphModel) ReviewsByID(ctx context.Context, ids []int64) (map[int64]*Review, error) {
+	ctx, span := startSpan(ctx, "GraphModel.ReviewsByID")
+	reviews, err := t.GraphModel.ReviewsByID(ctx, ids)
+	endSpan(span, len(reviews), err)
+	return reviews, err
+}
+
+func (t tracedGraphModel) Scores(ctx context.Context, venueIDs []int64) (map[int64]*Scores, error) {
+	ctx, span := startSpan(ctx, "GraphModel.Scores")
+	scores, err := t.GraphModel.Scores(ctx, venueIDs)
+	endSpan(span, len(scores), err)
+	return scores, err
+}
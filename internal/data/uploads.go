@@ -0,0 +1,204 @@
+package data
+
+import (
+	"time"
+	"database/sql"
+	"errors"
+	"context"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	_ "github.com/lib/pq"
+)
+
+// progress of a resumable upload, the bytes themselves live in the image's file
+type Upload struct {
+	ImageID int64 `json:"image_id"`
+	Length int64 `json:"length"`
+	Offset int64 `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateUpload(v *validator.Validator, upload *Upload, maxSize int64) {
+	v.Check(upload.Length > 0, "length", "must be greater than zero")
+	v.Check(upload.Length <= maxSize, "length", "must not be larger than the maximum upload size")
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+// creates the pending image and its upload state together so neither exists without the other
+func (um UploadModel) Insert(ctx context.Context, image *Image, upload *Upload) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO images (
+		filename,
+		content_type,
+		location,
+		status,
+		checksum
+	)
+	VALUES($1, $2, $3, $4, $5)
+	RETURNING id, created_at
+	`
+
+	args := []interface{}{
+		image.Filename, image.ContentType, image.Location, image.Status, image.Checksum,
+	}
+
+	err = tx.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&image.ID, &image.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	upload.ImageID = image.ID
+
+	query = `
+	INSERT INTO image_uploads (
+		image_id,
+		upload_length,
+		upload_offset
+	)
+	VALUES($1, $2, $3)
+	RETURNING created_at
+	`
+
+	args = []interface{}{
+		upload.ImageID, upload.Length, upload.Offset,
+	}
+
+	err = tx.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&upload.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (um UploadModel) Get(ctx context.Context, imageID int64) (*Upload, error) {
+	if imageID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT image_id,
+		upload_length,
+		upload_offset,
+		created_at
+		FROM image_uploads WHERE image_id = $1
+	`
+
+	var upload Upload
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, tag(ctx, query), imageID).Scan(
+		&upload.ImageID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &upload, nil
+}
+
+// moves the offset forward from what the caller last read, so two PATCH
+// requests racing on the same upload can't both win
+func (um UploadModel) UpdateOffset(ctx context.Context, upload *Upload, from int64) error {
+	query := `
+		UPDATE image_uploads
+		SET upload_offset = $1
+		WHERE image_id = $2 AND upload_offset = $3
+		RETURNING upload_offset
+	`
+
+	args := []interface{}{
+		upload.Offset,
+		upload.ImageID,
+		from,
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&upload.Offset)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// claims the upload for one request until release is called, so two PATCH
+// requests can't write its file at once. ErrEditConflict means another request
+// holds it. The claim is an advisory lock scoped to a transaction, it keeps a
+// connection for as long as the chunk takes to arrive and goes with it if the
+// process dies, so ctx must not be one that ends before release is called
+func (um UploadModel) Lock(ctx context.Context, imageID int64) (release func(), err error) {
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT pg_try_advisory_xact_lock($1)`
+
+	queryCtx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	var locked bool
+
+	err = tx.QueryRowContext(queryCtx, tag(ctx, query), imageID).Scan(&locked)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if !locked {
+		tx.Rollback()
+		return nil, ErrEditConflict
+	}
+
+	return func() { tx.Rollback() }, nil
+}
+
+type MockUploadModel struct {}
+
+func (um MockUploadModel) Insert(ctx context.Context, image *Image, upload *Upload) error {
+	return nil
+}
+
+func (um MockUploadModel) Get(ctx context.Context, imageID int64) (*Upload, error) {
+	return nil, nil
+}
+
+func (um MockUploadModel) UpdateOffset(ctx context.Context, upload *Upload, from int64) error {
+	return nil
+}
+
+func (um MockUploadModel) Lock(ctx context.Context, imageID int64) (func(), error) {
+	return func() {}, nil
+}
\ No newline at end of file
@@ -0,0 +1,267 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/tclohm/project-pizza/internal/validator"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+type User struct {
+	ID 			int64 		`json:"id"`
+	CreatedAt 	time.Time 	`json:"created_at"`
+	Name 		string 		`json:"name"`
+	Email 		string 		`json:"email"`
+	Password 	password 	`json:"-"`
+	Activated 	bool 		`json:"activated"`
+	Version 	int 		`json:"-"`
+}
+
+// the plaintext is only kept around long enough to validate it
+type password struct {
+	plaintext *string
+	hash []byte
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+
+	return nil
+}
+
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRx), "email", "must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	// bcrypt ignores everything past 72 bytes
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	// a user without a hash can never log in, this is a bug in the caller not bad input
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+type UserModel struct {
+	DB *sql.DB
+}
+
+func (um UserModel) Insert(ctx context.Context, user *User) error {
+	query := `
+	INSERT INTO users (
+		name,
+		email,
+		password_hash,
+		activated
+	) VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version
+	`
+
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (um UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id,
+		created_at,
+		name,
+		email,
+		password_hash,
+		activated,
+		version
+		FROM users
+		WHERE email = $1
+	`
+
+	var user User
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, tag(ctx, query), email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// the version check stops two concurrent edits from silently overwriting each other
+func (um UserModel) Update(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1,
+		email = $2,
+		password_hash = $3,
+		activated = $4,
+		version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version
+	`
+
+	args := []interface{}{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (um UserModel) GetAll(ctx context.Context) ([]*User, error) {
+	query := `
+		SELECT id,
+		created_at,
+		name,
+		email,
+		password_hash,
+		activated,
+		version
+		FROM users
+		ORDER BY id
+	`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := um.DB.QueryContext(ctx, tag(ctx, query))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+type MockUserModel struct {}
+
+func (um MockUserModel) Insert(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (um MockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return nil, nil
+}
+
+func (um MockUserModel) Update(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (um MockUserModel) GetAll(ctx context.Context) ([]*User, error) {
+	return nil, nil
+}
@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"context"
-	_ "fmt"
+	"fmt"
 	_ "github.com/lib/pq"
 
 	"github.com/tclohm/project-pizza/internal/validator"
@@ -25,6 +25,7 @@ type PizzaReviewed struct {
 
 type Opinion struct {
 	VenueId 			int64 		`json:"venue_id"`
+	ReviewId 			int64 		`json:"review_id"`
 	PizzaId 			int64 		`json:"pizza_id"`
 	PizzaName 			string 		`json:"pizza_name"`
 	PizzaStyle 			string 		`json:"pizza_style"`
@@ -40,6 +41,7 @@ type Opinion struct {
 	PizzaImageID		int64 		`json:"pizza_image_id"`
 	PizzaLocation		string 		`json:"pizza_image_location"`
 	CreatedAt			time.Time 	`json:"created_at"`
+	Images 				[]*ReviewImage `json:"images"`
 }
 
 
@@ -61,7 +63,7 @@ type VenuePizzaModel struct {
 	DB *sql.DB
 }
 
-func (vpm VenuePizzaModel) Insert(venuePizza *VenuePizza) error {
+func (vpm VenuePizzaModel) Insert(ctx context.Context, venuePizza *VenuePizza) error {
 	query := `
 	INSERT INTO venuepizzas (
 		venue_id, pizza_id
@@ -73,16 +75,16 @@ func (vpm VenuePizzaModel) Insert(venuePizza *VenuePizza) error {
 		venuePizza.VenueId, venuePizza.PizzaId,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	// passing in the slice and scanning the system generated id
-	return vpm.DB.QueryRowContext(ctx, query, args...).Scan(&venuePizza.ID)
+	return vpm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&venuePizza.ID)
 }
 
 
 
-func (vpm VenuePizzaModel) GetPizza(id int64) (*Opinion, error) {
+func (vpm VenuePizzaModel) GetPizza(ctx context.Context, id int64) (*Opinion, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -105,26 +107,26 @@ func (vpm VenuePizzaModel) GetPizza(id int64) (*Opinion, error) {
 		reviews.charness,
 		reviews.spiciness,
 		reviews.conclusion,
-		images.filename as pizza_image_filename,
-		images.id as pizza_image_id,
-		images.location as pizza_image_location,
-		reviews.created_at
+		COALESCE(images.filename, '') as pizza_image_filename,
+		COALESCE(images.id, 0) as pizza_image_id,
+		COALESCE(images.location, '') as pizza_image_location,
+		reviews.created_at,
+		reviews.id as review_id
 FROM venuepizzas
 JOIN pizzas ON pizzas.id = venuepizzas.pizza_id
 JOIN reviews ON reviews.id = pizzas.review_id
-JOIN images ON reviews.image_id = images.id
+LEFT JOIN images ON reviews.image_id = images.id
 WHERE pizza_id = $1
 ORDER BY reviews.created_at DESC
 	`
 
 	var opinion Opinion
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	err := vpm.DB.QueryRowContext(ctx, query, id).Scan(
+	err := vpm.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
 		&opinion.VenueId,
 		&opinion.PizzaId,
 		&opinion.PizzaName,
@@ -140,7 +142,8 @@ ORDER BY reviews.created_at DESC
 		&opinion.PizzaImageFilename, 	
 		&opinion.PizzaImageID,		
 		&opinion.PizzaLocation,	
-		&opinion.CreatedAt,		
+		&opinion.CreatedAt,
+		&opinion.ReviewId,
 	)
 
 	if err != nil {
@@ -152,10 +155,15 @@ ORDER BY reviews.created_at DESC
 		}
 	}
 
+	err = attachOpinionImages(ctx, vpm.DB, []*Opinion{&opinion})
+	if err != nil {
+		return nil, err
+	}
+
 	return &opinion, nil
 }
 
-func (vpm VenuePizzaModel) Get(id int64) (*VenuePizza, error) {
+func (vpm VenuePizzaModel) Get(ctx context.Context, id int64) (*VenuePizza, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -168,13 +176,12 @@ WHERE pizza_id = $1
 	`
 
 	var venuepizza VenuePizza
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	err := vpm.DB.QueryRowContext(ctx, query, id).Scan(
+	err := vpm.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
 		&venuepizza.ID,
 		&venuepizza.VenueId,
 		&venuepizza.PizzaId,
@@ -192,7 +199,7 @@ WHERE pizza_id = $1
 	return &venuepizza, nil
 }
 
-func (vpm VenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
+func (vpm VenuePizzaModel) GetPizzasFromVenue(ctx context.Context, id int64) ([]*Opinion, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -210,20 +217,20 @@ func (vpm VenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
 		reviews.charness,
 		reviews.spiciness,
 		reviews.conclusion,
-		images.filename as pizza_image_filename,
-		images.id as pizza_image_id,
-		images.location as pizza_image_location,
-		reviews.created_at
+		COALESCE(images.filename, '') as pizza_image_filename,
+		COALESCE(images.id, 0) as pizza_image_id,
+		COALESCE(images.location, '') as pizza_image_location,
+		reviews.created_at,
+		reviews.id as review_id
 	FROM venuepizzas
 	JOIN pizzas ON pizzas.id = venuepizzas.pizza_id
 	JOIN reviews ON reviews.id = pizzas.review_id
-	JOIN images ON reviews.image_id = images.id
+	LEFT JOIN images ON reviews.image_id = images.id
 	WHERE venue_id = $1
 	ORDER BY reviews.created_at DESC`
 
 	opinions := []*Opinion{}
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
@@ -231,7 +238,7 @@ func (vpm VenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
 	// MARK: -- This is where I need to some work
 	// opinion_args := []interface{}{}
 
-	opinion_rows, err := vpm.DB.QueryContext(ctx, query, id)
+	opinion_rows, err := vpm.DB.QueryContext(ctx, tag(ctx, query), id)
 
 	if err != nil {
 		return nil, err
@@ -258,7 +265,8 @@ func (vpm VenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
 			&opinion.PizzaImageFilename, 	
 			&opinion.PizzaImageID,		
 			&opinion.PizzaLocation,	
-			&opinion.CreatedAt,				
+			&opinion.CreatedAt,
+			&opinion.ReviewId,
 		)
 
 		if err != nil {
@@ -273,11 +281,132 @@ func (vpm VenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
 
 	}
 
+	err = attachOpinionImages(ctx, vpm.DB, opinions)
+	if err != nil {
+		return nil, err
+	}
+
 	return opinions, nil
 
 }
 
-func (vpm VenuePizzaModel) Update(venuePizza *VenuePizza) error {
+// the columns an opinion list can be sorted by, keyed by the sort's name
+var opinionSortColumns = map[string]string{
+	"created_at": "reviews.created_at",
+	"price": "reviews.price",
+	"flavor": "reviews.flavor",
+	"name": "pizzas.name",
+}
+
+// a page of GetPizzasFromVenue in the order f asks for, paged by offset or by
+// cursor. A venue serving the same pizza twice has a row for each
+func (vpm VenuePizzaModel) ListPizzasFromVenue(ctx context.Context, id int64, f Filters) ([]*Opinion, Metadata, error) {
+	if id < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	column, ok := opinionSortColumns[f.sortColumn()]
+	if !ok {
+		panic("unsafe sort parameter: " + f.Sort)
+	}
+
+	page := f.pageQuery(column, "venuepizzas.id", 2)
+
+	query := fmt.Sprintf(`SELECT 
+		%s,
+		%s,
+		%s,
+		venue_id,
+		pizzas.review_id,
+		pizzas.name,
+		reviews.style as pizza_style,
+		reviews.price,
+		reviews.cheesiness,
+		reviews.flavor,
+		reviews.sauciness,
+		reviews.saltiness,
+		reviews.charness,
+		reviews.spiciness,
+		reviews.conclusion,
+		COALESCE(images.filename, '') as pizza_image_filename,
+		COALESCE(images.id, 0) as pizza_image_id,
+		COALESCE(images.location, '') as pizza_image_location,
+		reviews.created_at,
+		reviews.id as review_id
+	FROM venuepizzas
+	JOIN pizzas ON pizzas.id = venuepizzas.pizza_id
+	JOIN reviews ON reviews.id = pizzas.review_id
+	LEFT JOIN images ON reviews.image_id = images.id
+	WHERE venue_id = $1 %s
+	%s
+	%s`, page.total, page.key, page.id, page.where, page.orderBy, page.limit)
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	args := append([]interface{}{id}, page.args...)
+
+	rows, err := vpm.DB.QueryContext(ctx, tag(ctx, query), args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	opinions := []*Opinion{}
+	keys := []Cursor{}
+
+	for rows.Next() {
+		var opinion Opinion
+		key := Cursor{Sort: f.Sort}
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Key,
+			&key.ID,
+			&opinion.VenueId,
+			&opinion.PizzaId,
+			&opinion.PizzaName,
+			&opinion.PizzaStyle, 
+			&opinion.PizzaPrice,			
+			&opinion.Cheesiness, 			
+			&opinion.Flavor,				
+			&opinion.Sauciness, 			
+			&opinion.Saltiness,  			
+			&opinion.Charness, 			
+			&opinion.Spiciness,
+			&opinion.Conclusion,			
+			&opinion.PizzaImageFilename, 	
+			&opinion.PizzaImageID,		
+			&opinion.PizzaLocation,	
+			&opinion.CreatedAt,
+			&opinion.ReviewId,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		opinions = append(opinions, &opinion)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := f.paginate(&opinions, keys, totalRecords)
+
+	err = attachOpinionImages(ctx, vpm.DB, opinions)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return opinions, metadata, nil
+}
+
+func (vpm VenuePizzaModel) Update(ctx context.Context, venuePizza *VenuePizza) error {
 	query := `
 	UPDATE venuepizzas
 	SET venue_id = $1,
@@ -292,10 +421,10 @@ func (vpm VenuePizzaModel) Update(venuePizza *VenuePizza) error {
 		venuePizza.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 	// query and scan the new value in
-	err := vpm.DB.QueryRowContext(ctx, query, args...).Scan(&venuePizza.ID)
+	err := vpm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&venuePizza.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -307,7 +436,7 @@ func (vpm VenuePizzaModel) Update(venuePizza *VenuePizza) error {
 	return nil
 }
 
-func (vpm VenuePizzaModel) Delete(id int64) error {
+func (vpm VenuePizzaModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -317,7 +446,10 @@ func (vpm VenuePizzaModel) Delete(id int64) error {
 		WHERE id = $1
 	`
 
-	result, err := vpm.DB.Exec(query, id)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	result, err := vpm.DB.ExecContext(ctx, tag(ctx, query), id)
 	if err != nil {
 		return err
 	}
@@ -335,7 +467,7 @@ func (vpm VenuePizzaModel) Delete(id int64) error {
 	return nil
 }
 
-func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
+func (vpm VenuePizzaModel) GetAll(ctx context.Context) ([]*VenuePizzaMixin, error) {
 
 	// type VenuePizzaMixin struct {
 	// 	VenueId 			int64 			 `json:"venue_id"`
@@ -359,12 +491,12 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 		GROUP BY venues.id
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	venue_args := []interface{}{}
 
-	venue_rows, err := vpm.DB.QueryContext(ctx, venue_query, venue_args...)
+	venue_rows, err := vpm.DB.QueryContext(ctx, tag(ctx, venue_query), venue_args...)
 
 	if err != nil {
 		return nil, err
@@ -373,6 +505,8 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 	defer venue_rows.Close()
 
 	venuepizzas := []*VenuePizzaMixin{}
+	// every opinion that ends up in the response, so their photos load in one go
+	allOpinions := []*Opinion{}
 
 	for venue_rows.Next() {
 		var venuepizzaMixin VenuePizzaMixin
@@ -407,7 +541,7 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 
 		pizza_args := []interface{}{}
 
-		pizza_rows, err := vpm.DB.QueryContext(ctx, pizza_reviewed_for_venue_query, pizza_args...)
+		pizza_rows, err := vpm.DB.QueryContext(ctx, tag(ctx, pizza_reviewed_for_venue_query), pizza_args...)
 
 		if err != nil {
 			return nil, err
@@ -444,10 +578,11 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 				reviews.charness,
 				reviews.spiciness,
 				reviews.conclusion,
-				images.filename as pizza_image_filename,
-				images.id as pizza_image_id,
-				images.location as pizza_image_location,
-				reviews.created_at
+				COALESCE(images.filename, '') as pizza_image_filename,
+				COALESCE(images.id, 0) as pizza_image_id,
+				COALESCE(images.location, '') as pizza_image_location,
+				reviews.created_at,
+				reviews.id as review_id
 			FROM venues
 			JOIN venuepizzas
 			ON venues.id = venuepizzas.venue_id
@@ -455,14 +590,14 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 			ON pizzas.id = venuepizzas.pizza_id
 			JOIN reviews
 			ON pizzas.review_id = reviews.id
-			JOIN images
+			LEFT JOIN images
 			ON reviews.image_id = images.id
 			ORDER BY reviews.created_at DESC
 			`
 
 			opinion_args := []interface{}{}
 
-			opinion_rows, err := vpm.DB.QueryContext(ctx, opinion_query, opinion_args...)
+			opinion_rows, err := vpm.DB.QueryContext(ctx, tag(ctx, opinion_query), opinion_args...)
 
 			if err != nil {
 				return nil, err
@@ -489,7 +624,8 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 					&opinion.PizzaImageFilename, 	
 					&opinion.PizzaImageID,		
 					&opinion.PizzaLocation,	
-					&opinion.CreatedAt,				
+					&opinion.CreatedAt,
+					&opinion.ReviewId,
 				)
 
 				if err != nil {
@@ -498,6 +634,7 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 
 				if opinion.VenueId == pizzaReviewed.VenueId && opinion.PizzaName == pizzaReviewed.PizzaName {
 					opinions = append(opinions, &opinion)
+					allOpinions = append(allOpinions, &opinion)
 				}
 
 				if err = opinion_rows.Err(); err != nil {
@@ -526,35 +663,62 @@ func (vpm VenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
 
 	}
 
+	err = attachOpinionImages(ctx, vpm.DB, allOpinions)
+	if err != nil {
+		return nil, err
+	}
+
 	return venuepizzas, nil
 }
 
+func attachOpinionImages(ctx context.Context, q querier, opinions []*Opinion) error {
+	ids := make([]int64, len(opinions))
+	for i, opinion := range opinions {
+		ids[i] = opinion.ReviewId
+	}
+
+	images, err := getReviewImages(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, opinion := range opinions {
+		opinion.Images = images.of(opinion.ReviewId)
+	}
+
+	return nil
+}
+
 type MockVenuePizzaModel struct {}
 
-func (vpm MockVenuePizzaModel) Insert(venuePizza *VenuePizza) error {
+func (vpm MockVenuePizzaModel) Insert(ctx context.Context, venuePizza *VenuePizza) error {
 	return nil
 }
 
-func (vpm MockVenuePizzaModel) GetPizza(id int64) (*Opinion, error) {
+func (vpm MockVenuePizzaModel) GetPizza(ctx context.Context, id int64) (*Opinion, error) {
 	return nil, nil
 }
 
-func (vpm MockVenuePizzaModel) Get(id int64) (*VenuePizza, error) {
+func (vpm MockVenuePizzaModel) Get(ctx context.Context, id int64) (*VenuePizza, error) {
 	return nil, nil 
 }
 
-func (vpm MockVenuePizzaModel) GetPizzasFromVenue(id int64) ([]*Opinion, error) {
+func (vpm MockVenuePizzaModel) GetPizzasFromVenue(ctx context.Context, id int64) ([]*Opinion, error) {
 	return nil, nil
 }
 
-func (vpm MockVenuePizzaModel) Update(venuePizza *VenuePizza) error {
+func (vpm MockVenuePizzaModel) ListPizzasFromVenue(ctx context.Context, id int64, f Filters) ([]*Opinion, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (vpm MockVenuePizzaModel) Update(ctx context.Context, venuePizza *VenuePizza) error {
 	return nil
 }
 
-func (vpm MockVenuePizzaModel) Delete(id int64) error {
+func (vpm MockVenuePizzaModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (vpm MockVenuePizzaModel) GetAll() ([]*VenuePizzaMixin, error) {
+func (vpm MockVenuePizzaModel) GetAll(ctx context.Context) ([]*VenuePizzaMixin, error) {
 	return nil, nil
 }
\ No newline at end of file
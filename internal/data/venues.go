@@ -1,11 +1,10 @@
 package data
 
 import (
-	"time"
 	"database/sql"
 	"errors"
 	"context"
-	_ "fmt"
+	"fmt"
 
 	"github.com/tclohm/project-pizza/internal/validator"
 
@@ -18,6 +17,7 @@ type Venue struct {
 	Lat float64 `json:"lat"`
 	Lon float64 `json:"lon"`
 	Address string `json:"address"`
+	Version int `json:"-"`
 }
 
 func ValidateVenue(v *validator.Validator, venue *Venue) {
@@ -30,19 +30,19 @@ type VenueModel struct {
 	DB *sql.DB
 }
 
-func (vm VenueModel) Insert(venue *Venue) error {
+func (vm VenueModel) Insert(ctx context.Context, venue *Venue) error {
 
-	query := `SELECT id FROM venues WHERE name = $1 AND address = $2`
+	query := `SELECT id, version FROM venues WHERE name = $1 AND address = $2`
 
 	args := []interface{}{
 		venue.Name,
 		venue.Address,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	exist := vm.DB.QueryRowContext(ctx, query, args...).Scan(&venue.ID)
+	exist := vm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&venue.ID, &venue.Version)
 
 	if exist != nil && errors.Is(exist, sql.ErrNoRows) {
 
@@ -53,18 +53,15 @@ func (vm VenueModel) Insert(venue *Venue) error {
 			lon,
 			address
 		) VALUES ($1, $2, $3, $4)
-		RETURNING id
+		RETURNING id, version
 		`
 		// args slices containing values for the placeholder parameters from the venue struct
 		args = []interface{}{
 			venue.Name, venue.Lat, venue.Lon, venue.Address,
 		}
 
-		ctx, cancel = context.WithTimeout(context.Background(), 3 * time.Second)
-		defer cancel()
-
 		// passing in the slice and scanning the system generated id
-		return vm.DB.QueryRowContext(ctx, query, args...).Scan(&venue.ID)
+		return vm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&venue.ID, &venue.Version)
 		
 	}
 
@@ -74,7 +71,7 @@ func (vm VenueModel) Insert(venue *Venue) error {
 	
 }
 
-func (vm VenueModel) Get(id int64) (*Venue, error) {
+func (vm VenueModel) Get(ctx context.Context, id int64) (*Venue, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -84,23 +81,24 @@ func (vm VenueModel) Get(id int64) (*Venue, error) {
 		name, 
 		lat,
 		lon,
-		address
+		address,
+		version
 	FROM venues WHERE id = $1
 	`
 
 	var venue Venue
-	// 3-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	// release resources associated with context before Get() is returned
 	// memory leak avoided
 	defer cancel()
 
-	err := vm.DB.QueryRowContext(ctx, query, id).Scan(
+	err := vm.DB.QueryRowContext(ctx, tag(ctx, query), id).Scan(
 		&venue.ID,
 		&venue.Name,
 		&venue.Lat,
 		&venue.Lon,
 		&venue.Address,
+		&venue.Version,
 	)
 
 	if err != nil {
@@ -115,15 +113,16 @@ func (vm VenueModel) Get(id int64) (*Venue, error) {
 	return &venue, nil
 }
 
-func (vm VenueModel) Update(venue *Venue) error {
+func (vm VenueModel) Update(ctx context.Context, venue *Venue) error {
 	query := `
 		UPDATE venues
 		SET name = $1,
 		lat = $2,
 		lon = $3,
 		address = $4,
-		WHERE id = $5
-		RETURNING id
+		version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version
 	`
 
 	args := []interface{}{
@@ -132,12 +131,13 @@ func (vm VenueModel) Update(venue *Venue) error {
 		venue.Lon,
 		venue.Address,
 		venue.ID,
+		venue.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 	// query and scan the new value in
-	err := vm.DB.QueryRowContext(ctx, query, args...).Scan(&venue.ID)
+	err := vm.DB.QueryRowContext(ctx, tag(ctx, query), args...).Scan(&venue.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -149,16 +149,19 @@ func (vm VenueModel) Update(venue *Venue) error {
 	return nil
 }
 
-func (vm VenueModel) Delete(id int64) error {
+func (vm VenueModel) Delete(ctx context.Context, id int64, version int) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 		DELETE FROM venues
-		WHERE id = $1`
+		WHERE id = $1 AND version = $2`
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
 
-	result, err := vm.DB.Exec(query, id)
+	result, err := vm.DB.ExecContext(ctx, tag(ctx, query), id, version)
 	if err != nil {
 		return err
 	}
@@ -169,30 +172,32 @@ func (vm VenueModel) Delete(id int64) error {
 		return err
 	}
 
+	// the row is gone or has moved on from the version the caller saw
 	if rows == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 	
 	return nil
 }
 
-func (vm VenueModel) GetAll() ([]*Venue, error) {
+func (vm VenueModel) GetAll(ctx context.Context) ([]*Venue, error) {
 	query := `
 		SELECT 
 		id, 
 		name, 
 		lat,
 		lon,
-		address
+		address,
+		version
 		FROM venues
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	args := []interface{}{}
 
-	rows, err := vm.DB.QueryContext(ctx, query, args...)
+	rows, err := vm.DB.QueryContext(ctx, tag(ctx, query), args...)
 
 	if err != nil {
 		return nil, err
@@ -211,6 +216,7 @@ func (vm VenueModel) GetAll() ([]*Venue, error) {
 			&venue.Lat,
 			&venue.Lon,
 			&venue.Address,
+			&venue.Version,
 		)
 
 		if err != nil {
@@ -227,25 +233,162 @@ func (vm VenueModel) GetAll() ([]*Venue, error) {
 	return venues, nil
 }
 
+// a page of the venues in the order f asks for, paged by offset or by cursor
+func (vm VenueModel) List(ctx context.Context, f Filters) ([]*Venue, Metadata, error) {
+	page := f.pageQuery(f.sortColumn(), "id", 1)
+
+	query := fmt.Sprintf(`
+		SELECT 
+		%s,
+		%s,
+		id, 
+		name, 
+		lat,
+		lon,
+		address,
+		version
+		FROM venues
+		WHERE true %s
+		%s
+		%s`, page.total, page.key, page.where, page.orderBy, page.limit)
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	rows, err := vm.DB.QueryContext(ctx, tag(ctx, query), page.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	venues := []*Venue{}
+	keys := []Cursor{}
+
+	for rows.Next() {
+		var venue Venue
+		key := Cursor{Sort: f.Sort}
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Key,
+			&venue.ID,
+			&venue.Name,
+			&venue.Lat,
+			&venue.Lon,
+			&venue.Address,
+			&venue.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = venue.ID
+
+		venues = append(venues, &venue)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return venues, f.paginate(&venues, keys, totalRecords), nil
+}
+
+// moves everything served at the duplicate venue over to the one being kept, then
+// deletes the duplicate. Both ids must exist
+func (vm VenueModel) Merge(ctx context.Context, duplicateID, keepID int64) error {
+	if duplicateID < 1 || keepID < 1 || duplicateID == keepID {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	tx, err := vm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// the kept venue gains pizzas, so an ETag fetched before the merge goes stale.
+	// Updating the row also locks it until the merge commits
+	result, err := tx.ExecContext(ctx, tag(ctx, `UPDATE venues SET version = version + 1 WHERE id = $1`), keepID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	// a pizza served at both venues would otherwise end up linked to the kept one twice
+	query := `
+		DELETE FROM venuepizzas
+		WHERE venue_id = $2
+		AND pizza_id IN (SELECT pizza_id FROM venuepizzas WHERE venue_id = $1)`
+
+	_, err = tx.ExecContext(ctx, tag(ctx, query), keepID, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tag(ctx, `UPDATE venuepizzas SET venue_id = $1 WHERE venue_id = $2`), keepID, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	result, err = tx.ExecContext(ctx, tag(ctx, `DELETE FROM venues WHERE id = $1`), duplicateID)
+	if err != nil {
+		return err
+	}
+
+	rows, err = result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
 
 type MockVenueModel struct {}
 
-func (vm MockVenueModel) Insert(venue *Venue) error {
+func (vm MockVenueModel) Insert(ctx context.Context, venue *Venue) error {
 	return nil
 }
 
-func (vm MockVenueModel) Get(id int64) (*Venue, error) {
+func (vm MockVenueModel) Get(ctx context.Context, id int64) (*Venue, error) {
 	return nil, nil
 }
 
-func (vm MockVenueModel) Update(venue *Venue) error {
+func (vm MockVenueModel) Update(ctx context.Context, venue *Venue) error {
 	return nil
 }
 
-func (vm MockVenueModel) Delete(id int64) error {
+func (vm MockVenueModel) Delete(ctx context.Context, id int64, version int) error {
 	return nil
 }
 
-func (vm MockVenueModel) GetAll() ([]*Venue, error) {
+func (vm MockVenueModel) GetAll(ctx context.Context) ([]*Venue, error) {
 	return nil, nil
-}
\ No newline at end of file
+}
+func (vm MockVenueModel) List(ctx context.Context, f Filters) ([]*Venue, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (vm MockVenueModel) Merge(ctx context.Context, duplicateID, keepID int64) error {
+	return nil
+}
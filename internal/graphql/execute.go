@@ -0,0 +1,563 @@
+// Package graphql is a small GraphQL executor: queries and mutations over
+// objects, scalars, lists and input objects, with fragments and the @skip and
+// @include directives. There are no interfaces, unions, enums, subscriptions
+// or introspection beyond __typename.
+//
+// Fields are resolved a level at a time, so a resolver that returns a Thunk
+// from a Loader gets batched with the same field on every sibling object
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Request is a GraphQL request as clients POST it
+type Request struct {
+	Query 			string 					`json:"query"`
+	OperationName 	string 					`json:"operationName"`
+	Variables 		map[string]interface{} 	`json:"variables"`
+}
+
+type Location struct {
+	Line 	int `json:"line"`
+	Column 	int `json:"column"`
+}
+
+// Error is one entry in a response's errors list
+type Error struct {
+	Message 	string 			`json:"message"`
+	Locations 	[]Location 		`json:"locations,omitempty"`
+	Path 		[]interface{} 	`json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(locations []Location, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: locations}
+}
+
+// Result is the response to a Request
+type Result struct {
+	// false when the request was rejected before execution, the response
+	// then has no data at all rather than null data
+	Executed 	bool
+	Data 		interface{}
+	Errors 		[]*Error
+}
+
+func (r *Result) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+
+	if r.Executed {
+		data, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"data":`)
+		buf.Write(data)
+	}
+
+	if len(r.Errors) > 0 {
+		errors, err := json.Marshal(r.Errors)
+		if err != nil {
+			return nil, err
+		}
+		if r.Executed {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"errors":`)
+		buf.Write(errors)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func requestError(err error) *Result {
+	if gqlErr, ok := err.(*Error); ok {
+		return &Result{Errors: []*Error{gqlErr}}
+	}
+	return &Result{Errors: []*Error{{Message: err.Error()}}}
+}
+
+// Execute parses, validates and runs req. Errors resolvers return are reported
+// in the result next to whatever data could still be resolved
+func (s *Schema) Execute(ctx context.Context, req Request) *Result {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError(err)
+	}
+
+	errs := validate(s, doc)
+	if len(errs) > 0 {
+		return &Result{Errors: errs}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return requestError(err)
+	}
+
+	variables, err := s.coerceVariables(op, req.Variables)
+	if err != nil {
+		return requestError(err)
+	}
+
+	root := s.query
+	if op.kind == "mutation" {
+		root = s.mutation
+	}
+
+	e := &execution{
+		ctx: ctx,
+		fragments: doc.fragments,
+		variables: variables,
+	}
+
+	data := &orderedObject{}
+
+	// mutations run one root field at a time, in order
+	for _, group := range e.collectFields(root, op.selections) {
+		e.resolveField(root, nil, data, group, nil)
+
+		if op.kind == "mutation" {
+			e.drain()
+		}
+	}
+
+	e.drain()
+
+	result := &Result{Executed: true, Errors: e.errors}
+
+	if value, ok := nullify(data, nil); ok {
+		result.Data = value
+	}
+
+	return result
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, newError(nil, "Must provide operation name if query contains multiple operations.")
+		}
+		return doc.operations[0], nil
+	}
+
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, newError(nil, "Unknown operation named %q.", name)
+}
+
+func (s *Schema) coerceVariables(op *operation, input map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+
+	for _, def := range op.variables {
+		// validate has made sure the type exists
+		t, _ := s.resolveTypeRef(def.typ)
+
+		v, ok := input[def.name]
+		if !ok {
+			if def.defaultValue != nil {
+				value, _, err := coerceLiteral(def.defaultValue, t, nil)
+				if err != nil {
+					return nil, newError([]Location{def.loc}, "Variable $%s has an invalid default value: %s.", def.name, err)
+				}
+				variables[def.name] = value
+			} else if def.typ.nonNull {
+				return nil, newError([]Location{def.loc}, "Variable $%s of required type %s was not provided.", def.name, t)
+			}
+			continue
+		}
+
+		value, err := coerceInput(v, t)
+		if err != nil {
+			return nil, newError([]Location{def.loc}, "Variable $%s got an invalid value: %s.", def.name, err)
+		}
+
+		variables[def.name] = value
+	}
+
+	return variables, nil
+}
+
+type execution struct {
+	ctx 		context.Context
+	fragments 	map[string]*fragment
+	variables 	map[string]interface{}
+	errors 		[]*Error
+	// fields whose value has been resolved but not completed
+	pending 	[]*pendingField
+}
+
+type pendingField struct {
+	object 		*orderedObject
+	index 		int
+	typ 		Type
+	value 		interface{}
+	// an error is already reported for the field, its null needs no other
+	failed 		bool
+	selections 	[]*selection
+	path 		[]interface{}
+}
+
+// the selections sharing one response key, fields with the same alias merge
+type fieldGroup struct {
+	key 		string
+	selections 	[]*selection
+}
+
+func (e *execution) errorf(path []interface{}, sel *selection, format string, args ...interface{}) {
+	err := newError([]Location{sel.loc}, format, args...)
+	err.Path = append([]interface{}{}, path...)
+	e.errors = append(e.errors, err)
+}
+
+// the fields selected on obj, in document order, with fragments flattened
+func (e *execution) collectFields(obj *Object, selections []*selection) []*fieldGroup {
+	var groups []*fieldGroup
+	byKey := map[string]*fieldGroup{}
+
+	var collect func(selections []*selection, visited map[string]bool)
+	collect = func(selections []*selection, visited map[string]bool) {
+		for _, sel := range selections {
+			if !e.included(sel.directives) {
+				continue
+			}
+
+			switch sel.kind {
+			case selectField:
+				group, ok := byKey[sel.responseKey()]
+				if !ok {
+					group = &fieldGroup{key: sel.responseKey()}
+					byKey[group.key] = group
+					groups = append(groups, group)
+				}
+				group.selections = append(group.selections, sel)
+			case selectInlineFragment:
+				if sel.typeCondition == "" || sel.typeCondition == obj.Name {
+					collect(sel.selections, visited)
+				}
+			case selectFragmentSpread:
+				f, ok := e.fragments[sel.name]
+				if !ok || visited[sel.name] || f.typeCondition != obj.Name || !e.included(f.directives) {
+					continue
+				}
+				visited[sel.name] = true
+				collect(f.selections, visited)
+			}
+		}
+	}
+
+	collect(selections, map[string]bool{})
+
+	return groups
+}
+
+// false when @skip or @include leave the selection out
+func (e *execution) included(directives []*directive) bool {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+
+		args, err := coerceArgs(Args{"if": {Type: NonNullOf(Boolean)}}, argumentMap(d.arguments), e.variables)
+		if err != nil {
+			continue
+		}
+
+		if args["if"] == (d.name == "skip") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func argumentMap(args []*argument) map[string]*value {
+	m := make(map[string]*value, len(args))
+	for _, arg := range args {
+		m[arg.name] = arg.value
+	}
+	return m
+}
+
+// runs the resolver for one field of source and queues the value for completion
+func (e *execution) resolveField(obj *Object, source interface{}, out *orderedObject, group *fieldGroup, path []interface{}) {
+	sel := group.selections[0]
+	path = append(path[:len(path):len(path)], group.key)
+
+	if sel.name == "__typename" {
+		out.set(group.key, obj.Name, NonNullOf(String))
+		return
+	}
+
+	field := obj.Fields[sel.name]
+
+	index := out.set(group.key, nil, field.Type)
+
+	pending := &pendingField{
+		object: out,
+		index: index,
+		typ: field.Type,
+		selections: group.selections,
+		path: path,
+	}
+
+	e.pending = append(e.pending, pending)
+
+	args, err := coerceArgs(field.Args, argumentMap(sel.arguments), e.variables)
+	if err != nil {
+		e.errorf(path, sel, "%s", err)
+		pending.failed = true
+		return
+	}
+
+	resolve := field.Resolve
+	if resolve == nil {
+		resolve = defaultResolver(sel.name)
+	}
+
+	pending.value, err = resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+	if err != nil {
+		e.errorf(path, sel, "%s", err)
+		pending.value = nil
+		pending.failed = true
+	}
+}
+
+// completes the queued fields a level at a time. Every thunk in a level is
+// created before the first one is forced, so loaders see all their keys at once
+func (e *execution) drain() {
+	for len(e.pending) > 0 {
+		level := e.pending
+		e.pending = nil
+
+		for _, p := range level {
+			thunk, ok := p.value.(Thunk)
+			if !ok {
+				continue
+			}
+
+			value, err := thunk()
+			if err != nil {
+				e.errorf(p.path, p.selections[0], "%s", err)
+				value = nil
+				p.failed = true
+			}
+
+			p.value = value
+		}
+
+		for _, p := range level {
+			p.object.values[p.index] = e.complete(p.typ, p.value, p)
+		}
+	}
+}
+
+// shapes value to t. Objects get their fields resolved, which queues them for
+// the next level
+func (e *execution) complete(t Type, value interface{}, p *pendingField) interface{} {
+	if nn, ok := t.(*NonNull); ok {
+		if isNil(value) {
+			if !p.failed {
+				e.errorf(p.path, p.selections[0], "Cannot return null for non-nullable field.")
+			}
+			return nil
+		}
+		return e.complete(nn.Of, value, p)
+	}
+
+	if isNil(value) {
+		return nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			e.errorf(p.path, p.selections[0], "Expected a list, got %T.", value)
+			return nil
+		}
+
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			item := &pendingField{
+				selections: p.selections,
+				path: append(p.path[:len(p.path):len(p.path)], i),
+			}
+			items[i] = e.complete(t.Of, v.Index(i).Interface(), item)
+		}
+		return items
+	case *Scalar:
+		// a nullable scalar is commonly a pointer, nil ones are handled above
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+			value = rv.Elem().Interface()
+		}
+
+		out, err := t.Serialize(value)
+		if err != nil {
+			e.errorf(p.path, p.selections[0], "%s", err)
+			return nil
+		}
+		return out
+	case *Object:
+		var selections []*selection
+		for _, sel := range p.selections {
+			selections = append(selections, sel.selections...)
+		}
+
+		out := &orderedObject{}
+		for _, group := range e.collectFields(t, selections) {
+			e.resolveField(t, value, out, group, p.path)
+		}
+		return out
+	default:
+		e.errorf(p.path, p.selections[0], "%s is not an output type.", t)
+		return nil
+	}
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+// reads the exported struct field or map key called name, ignoring case
+func defaultResolver(name string) ResolveFunc {
+	return func(p ResolveParams) (interface{}, error) {
+		v := reflect.ValueOf(p.Source)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			field := v.FieldByNameFunc(func(field string) bool {
+				return strings.EqualFold(field, name)
+			})
+			if field.IsValid() && field.CanInterface() {
+				return field.Interface(), nil
+			}
+		case reflect.Map:
+			if v.Type().Key().Kind() == reflect.String {
+				value := v.MapIndex(reflect.ValueOf(name))
+				if value.IsValid() {
+					return value.Interface(), nil
+				}
+			}
+		}
+
+		return nil, nil
+	}
+}
+
+// a response object, its keys stay in the order the query selected them
+type orderedObject struct {
+	keys 	[]string
+	values 	[]interface{}
+	types 	[]Type
+}
+
+// adds key unless a merged selection already did, returns its index
+func (o *orderedObject) set(key string, value interface{}, t Type) int {
+	for i, k := range o.keys {
+		if k == key {
+			return i
+		}
+	}
+
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, value)
+	o.types = append(o.types, t)
+
+	return len(o.keys) - 1
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// a null in a non-null position makes its parent null, all the way up to the
+// first nullable field. ok is false when v itself has to become null for that
+func nullify(v interface{}, t Type) (interface{}, bool) {
+	if nn, ok := t.(*NonNull); ok {
+		v, ok := nullify(v, nn.Of)
+		return v, ok && v != nil
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		of := t.(*List).Of
+		for i := range v {
+			item, ok := nullify(v[i], of)
+			if !ok {
+				return nil, true
+			}
+			v[i] = item
+		}
+		return v, true
+	case *orderedObject:
+		for i := range v.values {
+			value, ok := nullify(v.values[i], v.types[i])
+			if !ok {
+				return nil, t != nil
+			}
+			v.values[i] = value
+		}
+		return v, true
+	default:
+		return v, true
+	}
+}
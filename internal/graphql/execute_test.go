@@ -0,0 +1,275 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testPizza struct {
+	ID 		int64
+	Name 	string
+	Venues 	[]int64
+}
+
+type testVenue struct {
+	ID 		int64
+	Name 	string
+}
+
+var testPizzas = []*testPizza{
+	{ID: 1, Name: "Margherita", Venues: []int64{1, 2}},
+	{ID: 2, Name: "Marinara", Venues: []int64{2}},
+	{ID: 3, Name: "Diavola"},
+}
+
+var testVenues = map[int64]*testVenue{
+	1: {ID: 1, Name: "Lucali"},
+	2: {ID: 2, Name: "Di Fara"},
+}
+
+// a schema over testPizzas and testVenues, venues are fetched through the
+// loader execute puts in the context
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+
+	venue := &Object{
+		Name: "Venue",
+		Fields: Fields{
+			"id": {Type: NonNullOf(ID)},
+			"name": {Type: NonNullOf(String)},
+		},
+	}
+
+	pizza := &Object{
+		Name: "Pizza",
+		Fields: Fields{
+			"id": {Type: NonNullOf(ID)},
+			"name": {Type: NonNullOf(String)},
+			"venues": {
+				Type: NonNullOf(ListOf(NonNullOf(venue))),
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					loader := p.Context.Value(testLoaderKey{}).(*Loader)
+
+					ids := p.Source.(*testPizza).Venues
+
+					thunks := make([]Thunk, len(ids))
+					for i, id := range ids {
+						thunks[i] = loader.Load(id)
+					}
+
+					return Thunk(func() (interface{}, error) {
+						venues := make([]interface{}, len(thunks))
+						for i, thunk := range thunks {
+							v, err := thunk()
+							if err != nil {
+								return nil, err
+							}
+							venues[i] = v
+						}
+						return venues, nil
+					}), nil
+				},
+			},
+		},
+	}
+
+	query := &Object{
+		Name: "Query",
+		Fields: Fields{
+			"pizzas": {
+				Type: NonNullOf(ListOf(NonNullOf(pizza))),
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return testPizzas, nil
+				},
+			},
+			"pizza": {
+				Type: pizza,
+				Args: Args{"id": {Type: NonNullOf(ID)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					for _, pizza := range testPizzas {
+						if p.Args["id"] == strconv.FormatInt(pizza.ID, 10) {
+							return pizza, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			"greeting": {
+				Type: NonNullOf(String),
+				Args: Args{"name": {Type: String, Default: "world"}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return "hello " + p.Args["name"].(string), nil
+				},
+			},
+			"broken": {
+				Type: String,
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return nil, errors.New("the oven is off")
+				},
+			},
+			"missing": {
+				Type: NonNullOf(String),
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return nil, nil
+				},
+			},
+		},
+	}
+
+	var log []string
+
+	mutation := &Object{
+		Name: "Mutation",
+		Fields: Fields{
+			"bake": {
+				Type: NonNullOf(String),
+				Args: Args{"name": {Type: NonNullOf(String)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					log = append(log, p.Args["name"].(string))
+					return strings.Join(log, ","), nil
+				},
+			},
+		},
+	}
+
+	s, err := NewSchema(query, mutation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+type testLoaderKey struct{}
+
+// executes req with a fresh venue loader and returns the response as JSON.
+// The keys of each batch the loader fetches are appended to batches
+func execute(t *testing.T, s *Schema, req Request, batches *[][]interface{}) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	loader := NewLoader(ctx, func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		if batches != nil {
+			*batches = append(*batches, keys)
+		}
+
+		venues := map[interface{}]interface{}{}
+		for _, key := range keys {
+			if venue, ok := testVenues[key.(int64)]; ok {
+				venues[key] = venue
+			}
+		}
+		return venues, nil
+	})
+
+	ctx = context.WithValue(ctx, testLoaderKey{}, loader)
+
+	js, err := json.Marshal(s.Execute(ctx, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(js)
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name 	string
+		req 	Request
+		want 	string
+	}{
+		{
+			name: "fields in query order",
+			req: Request{Query: `{ pizzas { name id } }`},
+			want: `{"data":{"pizzas":[{"name":"Margherita","id":"1"},{"name":"Marinara","id":"2"},{"name":"Diavola","id":"3"}]}}`,
+		},
+		{
+			name: "aliases and __typename",
+			req: Request{Query: `{ first: pizza(id: 1) { __typename title: name } none: pizza(id: 9) { name } }`},
+			want: `{"data":{"first":{"__typename":"Pizza","title":"Margherita"},"none":null}}`,
+		},
+		{
+			name: "argument defaults and variables",
+			req: Request{
+				Query: `query ($who: String) { default: greeting a: greeting(name: "Ana") b: greeting(name: $who) }`,
+				Variables: map[string]interface{}{"who": "Bo"},
+			},
+			want: `{"data":{"default":"hello world","a":"hello Ana","b":"hello Bo"}}`,
+		},
+		{
+			name: "variable defaults",
+			req: Request{Query: `query ($id: ID = 2) { pizza(id: $id) { name } }`},
+			want: `{"data":{"pizza":{"name":"Marinara"}}}`,
+		},
+		{
+			name: "fragments merge with fields",
+			req: Request{Query: `{ pizza(id: 1) { id ...names ... on Pizza { venues { name } } } } fragment names on Pizza { name }`},
+			want: `{"data":{"pizza":{"id":"1","name":"Margherita","venues":[{"name":"Lucali"},{"name":"Di Fara"}]}}}`,
+		},
+		{
+			name: "skip and include",
+			req: Request{
+				Query: `query ($yes: Boolean!) { pizza(id: 2) { id @skip(if: $yes) name @include(if: $yes) } }`,
+				Variables: map[string]interface{}{"yes": true},
+			},
+			want: `{"data":{"pizza":{"name":"Marinara"}}}`,
+		},
+		{
+			name: "resolver error on a nullable field",
+			req: Request{Query: `{ greeting broken }`},
+			want: `{"data":{"greeting":"hello world","broken":null},"errors":[{"message":"the oven is off","locations":[{"line":1,"column":12}],"path":["broken"]}]}`,
+		},
+		{
+			name: "null in a non-null field nulls the data",
+			req: Request{Query: `{ greeting missing }`},
+			want: `{"data":null,"errors":[{"message":"Cannot return null for non-nullable field.","locations":[{"line":1,"column":12}],"path":["missing"]}]}`,
+		},
+		{
+			name: "missing required variable",
+			req: Request{Query: `query ($id: ID!) { pizza(id: $id) { name } }`},
+			want: `{"errors":[{"message":"Variable $id of required type ID! was not provided.","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name: "operation by name",
+			req: Request{Query: `query a { greeting } query b { pizza(id: 3) { name } }`, OperationName: "b"},
+			want: `{"data":{"pizza":{"name":"Diavola"}}}`,
+		},
+		{
+			name: "mutations run in order",
+			req: Request{Query: `mutation { one: bake(name: "a") two: bake(name: "b") }`},
+			want: `{"data":{"one":"a","two":"a,b"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := execute(t, testSchema(t), tt.req, nil)
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteBatchesLoads(t *testing.T) {
+	var batches [][]interface{}
+
+	got := execute(t, testSchema(t), Request{Query: `{ pizzas { name venues { name } } again: pizzas { venues { id } } }`}, &batches)
+
+	want := `{"data":{"pizzas":[{"name":"Margherita","venues":[{"name":"Lucali"},{"name":"Di Fara"}]},{"name":"Marinara","venues":[{"name":"Di Fara"}]},{"name":"Diavola","venues":[]}],` +
+		`"again":[{"venues":[{"id":"1"},{"id":"2"}]},{"venues":[{"id":"2"}]},{"venues":[]}]}}`
+
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// every venue on the level is asked for in one batch, the second list is served from the loader
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Errorf("got batches %v, want one batch of the two venue ids", batches)
+	}
+}
@@ -0,0 +1,282 @@
+package graphql
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind 	tokenKind
+	value 	string
+	loc 	Location
+}
+
+// splits a document into tokens, commas and comments are skipped like whitespace
+type lexer struct {
+	src 		string
+	pos 		int
+	line 		int
+	lineStart 	int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1}
+}
+
+func (l *lexer) errorf(loc Location, format string, args ...interface{}) *Error {
+	return newError([]Location{loc}, "Syntax Error: "+format, args...)
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	loc := Location{Line: l.line, Column: l.pos - l.lineStart + 1}
+
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunct, value: "...", loc: loc}, nil
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, value: string(c), loc: loc}, nil
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		return l.blockString(loc)
+	case c == '"':
+		return l.string(loc)
+	default:
+		r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+		return token{}, l.errorf(loc, "unexpected character %q", r)
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case ' ', '\t', ',', '\r':
+			l.pos++
+		case '\n':
+			l.pos++
+			l.line++
+			l.lineStart = l.pos
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			// a byte order mark is ignored too
+			if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				l.pos += len("\uFEFF")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	intStart := l.pos
+
+	if !l.digits() {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+
+	// the spec doesn't allow 012, only 0 itself may start with a zero
+	if l.src[intStart] == '0' && l.pos-intStart > 1 {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if !l.digits() {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !l.digits() {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+	}
+
+	// 1a or 1.5b would otherwise lex as a number followed by a name
+	if l.pos < len(l.src) && (l.src[l.pos] == '_' || l.src[l.pos] == '.' || isLetter(l.src[l.pos])) {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+
+	return token{kind: kind, value: l.src[start:l.pos], loc: loc}, nil
+}
+
+func (l *lexer) digits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos > start
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	l.pos++
+
+	var b strings.Builder
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: b.String(), loc: loc}, nil
+		case c == '\n':
+			return token{}, l.errorf(loc, "unterminated string")
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(loc, "unterminated string")
+			}
+
+			escape := l.src[l.pos+1]
+			l.pos += 2
+
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+				b.WriteRune(rune(n))
+				l.pos += 4
+			default:
+				return token{}, l.errorf(loc, "invalid escape \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+
+	return token{}, l.errorf(loc, "unterminated string")
+}
+
+// """ strings keep their line breaks, the common indentation and the blank
+// first and last lines are removed
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.pos += 3
+
+	// \""" is an escaped quote, not the end
+	end := -1
+	for i := l.pos; i+3 <= len(l.src); i++ {
+		if l.src[i] == '\\' && strings.HasPrefix(l.src[i+1:], `"""`) {
+			i += 3
+			continue
+		}
+		if strings.HasPrefix(l.src[i:], `"""`) {
+			end = i - l.pos
+			break
+		}
+	}
+
+	if end < 0 {
+		return token{}, l.errorf(loc, "unterminated string")
+	}
+
+	raw := l.src[l.pos : l.pos+end]
+
+	for _, c := range raw {
+		if c == '\n' {
+			l.line++
+		}
+	}
+	l.pos += end + 3
+	if i := strings.LastIndexByte(raw, '\n'); i >= 0 {
+		l.lineStart = l.pos - (len(raw) - i - 1) - 3
+	}
+
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if indent > 0 && len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return token{kind: tokenString, value: strings.Join(lines, "\n"), loc: loc}, nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
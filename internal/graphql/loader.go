@@ -0,0 +1,70 @@
+package graphql
+
+import "context"
+
+// BatchFunc fetches the values for many keys in one go. Keys left out of the
+// map resolve to nil
+type BatchFunc func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error)
+
+// Loader collects the keys resolvers ask for and fetches all of them with a
+// single BatchFunc call once the first of their Thunks is forced. Execute
+// resolves a whole level of the query before forcing any Thunk, so the same
+// field on a hundred siblings costs one call instead of a hundred.
+// Values are kept for the rest of the request. A Loader belongs to one
+// request and isn't safe for concurrent use, neither is Execute's use of it
+type Loader struct {
+	ctx 		context.Context
+	batch 		BatchFunc
+	queued 		[]interface{}
+	isQueued 	map[interface{}]bool
+	values 		map[interface{}]interface{}
+	errs 		map[interface{}]error
+}
+
+func NewLoader(ctx context.Context, batch BatchFunc) *Loader {
+	return &Loader{
+		ctx: ctx,
+		batch: batch,
+		isQueued: map[interface{}]bool{},
+		values: map[interface{}]interface{}{},
+		errs: map[interface{}]error{},
+	}
+}
+
+// Load queues key for the next batch unless its value is already known
+func (l *Loader) Load(key interface{}) Thunk {
+	if _, done := l.values[key]; !done && !l.isQueued[key] {
+		if _, failed := l.errs[key]; !failed {
+			l.queued = append(l.queued, key)
+			l.isQueued[key] = true
+		}
+	}
+
+	return func() (interface{}, error) {
+		if l.isQueued[key] {
+			l.dispatch()
+		}
+
+		if err, ok := l.errs[key]; ok {
+			return nil, err
+		}
+
+		return l.values[key], nil
+	}
+}
+
+func (l *Loader) dispatch() {
+	keys := l.queued
+	l.queued = nil
+	l.isQueued = map[interface{}]bool{}
+
+	values, err := l.batch(l.ctx, keys)
+
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+			continue
+		}
+		l.values[key] = values[key]
+	}
+}
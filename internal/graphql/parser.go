@@ -0,0 +1,632 @@
+package graphql
+
+type document struct {
+	operations 	[]*operation
+	fragments 	map[string]*fragment
+}
+
+type operation struct {
+	// query, mutation or subscription
+	kind 		string
+	name 		string
+	variables 	[]*variableDefinition
+	directives 	[]*directive
+	selections 	[]*selection
+	loc 		Location
+}
+
+type variableDefinition struct {
+	name 			string
+	typ 			*typeRef
+	defaultValue 	*value
+	loc 			Location
+}
+
+// a type as written in a document, [Int!]! is nonNull{list{nonNull{Int}}}
+type typeRef struct {
+	name 		string
+	list 		*typeRef
+	nonNull 	bool
+}
+
+type fragment struct {
+	name 			string
+	typeCondition 	string
+	directives 		[]*directive
+	selections 		[]*selection
+	loc 			Location
+}
+
+type selectionKind int
+
+const (
+	selectField selectionKind = iota
+	selectFragmentSpread
+	selectInlineFragment
+)
+
+// a field, a ...Spread or an inline ... on Type { }, which of the fields are
+// set depends on kind
+type selection struct {
+	kind 			selectionKind
+	alias 			string
+	name 			string
+	arguments 		[]*argument
+	directives 		[]*directive
+	selections 		[]*selection
+	typeCondition 	string
+	loc 			Location
+}
+
+// the key the field's value goes under in the response
+func (s *selection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type argument struct {
+	name 	string
+	value 	*value
+	loc 	Location
+}
+
+type directive struct {
+	name 		string
+	arguments 	[]*argument
+	loc 		Location
+}
+
+type valueKind int
+
+const (
+	valueVariable valueKind = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBoolean
+	valueNull
+	valueEnum
+	valueList
+	valueObject
+)
+
+// a literal in a document. raw holds the variable name, the number or string
+// as written, or the enum name
+type value struct {
+	kind 	valueKind
+	raw 	string
+	list 	[]*value
+	fields 	[]*argument
+	loc 	Location
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lex: newLexer(src)}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: map[string]*fragment{}}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections, loc: selections[0].loc})
+		case p.tok.kind == tokenName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, newError([]Location{f.loc}, "There can be only one fragment named %q.", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, newError(nil, "The document contains no operations.")
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+// consumes punct if it is next
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.lex.errorf(p.tok.loc, "expected %q, found %s", punct, p.describe())
+	}
+	return p.advance()
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if p.tok.kind != tokenName || p.tok.value != keyword {
+		return p.lex.errorf(p.tok.loc, "expected %q, found %s", keyword, p.describe())
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.lex.errorf(p.tok.loc, "expected a name, found %s", p.describe())
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	return p.lex.errorf(p.tok.loc, "unexpected %s", p.describe())
+}
+
+func (p *parser) describe() string {
+	switch p.tok.kind {
+	case tokenEOF:
+		return "end of document"
+	case tokenString:
+		return "string"
+	default:
+		return "\"" + p.tok.value + "\""
+	}
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	op.directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	op.selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (*variableDefinition, error) {
+	def := &variableDefinition{loc: p.tok.loc}
+
+	err := p.expect("$")
+	if err != nil {
+		return nil, err
+	}
+
+	def.name, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect(":")
+	if err != nil {
+		return nil, err
+	}
+
+	def.typ, err = p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		def.defaultValue, err = p.value(true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// directives on variable definitions are allowed and ignored
+	_, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	return def, nil
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	var t *typeRef
+
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		of, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &typeRef{list: of}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t = &typeRef{name: name}
+	}
+
+	if ok, err := p.skip("!"); err != nil {
+		return nil, err
+	} else if ok {
+		t.nonNull = true
+	}
+
+	return t, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	f := &fragment{loc: p.tok.loc}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName && p.tok.value == "on" {
+		return nil, p.unexpected()
+	}
+
+	f.name, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expectKeyword("on")
+	if err != nil {
+		return nil, err
+	}
+
+	f.typeCondition, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	f.directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	f.selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	var selections []*selection
+
+	for !p.peek("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+
+	if len(selections) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "a selection set must not be empty")
+	}
+
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (*selection, error) {
+	s := &selection{loc: p.tok.loc}
+
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		return p.fragmentSelection(s)
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		s.alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.name = name
+
+	s.arguments, err = p.arguments(false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek("{") {
+		s.selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// whatever follows a ..., either a named spread or an inline fragment
+func (p *parser) fragmentSelection(s *selection) (*selection, error) {
+	var err error
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		s.kind = selectFragmentSpread
+		s.name = p.tok.value
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		s.directives, err = p.directives()
+		return s, err
+	}
+
+	s.kind = selectInlineFragment
+
+	if p.tok.kind == tokenName {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		s.typeCondition, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	s.selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*argument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+
+	var args []*argument
+
+	for !p.peek(")") {
+		arg := &argument{loc: p.tok.loc}
+
+		var err error
+
+		arg.name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		arg.value, err = p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	if len(args) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "an argument list must not be empty")
+	}
+
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+
+	for p.peek("@") {
+		d := &directive{loc: p.tok.loc}
+
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		d.name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		d.arguments, err = p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+// constant is set where variables aren't allowed, in default values
+func (p *parser) value(constant bool) (*value, error) {
+	v := &value{loc: p.tok.loc, raw: p.tok.value}
+
+	switch p.tok.kind {
+	case tokenInt:
+		v.kind = valueInt
+	case tokenFloat:
+		v.kind = valueFloat
+	case tokenString:
+		v.kind = valueString
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = valueBoolean
+		case "null":
+			v.kind = valueNull
+		default:
+			v.kind = valueEnum
+		}
+	case tokenPunct:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			v.kind = valueVariable
+			v.raw, err = p.name()
+			return v, err
+		case "[":
+			v.kind = valueList
+
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			for !p.peek("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.list = append(v.list, item)
+			}
+
+			return v, p.advance()
+		case "{":
+			v.kind = valueObject
+
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			for !p.peek("}") {
+				field := &argument{loc: p.tok.loc}
+
+				field.name, err = p.name()
+				if err != nil {
+					return nil, err
+				}
+
+				err = p.expect(":")
+				if err != nil {
+					return nil, err
+				}
+
+				field.value, err = p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+
+				v.fields = append(v.fields, field)
+			}
+
+			return v, p.advance()
+		default:
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+
+	return v, p.advance()
+}
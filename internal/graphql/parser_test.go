@@ -0,0 +1,132 @@
+package graphql
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	doc, err := parse(`
+		# a comment
+		query Menu($id: ID! = "1", $tags: [String!]) @include(if: true) {
+			pizza(id: $id, sizes: [10, 12.5], where: {name: "Margherita", vegan: false, note: null}) {
+				...fields
+				... on Pizza { name }
+				aliased: name
+			}
+		}
+
+		fragment fields on Pizza { id, venues { name } }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.operations) != 1 || len(doc.fragments) != 1 {
+		t.Fatalf("got %d operations and %d fragments, want 1 of each", len(doc.operations), len(doc.fragments))
+	}
+
+	op := doc.operations[0]
+
+	if op.kind != "query" || op.name != "Menu" || len(op.variables) != 2 || len(op.directives) != 1 {
+		t.Fatalf("got operation %+v", op)
+	}
+
+	if id := op.variables[0]; id.name != "id" || !id.typ.nonNull || id.typ.name != "ID" || id.defaultValue.raw != "1" {
+		t.Errorf("got variable $id %+v of type %+v", id, id.typ)
+	}
+
+	if tags := op.variables[1]; tags.typ.nonNull || tags.typ.list == nil || !tags.typ.list.nonNull || tags.typ.list.name != "String" {
+		t.Errorf("got variable $tags of type %+v", tags.typ)
+	}
+
+	pizza := op.selections[0]
+
+	if pizza.name != "pizza" || len(pizza.arguments) != 3 || len(pizza.selections) != 3 {
+		t.Fatalf("got selection %+v", pizza)
+	}
+
+	if v := pizza.arguments[0].value; v.kind != valueVariable || v.raw != "id" {
+		t.Errorf("got id argument %+v", v)
+	}
+
+	if v := pizza.arguments[1].value; v.kind != valueList || v.list[0].kind != valueInt || v.list[1].kind != valueFloat {
+		t.Errorf("got sizes argument %+v", v)
+	}
+
+	if v := pizza.arguments[2].value; v.kind != valueObject || len(v.fields) != 3 || v.fields[2].value.kind != valueNull {
+		t.Errorf("got where argument %+v", v)
+	}
+
+	kinds := []selectionKind{selectFragmentSpread, selectInlineFragment, selectField}
+	for i, sel := range pizza.selections {
+		if sel.kind != kinds[i] {
+			t.Errorf("selection %d is of kind %d, want %d", i, sel.kind, kinds[i])
+		}
+	}
+
+	if aliased := pizza.selections[2]; aliased.alias != "aliased" || aliased.responseKey() != "aliased" {
+		t.Errorf("got aliased selection %+v", aliased)
+	}
+
+	if loc := pizza.loc; loc.Line != 4 || loc.Column != 4 {
+		t.Errorf("pizza is at %+v, want line 4 column 4", loc)
+	}
+}
+
+func TestParseStrings(t *testing.T) {
+	tests := []struct {
+		src 	string
+		want 	string
+	}{
+		{`"plain"`, "plain"},
+		{`"tab\tquote\"slash\\"`, "tab\tquote\"slash\\"},
+		{`"è"`, "è"},
+		{`"""
+			block
+			  indented
+		"""`, "block\n  indented"},
+	}
+
+	for _, tt := range tests {
+		doc, err := parse(`{ f(s: ` + tt.src + `) }`)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+
+		if got := doc.operations[0].selections[0].arguments[0].value.raw; got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name 	string
+		src 	string
+	}{
+		{"empty", ``},
+		{"fragments only", `fragment f on Pizza { id }`},
+		{"unclosed selection set", `{ pizza { id }`},
+		{"empty selection set", `{ }`},
+		{"unterminated string", `{ f(s: "open) }`},
+		{"bad escape", `{ f(s: "\q") }`},
+		{"number followed by a name", `{ f(n: 12abc) }`},
+		{"leading zero", `{ f(n: 012) }`},
+		{"variable in a default", `query ($a: Int = $b) { f }`},
+		{"duplicate fragment", `{ ...f } fragment f on P { a } fragment f on P { b }`},
+		{"fragment named on", `{ ...on } fragment on on P { a }`},
+		{"unknown character", `{ f ^ }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.src)
+			if err == nil {
+				t.Fatalf("parsed %q without an error", tt.src)
+			}
+
+			if _, ok := err.(*Error); !ok {
+				t.Errorf("got %T, want *Error", err)
+			}
+		})
+	}
+}
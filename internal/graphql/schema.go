@@ -0,0 +1,599 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Type is one of *Scalar, *Object, *InputObject, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize turns what a resolver returned into the
+// value written to the response, ParseValue turns an argument or variable
+// into the value resolvers see. Literals in a document are handed to
+// ParseValue as int64, float64, string or bool
+type Scalar struct {
+	Name 		string
+	Serialize 	func(v interface{}) (interface{}, error)
+	ParseValue 	func(v interface{}) (interface{}, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is an output type with fields. There are no interfaces or unions,
+// a fragment's type condition has to name the object itself
+type Object struct {
+	Name 	string
+	Fields 	Fields
+}
+
+func (o *Object) String() string { return o.Name }
+
+type Fields map[string]*Field
+
+type Field struct {
+	Type 	Type
+	Args 	Args
+	// nil reads the exported struct field with the same name, ignoring case
+	Resolve ResolveFunc
+}
+
+// the arguments of a field or the fields of an input object
+type Args map[string]*Arg
+
+type Arg struct {
+	Type 	Type
+	// used when the argument is left out, nil for no default
+	Default interface{}
+}
+
+// InputObject is an object accepted as an argument. Resolvers see it as a
+// map[string]interface{} holding the fields that were given or have a default
+type InputObject struct {
+	Name 	string
+	Fields 	Args
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ListOf is shorthand for &List{Of: t}
+func ListOf(t Type) *List { return &List{Of: t} }
+
+// NonNullOf is shorthand for &NonNull{Of: t}
+func NonNullOf(t Type) *NonNull { return &NonNull{Of: t} }
+
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+type ResolveParams struct {
+	Context 	context.Context
+	// the value the parent field resolved to, nil for root fields
+	Source 		interface{}
+	Args 		map[string]interface{}
+}
+
+// Thunk is a field value that isn't known yet, see Loader
+type Thunk func() (interface{}, error)
+
+// Schema holds the root types and every type reachable from them
+type Schema struct {
+	query 		*Object
+	mutation 	*Object
+	types 		map[string]Type
+	limits 		Limits
+}
+
+// Limits bound the work a single document can ask for. They are checked with
+// the rest of validation, before any resolver runs. Zero means no limit
+type Limits struct {
+	// how deeply fields may nest, a root field is at depth 1
+	MaxDepth 	int
+	// the most fields a document may resolve. Every field costs 1, the fields
+	// under a list count once for each item the list is assumed to hold
+	MaxCost 	int
+	// the items a list field is assumed to hold when costing, 1 if unset
+	ListSize 	int
+}
+
+// SetLimits replaces the schema's limits, call it before the schema is used
+func (s *Schema) SetLimits(l Limits) {
+	s.limits = l
+}
+
+// NewSchema checks the types reachable from query and mutation have unique
+// names, mutation may be nil
+func NewSchema(query, mutation *Object) (*Schema, error) {
+	s := &Schema{query: query, mutation: mutation, types: map[string]Type{}}
+
+	for _, scalar := range []*Scalar{Int, Float, String, Boolean, ID} {
+		s.types[scalar.Name] = scalar
+	}
+
+	roots := []Type{query}
+	if mutation != nil {
+		roots = append(roots, mutation)
+	}
+
+	for _, root := range roots {
+		err := s.addType(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Schema) addType(t Type) error {
+	switch t := t.(type) {
+	case *List:
+		return s.addType(t.Of)
+	case *NonNull:
+		return s.addType(t.Of)
+	}
+
+	if existing, ok := s.types[t.String()]; ok {
+		if existing != t {
+			return fmt.Errorf("graphql: two types are named %s", t)
+		}
+		return nil
+	}
+
+	s.types[t.String()] = t
+
+	switch t := t.(type) {
+	case *Object:
+		for name, field := range t.Fields {
+			if !isOutputType(field.Type) {
+				return fmt.Errorf("graphql: %s.%s is not an output type", t.Name, name)
+			}
+
+			err := s.addType(field.Type)
+			if err != nil {
+				return err
+			}
+
+			err = s.addArgs(field.Args)
+			if err != nil {
+				return err
+			}
+		}
+	case *InputObject:
+		return s.addArgs(t.Fields)
+	}
+
+	return nil
+}
+
+func (s *Schema) addArgs(args Args) error {
+	for name, arg := range args {
+		if !isInputType(arg.Type) {
+			return fmt.Errorf("graphql: argument %s is not an input type", name)
+		}
+
+		err := s.addType(arg.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func namedType(t Type) Type {
+	for {
+		switch wrapped := t.(type) {
+		case *List:
+			t = wrapped.Of
+		case *NonNull:
+			t = wrapped.Of
+		default:
+			return t
+		}
+	}
+}
+
+func isInputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *InputObject:
+		return true
+	default:
+		return false
+	}
+}
+
+func isOutputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *Object:
+		return true
+	default:
+		return false
+	}
+}
+
+// the schema type a variable definition names
+func (s *Schema) resolveTypeRef(ref *typeRef) (Type, bool) {
+	var t Type
+
+	if ref.list != nil {
+		of, ok := s.resolveTypeRef(ref.list)
+		if !ok {
+			return nil, false
+		}
+		t = ListOf(of)
+	} else {
+		named, ok := s.types[ref.name]
+		if !ok {
+			return nil, false
+		}
+		t = named
+	}
+
+	if ref.nonNull {
+		t = NonNullOf(t)
+	}
+
+	return t, true
+}
+
+// the built-in scalars, ID is serialized as a string but accepts integers too
+
+var Int = &Scalar{
+	Name: "Int",
+	Serialize: func(v interface{}) (interface{}, error) {
+		n, ok := toInt64(v)
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent %v", v)
+		}
+		return n, nil
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		n, ok := toInt64(v)
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent %v", describe(v))
+		}
+		return int(n), nil
+	},
+}
+
+var Float = &Scalar{
+	Name: "Float",
+	Serialize: func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case float32:
+			// through the float32 formatting so 4.2 doesn't come out as 4.199999809265137
+			f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+			return f, nil
+		case float64:
+			return v, nil
+		}
+		if n, ok := toInt64(v); ok {
+			return float64(n), nil
+		}
+		return nil, fmt.Errorf("Float cannot represent %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+		return nil, fmt.Errorf("Float cannot represent %v", describe(v))
+	},
+}
+
+var String = &Scalar{
+	Name: "String",
+	Serialize: func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("String cannot represent %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("String cannot represent %v", describe(v))
+	},
+}
+
+var Boolean = &Scalar{
+	Name: "Boolean",
+	Serialize: func(v interface{}) (interface{}, error) {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent %v", describe(v))
+	},
+}
+
+var ID = &Scalar{
+	Name: "ID",
+	Serialize: func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if n, ok := toInt64(v); ok {
+			return strconv.FormatInt(n, 10), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if n, ok := toInt64(v); ok {
+			return strconv.FormatInt(n, 10), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent %v", describe(v))
+	},
+}
+
+// whole numbers only, variables decoded from JSON arrive as float64
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return 0, false
+		}
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// an enum literal, none of the scalars accept one
+type enumLiteral string
+
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case enumLiteral:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// coerces a variable's JSON value to t
+func coerceInput(v interface{}, t Type) (interface{}, error) {
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected a non-null %s", nn.Of)
+		}
+		return coerceInput(v, nn.Of)
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			// a single value stands in for a list of one
+			item, err := coerceInput(v, t.Of)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			out[i], err = coerceInput(item, t.Of)
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+		}
+		return out, nil
+	case *InputObject:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object for %s", t.Name)
+		}
+
+		for name := range fields {
+			if _, ok := t.Fields[name]; !ok {
+				return nil, fmt.Errorf("field %q is not defined by %s", name, t.Name)
+			}
+		}
+
+		out := map[string]interface{}{}
+		for name, def := range t.Fields {
+			field, ok := fields[name]
+			if !ok {
+				if def.Default != nil {
+					out[name] = def.Default
+				} else if _, required := def.Type.(*NonNull); required {
+					return nil, fmt.Errorf("field %s.%s of type %s is required", t.Name, name, def.Type)
+				}
+				continue
+			}
+
+			value, err := coerceInput(field, def.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %s.%s: %w", t.Name, name, err)
+			}
+			out[name] = value
+		}
+		return out, nil
+	case *Scalar:
+		return t.ParseValue(v)
+	default:
+		return nil, fmt.Errorf("%s is not an input type", t)
+	}
+}
+
+// coerces a literal from the document to t, variables have already been coerced.
+// A variable the request didn't provide counts as a missing value, ok is false
+func coerceLiteral(v *value, t Type, variables map[string]interface{}) (result interface{}, ok bool, err error) {
+	if v.kind == valueVariable {
+		value, ok := variables[v.raw]
+		if !ok {
+			return nil, false, nil
+		}
+		if _, nonNull := t.(*NonNull); nonNull && value == nil {
+			return nil, true, fmt.Errorf("variable $%s must not be null", v.raw)
+		}
+		return value, true, nil
+	}
+
+	if nn, nonNull := t.(*NonNull); nonNull {
+		if v.kind == valueNull {
+			return nil, true, fmt.Errorf("expected a non-null %s", nn.Of)
+		}
+		return coerceLiteral(v, nn.Of, variables)
+	}
+
+	if v.kind == valueNull {
+		return nil, true, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if v.kind != valueList {
+			item, _, err := coerceLiteral(v, t.Of, variables)
+			if err != nil {
+				return nil, true, err
+			}
+			return []interface{}{item}, true, nil
+		}
+
+		out := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			value, ok, err := coerceLiteral(item, t.Of, variables)
+			if err != nil {
+				return nil, true, fmt.Errorf("at index %d: %w", i, err)
+			}
+			if !ok {
+				if _, nonNull := t.Of.(*NonNull); nonNull {
+					return nil, true, fmt.Errorf("at index %d: variable $%s was not provided", i, item.raw)
+				}
+			}
+			out[i] = value
+		}
+		return out, true, nil
+	case *InputObject:
+		if v.kind != valueObject {
+			return nil, true, fmt.Errorf("expected an object for %s", t.Name)
+		}
+
+		given := map[string]*value{}
+		for _, field := range v.fields {
+			if _, ok := t.Fields[field.name]; !ok {
+				return nil, true, fmt.Errorf("field %q is not defined by %s", field.name, t.Name)
+			}
+			given[field.name] = field.value
+		}
+
+		out, err := coerceArgs(t.Fields, given, variables)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %w", t.Name, err)
+		}
+		return out, true, nil
+	case *Scalar:
+		literal, err := literalValue(v)
+		if err != nil {
+			return nil, true, err
+		}
+		value, err := t.ParseValue(literal)
+		return value, true, err
+	default:
+		return nil, true, fmt.Errorf("%s is not an input type", t)
+	}
+}
+
+func literalValue(v *value) (interface{}, error) {
+	switch v.kind {
+	case valueInt:
+		n, err := strconv.ParseInt(v.raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is out of range", v.raw)
+		}
+		return n, nil
+	case valueFloat:
+		return strconv.ParseFloat(v.raw, 64)
+	case valueString:
+		return v.raw, nil
+	case valueBoolean:
+		return v.raw == "true", nil
+	case valueEnum:
+		return enumLiteral(v.raw), nil
+	default:
+		return nil, fmt.Errorf("a scalar cannot be a list or an object")
+	}
+}
+
+// the argument values for defs, leaving out any that weren't given and have no default
+func coerceArgs(defs Args, given map[string]*value, variables map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+
+	for name, def := range defs {
+		v, present := given[name]
+
+		if present {
+			value, ok, err := coerceLiteral(v, def.Type, variables)
+			if err != nil {
+				return nil, fmt.Errorf("argument %q: %w", name, err)
+			}
+			if ok {
+				out[name] = value
+				continue
+			}
+		}
+
+		if def.Default != nil {
+			out[name] = def.Default
+			continue
+		}
+
+		if _, required := def.Type.(*NonNull); required {
+			return nil, fmt.Errorf("argument %q of type %s is required", name, def.Type)
+		}
+	}
+
+	return out, nil
+}
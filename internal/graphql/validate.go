@@ -0,0 +1,387 @@
+package graphql
+
+import (
+	"math"
+	"sort"
+)
+
+// checks a document against the schema before anything runs: fields and
+// arguments exist, required arguments are given, leaf fields have no
+// selections and objects do, fragments and variables are defined and used,
+// and each operation stays within the schema's Limits. Argument values are
+// only checked when the field executes
+func validate(s *Schema, doc *document) []*Error {
+	v := &validator{schema: s, doc: doc, usedFragments: map[string]bool{}}
+
+	names := map[string]bool{}
+
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			v.errorf(op.loc, "This anonymous operation must be the only defined operation.")
+		}
+
+		if op.name != "" {
+			if names[op.name] {
+				v.errorf(op.loc, "There can be only one operation named %q.", op.name)
+			}
+			names[op.name] = true
+		}
+
+		v.operation(op)
+	}
+
+	// measuring follows fragments, which is only safe once they are known to
+	// exist and not to spread within themselves
+	if len(v.errors) == 0 {
+		for _, op := range doc.operations {
+			v.limits(op)
+		}
+	}
+
+	var unused []string
+	for name := range doc.fragments {
+		if !v.usedFragments[name] {
+			unused = append(unused, name)
+		}
+	}
+
+	sort.Strings(unused)
+
+	for _, name := range unused {
+		v.errorf(doc.fragments[name].loc, "Fragment %q is never used.", name)
+	}
+
+	return v.errors
+}
+
+type validator struct {
+	schema 			*Schema
+	doc 			*document
+	errors 			[]*Error
+	usedFragments 	map[string]bool
+	// the variables the current operation uses
+	usedVariables 	map[string]Location
+}
+
+func (v *validator) errorf(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, newError([]Location{loc}, format, args...))
+}
+
+func (v *validator) operation(op *operation) {
+	var root *Object
+
+	switch op.kind {
+	case "query":
+		root = v.schema.query
+	case "mutation":
+		root = v.schema.mutation
+		if root == nil {
+			v.errorf(op.loc, "Schema is not configured for mutations.")
+			return
+		}
+	default:
+		v.errorf(op.loc, "Subscriptions are not supported.")
+		return
+	}
+
+	v.usedVariables = map[string]Location{}
+
+	defined := map[string]bool{}
+
+	for _, def := range op.variables {
+		if defined[def.name] {
+			v.errorf(def.loc, "There can be only one variable named $%s.", def.name)
+		}
+		defined[def.name] = true
+
+		t, ok := v.schema.resolveTypeRef(def.typ)
+		switch {
+		case !ok:
+			v.errorf(def.loc, "Variable $%s has an unknown type.", def.name)
+		case !isInputType(t):
+			v.errorf(def.loc, "Variable $%s cannot be of non-input type %s.", def.name, t)
+		}
+	}
+
+	v.directives(op.directives)
+	v.selections(root, op.selections, map[string]bool{})
+
+	var undefined []string
+	for name := range v.usedVariables {
+		if !defined[name] {
+			undefined = append(undefined, name)
+		}
+	}
+
+	sort.Strings(undefined)
+
+	for _, name := range undefined {
+		v.errorf(v.usedVariables[name], "Variable $%s is not defined.", name)
+	}
+
+	for _, def := range op.variables {
+		if _, ok := v.usedVariables[def.name]; !ok {
+			v.errorf(def.loc, "Variable $%s is never used.", def.name)
+		}
+	}
+}
+
+// spreading is the fragments on the current path, a fragment inside itself is a cycle
+func (v *validator) selections(obj *Object, selections []*selection, spreading map[string]bool) {
+	for _, sel := range selections {
+		v.directives(sel.directives)
+
+		switch sel.kind {
+		case selectField:
+			v.field(obj, sel, spreading)
+		case selectInlineFragment:
+			if sel.typeCondition != "" && sel.typeCondition != obj.Name {
+				v.typeCondition(sel.loc, sel.typeCondition, obj)
+			}
+			v.selections(obj, sel.selections, spreading)
+		case selectFragmentSpread:
+			f, ok := v.doc.fragments[sel.name]
+			if !ok {
+				v.errorf(sel.loc, "Unknown fragment %q.", sel.name)
+				continue
+			}
+
+			v.usedFragments[sel.name] = true
+
+			if spreading[sel.name] {
+				v.errorf(sel.loc, "Cannot spread fragment %q within itself.", sel.name)
+				continue
+			}
+
+			if f.typeCondition != obj.Name {
+				v.typeCondition(sel.loc, f.typeCondition, obj)
+				continue
+			}
+
+			spreading[sel.name] = true
+			v.directives(f.directives)
+			v.selections(obj, f.selections, spreading)
+			delete(spreading, sel.name)
+		}
+	}
+}
+
+func (v *validator) typeCondition(loc Location, name string, obj *Object) {
+	if _, ok := v.schema.types[name]; !ok {
+		v.errorf(loc, "Unknown type %q.", name)
+		return
+	}
+	v.errorf(loc, "A fragment on %q cannot be spread within %q.", name, obj.Name)
+}
+
+func (v *validator) field(obj *Object, sel *selection, spreading map[string]bool) {
+	if sel.name == "__typename" {
+		if len(sel.arguments) > 0 || len(sel.selections) > 0 {
+			v.errorf(sel.loc, "Field \"__typename\" takes no arguments or selections.")
+		}
+		return
+	}
+
+	field, ok := obj.Fields[sel.name]
+	if !ok {
+		v.errorf(sel.loc, "Cannot query field %q on type %q.", sel.name, obj.Name)
+		return
+	}
+
+	v.arguments(sel.loc, field.Args, sel.arguments, "field \""+sel.name+"\"")
+
+	switch t := namedType(field.Type).(type) {
+	case *Object:
+		if len(sel.selections) == 0 {
+			v.errorf(sel.loc, "Field %q of type %q must have a selection of subfields.", sel.name, field.Type)
+			return
+		}
+		v.selections(t, sel.selections, spreading)
+	default:
+		if len(sel.selections) > 0 {
+			v.errorf(sel.loc, "Field %q must not have a selection since type %q has no subfields.", sel.name, field.Type)
+		}
+	}
+}
+
+func (v *validator) arguments(loc Location, defs Args, args []*argument, of string) {
+	given := map[string]bool{}
+
+	for _, arg := range args {
+		if given[arg.name] {
+			v.errorf(arg.loc, "There can be only one argument named %q.", arg.name)
+		}
+		given[arg.name] = true
+
+		if _, ok := defs[arg.name]; !ok {
+			v.errorf(arg.loc, "Unknown argument %q on %s.", arg.name, of)
+		}
+
+		v.variablesIn(arg.value)
+	}
+
+	var missing []string
+	for name, def := range defs {
+		if _, required := def.Type.(*NonNull); required && def.Default == nil && !given[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	sort.Strings(missing)
+
+	for _, name := range missing {
+		v.errorf(loc, "Argument %q of type %q is required on %s.", name, defs[name].Type, of)
+	}
+}
+
+func (v *validator) directives(directives []*directive) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			v.errorf(d.loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		v.arguments(d.loc, Args{"if": {Type: NonNullOf(Boolean)}}, d.arguments, "directive \"@"+d.name+"\"")
+	}
+}
+
+func (v *validator) variablesIn(val *value) {
+	switch val.kind {
+	case valueVariable:
+		if _, ok := v.usedVariables[val.raw]; !ok {
+			v.usedVariables[val.raw] = val.loc
+		}
+	case valueList:
+		for _, item := range val.list {
+			v.variablesIn(item)
+		}
+	case valueObject:
+		for _, field := range val.fields {
+			v.variablesIn(field.value)
+		}
+	}
+}
+
+func (v *validator) limits(op *operation) {
+	limits := v.schema.limits
+
+	if limits.MaxDepth == 0 && limits.MaxCost == 0 {
+		return
+	}
+
+	root := v.schema.query
+	if op.kind == "mutation" {
+		root = v.schema.mutation
+	}
+
+	m := &measure{doc: v.doc, listSize: limits.ListSize, fragments: map[string]measured{}}
+	if m.listSize < 1 {
+		m.listSize = 1
+	}
+
+	size := m.selections(root, op.selections)
+
+	if limits.MaxDepth > 0 && size.depth > limits.MaxDepth {
+		v.errorf(op.loc, "The operation is nested %d levels deep, the limit is %d.", size.depth, limits.MaxDepth)
+	}
+
+	if limits.MaxCost > 0 && size.cost > limits.MaxCost {
+		v.errorf(op.loc, "The operation costs %d, the limit is %d.", size.cost, limits.MaxCost)
+	}
+}
+
+// how deep a selection set nests and what it costs, see Limits
+type measured struct {
+	depth 	int
+	cost 	int
+}
+
+// costs stop growing here instead of overflowing, it is above any sane limit
+const maxMeasuredCost = math.MaxInt32
+
+type measure struct {
+	doc 		*document
+	listSize 	int
+	// a fragment is measured once however often it is spread, so a document
+	// spreading fragments within fragments can't make measuring itself expensive
+	fragments 	map[string]measured
+}
+
+// directives are ignored, a field skipped by a variable still counts
+func (m *measure) selections(obj *Object, selections []*selection) measured {
+	var total measured
+
+	for _, sel := range selections {
+		var size measured
+
+		switch sel.kind {
+		case selectField:
+			size = m.field(obj, sel)
+		case selectInlineFragment:
+			size = m.selections(obj, sel.selections)
+		case selectFragmentSpread:
+			var ok bool
+			size, ok = m.fragments[sel.name]
+			if !ok {
+				size = m.selections(obj, m.doc.fragments[sel.name].selections)
+				m.fragments[sel.name] = size
+			}
+		}
+
+		if size.depth > total.depth {
+			total.depth = size.depth
+		}
+		total.cost = addCost(total.cost, size.cost)
+	}
+
+	return total
+}
+
+func (m *measure) field(obj *Object, sel *selection) measured {
+	size := measured{depth: 1, cost: 1}
+
+	if sel.name == "__typename" {
+		return size
+	}
+
+	field := obj.Fields[sel.name]
+
+	child, ok := namedType(field.Type).(*Object)
+	if !ok {
+		return size
+	}
+
+	sub := m.selections(child, sel.selections)
+
+	// a list of lists holds listSize items listSize times over
+	for t := field.Type; ; {
+		switch wrapped := t.(type) {
+		case *NonNull:
+			t = wrapped.Of
+			continue
+		case *List:
+			sub.cost = mulCost(sub.cost, m.listSize)
+			t = wrapped.Of
+			continue
+		}
+		break
+	}
+
+	size.depth += sub.depth
+	size.cost = addCost(size.cost, sub.cost)
+
+	return size
+}
+
+func addCost(a, b int) int {
+	if a > maxMeasuredCost-b {
+		return maxMeasuredCost
+	}
+	return a + b
+}
+
+func mulCost(a, b int) int {
+	if b != 0 && a > maxMeasuredCost/b {
+		return maxMeasuredCost
+	}
+	return a * b
+}
@@ -0,0 +1,156 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+// parses and validates query against s and returns the messages of the errors
+func validateQuery(t *testing.T, s *Schema, query string) []string {
+	t.Helper()
+
+	doc, err := parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, err := range validate(s, doc) {
+		messages = append(messages, err.Message)
+	}
+
+	return messages
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name 	string
+		query 	string
+		want 	[]string
+	}{
+		{
+			name: "valid",
+			query: `query ($id: ID!) { pizza(id: $id) { ...f } } fragment f on Pizza { name venues { id } }`,
+		},
+		{
+			name: "unknown field",
+			query: `{ pizzas { price } }`,
+			want: []string{`Cannot query field "price" on type "Pizza".`},
+		},
+		{
+			name: "missing required argument",
+			query: `{ pizza { name } }`,
+			want: []string{`Argument "id" of type "ID!" is required on field "pizza".`},
+		},
+		{
+			name: "object without a selection",
+			query: `{ pizzas }`,
+			want: []string{`Field "pizzas" of type "[Pizza!]!" must have a selection of subfields.`},
+		},
+		{
+			name: "leaf with a selection",
+			query: `{ greeting { length } }`,
+			want: []string{`Field "greeting" must not have a selection since type "String!" has no subfields.`},
+		},
+		{
+			name: "unused fragment",
+			query: `{ greeting } fragment f on Pizza { name }`,
+			want: []string{`Fragment "f" is never used.`},
+		},
+		{
+			name: "fragment cycle",
+			query: `{ pizzas { ...a } } fragment a on Pizza { ...b } fragment b on Pizza { ...a }`,
+			want: []string{`Cannot spread fragment "a" within itself.`},
+		},
+		{
+			name: "undefined and unused variables",
+			query: `query ($unused: Int) { pizza(id: $id) { name } }`,
+			want: []string{`Variable $id is not defined.`, `Variable $unused is never used.`},
+		},
+		{
+			name: "two anonymous operations",
+			query: `{ greeting } { greeting }`,
+			want: []string{`This anonymous operation must be the only defined operation.`, `This anonymous operation must be the only defined operation.`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateQuery(t, testSchema(t), tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	// 3 levels deep, costing 1 for pizzas, 10 for a venues list on each of
+	// the 10 pizzas and 100 for a name on each of the 10 venues of those
+	const query = `{ pizzas { venues { name } } }`
+
+	tests := []struct {
+		name 	string
+		limits 	Limits
+		query 	string
+		want 	[]string
+	}{
+		{
+			name: "no limits",
+			query: query,
+		},
+		{
+			name: "within the limits",
+			limits: Limits{MaxDepth: 3, MaxCost: 111, ListSize: 10},
+			query: query,
+		},
+		{
+			name: "too deep",
+			limits: Limits{MaxDepth: 2},
+			query: query,
+			want: []string{"The operation is nested 3 levels deep, the limit is 2."},
+		},
+		{
+			name: "too expensive",
+			limits: Limits{MaxCost: 110, ListSize: 10},
+			query: query,
+			want: []string{"The operation costs 111, the limit is 110."},
+		},
+		{
+			name: "lists count once without a list size",
+			limits: Limits{MaxCost: 3},
+			query: query,
+		},
+		{
+			name: "fragments count where they are spread",
+			limits: Limits{MaxDepth: 2},
+			query: `{ pizzas { ...venues } } fragment venues on Pizza { venues { id } }`,
+			want: []string{"The operation is nested 3 levels deep, the limit is 2."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSchema(t)
+			s.SetLimits(tt.limits)
+
+			got := validateQuery(t, s, tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateLimitsSaturate(t *testing.T) {
+	s := testSchema(t)
+	s.SetLimits(Limits{MaxCost: maxMeasuredCost - 1, ListSize: maxMeasuredCost})
+
+	// venues on every pizza would cost far more than an int can hold
+	got := validateQuery(t, s, `{ pizzas { venues { id } } }`)
+
+	want := []string{"The operation costs 2147483647, the limit is 2147483646."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
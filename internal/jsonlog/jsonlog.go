@@ -1,10 +1,14 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,7 +16,9 @@ import (
 type Level int8
 
 const (
-	LevelInfo	Level = iota
+	LevelDebug	Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,8 +26,12 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -31,23 +41,79 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel accepts the level names in any case, plus "off"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	case "FATAL":
+		return LevelFatal, nil
+	case "OFF":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
 type Logger struct {
 	out 		io.Writer
 	minLevel 	Level
+	stackTraces	bool
+	sampler		*sampler
 	mu 			sync.Mutex
 }
 
-func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
+type Option func(*Logger)
+
+// WithStackTraces turns the stack trace attached to ERROR and FATAL entries on or off, it is on by default
+func WithStackTraces(enabled bool) Option {
+	return func(l *Logger) {
+		l.stackTraces = enabled
+	}
+}
+
+// WithSampling caps how often the same DEBUG or INFO message is written. Within each
+// tick the first entries of a message are written, after that only every thereafter-th.
+// WARN and above are never sampled
+func WithSampling(tick time.Duration, first, thereafter int) Option {
+	return func(l *Logger) {
+		l.sampler = newSampler(tick, first, thereafter)
+	}
+}
+
+// New writes to out, pass an io.MultiWriter to fan out to several sinks
+func New(out io.Writer, minLevel Level, opts ...Option) *Logger {
+	l := &Logger{
 		out: out,
 		minLevel: minLevel,
+		stackTraces: true,
+	}
+
+	for _, opt := range opts {
+		opt(l)
 	}
+
+	return l
+}
+
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
 }
 
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
 
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+	l.print(LevelWarn, message, properties)
+}
+
 func (l *Logger) PrintError(err error, properties map[string]string) {
 	l.print(LevelError, err.Error(), properties)
 }
@@ -57,11 +123,35 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 	os.Exit(1) // FATAL level
 }
 
+// PrintDebugContext is PrintDebug plus any properties stored in ctx with WithProperty
+func (l *Logger) PrintDebugContext(ctx context.Context, message string, properties map[string]string) {
+	l.print(LevelDebug, message, mergeProperties(ctx, properties))
+}
+
+// PrintInfoContext is PrintInfo plus any properties stored in ctx with WithProperty
+func (l *Logger) PrintInfoContext(ctx context.Context, message string, properties map[string]string) {
+	l.print(LevelInfo, message, mergeProperties(ctx, properties))
+}
+
+// PrintWarnContext is PrintWarn plus any properties stored in ctx with WithProperty
+func (l *Logger) PrintWarnContext(ctx context.Context, message string, properties map[string]string) {
+	l.print(LevelWarn, message, mergeProperties(ctx, properties))
+}
+
+// PrintErrorContext is PrintError plus any properties stored in ctx with WithProperty
+func (l *Logger) PrintErrorContext(ctx context.Context, err error, properties map[string]string) {
+	l.print(LevelError, err.Error(), mergeProperties(ctx, properties))
+}
+
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
 	if level < l.minLevel {
 		return 0, nil
 	}
 
+	if level <= LevelInfo && l.sampler != nil && !l.sampler.allow(level, message) {
+		return 0, nil
+	}
+
 	aux := struct {
 		Level 		string 				`json:"level"`
 		Time 		string 				`json:"time"`
@@ -75,7 +165,7 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Properties:	properties,
 	}
 	// stack trace for entries at the error and fatal levels
-	if level >= LevelError {
+	if level >= LevelError && l.stackTraces {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -93,6 +183,106 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	return l.out.Write(append(line, '\n'))
 }
 
+// Write lets the logger back a standard library *log.Logger, e.g. http.Server.ErrorLog,
+// everything written through it is logged at the error level
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelError, string(message), nil)
+}
+
+// counts messages per tick, the counts are thrown away each tick so a flood of
+// distinct messages can't grow the map forever
+type sampler struct {
+	tick		time.Duration
+	first		uint64
+	thereafter	uint64
+	mu			sync.Mutex
+	resetAt		time.Time
+	counts		map[string]uint64
+}
+
+func newSampler(tick time.Duration, first, thereafter int) *sampler {
+	return &sampler{
+		tick: tick,
+		first: uint64(first),
+		thereafter: uint64(thereafter),
+		counts: make(map[string]uint64),
+	}
+}
+
+func (s *sampler) allow(level Level, message string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.After(s.resetAt) {
+		s.counts = make(map[string]uint64)
+		s.resetAt = now.Add(s.tick)
+	}
+
+	key := level.String() + message
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.first {
+		return true
+	}
+
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+type contextKey struct{}
+
+// the property request ids are stored under
+const RequestIDProperty = "request_id"
+
+// WithProperty returns a copy of ctx carrying key=value, every *Context print
+// call made with it (or a context derived from it) includes the property
+func WithProperty(ctx context.Context, key, value string) context.Context {
+	existing := Properties(ctx)
+
+	properties := make(map[string]string, len(existing)+1)
+	for k, v := range existing {
+		properties[k] = v
+	}
+	properties[key] = value
+
+	return context.WithValue(ctx, contextKey{}, properties)
+}
+
+// Properties returns the properties stored in ctx, callers must not modify the map
+func Properties(ctx context.Context) map[string]string {
+	properties, _ := ctx.Value(contextKey{}).(map[string]string)
+	return properties
+}
+
+// RequestID returns the request id stored in ctx, or "" if there isn't one
+func RequestID(ctx context.Context) string {
+	return Properties(ctx)[RequestIDProperty]
+}
+
+var requestIDRx = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ValidRequestID reports whether id is a request id safe to log, echo back
+// in a header or put in a SQL comment: 1 to 64 letters, digits, '.', '_' or '-'
+func ValidRequestID(id string) bool {
+	return requestIDRx.MatchString(id)
+}
+
+// properties passed to the print call win over ones from the context
+func mergeProperties(ctx context.Context, properties map[string]string) map[string]string {
+	fromCtx := Properties(ctx)
+	if len(fromCtx) == 0 {
+		return properties
+	}
+
+	merged := make(map[string]string, len(fromCtx)+len(properties))
+	for k, v := range fromCtx {
+		merged[k] = v
+	}
+	for k, v := range properties {
+		merged[k] = v
+	}
+
+	return merged
 }
\ No newline at end of file
@@ -0,0 +1,28 @@
+package jsonlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id 		string
+		want 	bool
+	}{
+		{"4f2a9c0e1b7d4e8f9a6b3c2d1e0f9a8b", true},
+		{"edge-01.req_7", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"", false},
+		{"a b", false},
+		{"x' */ DROP TABLE venues; --", false},
+		{"line\nbreak", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("%q: got %t, want %t", tt.id, got, tt.want)
+		}
+	}
+}
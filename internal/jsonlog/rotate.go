@@ -0,0 +1,126 @@
+package jsonlog
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser sink that starts a new file once the current
+// one would grow past maxSize bytes. Rotated files are renamed to
+// <path>.<timestamp> and deleted once they are older than maxAge, a zero maxAge keeps them forever
+type RotatingFile struct {
+	path	string
+	maxSize	int64
+	maxAge	time.Duration
+	mu		sync.Mutex
+	file	*os.File
+	size	int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path: path,
+		maxSize: maxSize,
+		maxAge: maxAge,
+	}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	// a single line bigger than maxSize still gets written, just on its own file
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		// on failure the line goes to the current file, the next write tries again
+		rf.rotate()
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.file.Close()
+}
+
+// appends to an existing log rather than truncating it, a restart shouldn't lose lines
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// the current file is closed only once its replacement is open, whatever step
+// fails rf keeps writing to it. When it was deleted from under us there is
+// nothing to rename, a new file at path is all that's needed
+func (rf *RotatingFile) rotate() error {
+	current := rf.file
+	rotated := rf.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+
+	err := os.Rename(rf.path, rotated)
+	renamed := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = rf.open()
+	if err != nil {
+		if renamed {
+			os.Rename(rotated, rf.path)
+		}
+		return err
+	}
+
+	current.Close()
+	rf.removeExpired()
+	return nil
+}
+
+// best effort, a backup we fail to delete is retried on the next rotation
+func (rf *RotatingFile) removeExpired() {
+	if rf.maxAge <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-rf.maxAge)
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		os.Remove(match)
+	}
+}
@@ -0,0 +1,87 @@
+package jsonlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+
+	rf, err := NewRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"12345678\n", "abcdefgh\n"} {
+		_, err := rf.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "abcdefgh\n" {
+		t.Errorf("got %q in the current file, want only the second line", b)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 {
+		t.Errorf("got rotated files %v, want 1", rotated)
+	}
+}
+
+func TestRotatingFileSurvivesFailedRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+
+	rf, err := NewRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	_, err = rf.Write([]byte("12345678\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the directory gone there is nowhere to open a new file
+	err = os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := rf.Write([]byte("abcdefgh\n"))
+		if err != nil {
+			t.Fatalf("write %d after a failed rotation: %v", i+1, err)
+		}
+	}
+
+	// and once the directory is back the next write rotates to a new file
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rf.Write([]byte("ijklmnop\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "ijklmnop\n" {
+		t.Errorf("got %q, want the line written after the directory came back", b)
+	}
+}
@@ -6,4 +6,6 @@ ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_sauciness_check;
 
 ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_saltiness_check;
 
-ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_charness_check;
\ No newline at end of file
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_charness_check;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_spiciness_check;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+ALTER TABLE images DROP COLUMN IF EXISTS status;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN status text NOT NULL DEFAULT 'ready';
+
+ALTER TABLE images ADD CONSTRAINT images_status_check CHECK (status IN ('pending', 'ready'));
\ No newline at end of file
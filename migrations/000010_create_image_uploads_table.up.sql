@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS image_uploads (
+	image_id bigint PRIMARY KEY,
+	upload_length bigint NOT NULL,
+	upload_offset bigint NOT NULL DEFAULT 0,
+	created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+	CONSTRAINT image_fk
+		FOREIGN KEY (image_id)
+			REFERENCES images(id)
+			ON DELETE CASCADE,
+	CONSTRAINT image_uploads_offset_check CHECK (upload_offset BETWEEN 0 AND upload_length)
+);
\ No newline at end of file
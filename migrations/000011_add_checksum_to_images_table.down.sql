@@ -0,0 +1 @@
+ALTER TABLE images DROP COLUMN IF EXISTS checksum;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS review_images;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS review_images (
+	review_id bigint NOT NULL,
+	image_id bigint NOT NULL,
+	position int NOT NULL,
+	caption text NOT NULL DEFAULT '',
+	PRIMARY KEY (review_id, image_id),
+	CONSTRAINT review_images_position_key UNIQUE (review_id, position),
+	CONSTRAINT review_fk
+		FOREIGN KEY (review_id) REFERENCES reviews(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	CONSTRAINT image_fk
+		FOREIGN KEY (image_id) REFERENCES images(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO review_images (review_id, image_id, position)
+SELECT id, image_id, 0 FROM reviews WHERE image_id IS NOT NULL
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS permissions (
+	id bigserial PRIMARY KEY,
+	code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+	user_id bigint NOT NULL,
+	permission_id bigint NOT NULL,
+	PRIMARY KEY (user_id, permission_id),
+	CONSTRAINT user_fk
+		FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	CONSTRAINT permission_fk
+		FOREIGN KEY (permission_id) REFERENCES permissions(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO permissions (code)
+VALUES
+	('reviews:read'),
+	('reviews:write'),
+	('venues:write'),
+	('admin')
+ON CONFLICT DO NOTHING;
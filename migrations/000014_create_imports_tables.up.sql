@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+	id bigserial PRIMARY KEY,
+	format text NOT NULL,
+	status text NOT NULL DEFAULT 'pending',
+	total_rows int NOT NULL DEFAULT 0,
+	imported_rows int NOT NULL DEFAULT 0,
+	failed_rows int NOT NULL DEFAULT 0,
+	created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+	finished_at timestamp(0) with time zone
+);
+
+CREATE TABLE IF NOT EXISTS import_errors (
+	id bigserial PRIMARY KEY,
+	job_id bigint NOT NULL,
+	line int NOT NULL,
+	field text NOT NULL DEFAULT '',
+	message text NOT NULL,
+	CONSTRAINT job_fk
+		FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS import_errors_job_id_idx ON import_errors (job_id, line);
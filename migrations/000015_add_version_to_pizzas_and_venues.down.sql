@@ -0,0 +1,3 @@
+ALTER TABLE pizzas DROP COLUMN IF EXISTS version;
+
+ALTER TABLE venues DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+ALTER TABLE pizzas ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE venues ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;